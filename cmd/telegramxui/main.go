@@ -58,6 +58,19 @@ func main() {
 	adminService := services.NewAdminService(cfg)
 
 	vpnConnectionService := services.NewVPNConnectionService(db)
+	inboundPoolService := services.NewInboundPoolService(db)
+	portAllocator := services.NewPortAllocatorService(db)
+	hostSelectionService := services.NewHostSelectionService(xuiServerService, vpnConnectionService, cfg.VPN.HostSelectionStrategy)
+	vpnRemovalService := services.NewVPNRemovalService(xuiServerService, vpnConnectionService, inboundPoolService, portAllocator)
+	realityService := services.NewRealityService(db, nil, cfg.Reality)
+	planService := services.NewPlanService(db)
+	reportService := services.NewReportService(db)
+	promoService := services.NewPromoService(db)
+	referralService := services.NewReferralService(db)
+	ledgerService := services.NewLedgerService(db)
+	trialService := services.NewTrialService(db)
+	giftService := services.NewGiftService(db)
+	subscriptionService := services.NewSubscriptionService(db)
 
 	// Создаем сервис для добавления XUI хостов
 	xuiHostAddService := services.NewXUIHostAddService(
@@ -69,10 +82,12 @@ func main() {
 
 	// Инициализируем HTTP обработчики
 	httpHandler := handlers.NewHTTPHandler(userService, nil, cfg.WebApp.URL)
+	statsHandler := handlers.NewStatsHandler(reportService, cfg.Stats.APIToken)
 
 	// Переменные для graceful shutdown
 	var bot *telegram.TelegramBot
 	var hostMonitorService *services.HostMonitorService
+	var expiryScheduler *services.ExpirySchedulerService
 
 	// Инициализируем Telegram бота
 	if cfg.Telegram.Token != "" && cfg.Telegram.Token != "your_bot_token_here" {
@@ -123,6 +138,16 @@ func main() {
 			time.Duration(cfg.Monitor.CheckIntervalMinutes)*time.Minute,
 		)
 
+		// Создаем планировщик проверки сроков и трафика VPN подключений
+		expiryScheduler = services.NewExpirySchedulerService(
+			xuiServerService,
+			vpnConnectionService,
+			trialService,
+			subscriptionService,
+			telegramClientAdapter,
+			cfg.Expiry,
+		)
+
 		// Создаем Telegram UserService
 		telegramUserService := telegram.NewUserService(db)
 
@@ -141,6 +166,19 @@ func main() {
 			vpnConnectionService,
 			cfg,
 			transactionService,
+			vpnRemovalService,
+			realityService,
+			inboundPoolService,
+			portAllocator,
+			hostSelectionService,
+			planService,
+			reportService,
+			promoService,
+			referralService,
+			ledgerService,
+			trialService,
+			giftService,
+			subscriptionService,
 		)
 
 		// Добавляем обработчик сообщений
@@ -153,12 +191,20 @@ func main() {
 			log.Printf("Мониторинг хостов запущен с интервалом %d минут", cfg.Monitor.CheckIntervalMinutes)
 		}
 
+		// Запускаем проверку сроков VPN подключений
+		if err := expiryScheduler.Start(); err != nil {
+			log.Printf("Предупреждение: не удалось запустить проверку сроков VPN: %v", err)
+		}
+
 		// Запускаем бота
 		if err := bot.Start(); err != nil {
 			log.Fatalf("Ошибка запуска Telegram бота: %v", err)
 		}
 
 		log.Printf("Telegram бот запущен в режиме: %s", bot.GetMode())
+
+		// Дообрабатываем платежи, обработка которых была прервана перезапуском
+		go messageProcessor.ResumePendingPayments(bot.GetClient())
 		log.Printf("WebApp URL: %s", cfg.WebApp.URL)
 		log.Printf("VPN Server IP: %s", cfg.VPN.ServerIP)
 		log.Printf("VPN Port Range: %d-%d", cfg.VPN.PortRangeStart, cfg.VPN.PortRangeEnd)
@@ -169,6 +215,7 @@ func main() {
 	// Настраиваем HTTP маршруты
 	http.HandleFunc("/v1/getUsers", httpHandler.GetUsersHandler())
 	http.HandleFunc("/v1/telegram/users", httpHandler.GetTelegramUsersHandler())
+	http.HandleFunc("/v1/stats", statsHandler.GetStats)
 
 	log.Println("Сервер запущен на :25566")
 
@@ -196,6 +243,13 @@ func main() {
 		}
 	}
 
+	if expiryScheduler != nil {
+		log.Println("Останавливаем проверку сроков VPN...")
+		if err := expiryScheduler.Stop(); err != nil {
+			log.Printf("Ошибка остановки проверки сроков: %v", err)
+		}
+	}
+
 	if bot != nil {
 		log.Println("Останавливаем Telegram бота...")
 		if err := bot.Stop(); err != nil {
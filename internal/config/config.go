@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 )
 
 // Config содержит конфигурацию приложения
@@ -15,6 +16,11 @@ type Config struct {
 	VPN      VPNConfig
 	Admin    AdminConfig
 	Monitor  MonitorConfig
+	Expiry   ExpiryConfig
+	Reality  RealityConfig
+	Stats    StatsConfig
+	Referral ReferralConfig
+	Trial    TrialConfig
 }
 
 // DatabaseConfig содержит конфигурацию базы данных
@@ -45,6 +51,19 @@ type VPNConfig struct {
 	ServerIP       string
 	PortRangeStart int
 	PortRangeEnd   int
+
+	// PoolInboundMaxClients — максимум клиентов в общем inbound (0 — без ограничения)
+	PoolInboundMaxClients int
+
+	// HostSelectionStrategy — стратегия выбора хоста: least_connections,
+	// weighted_round_robin, lowest_latency или location
+	HostSelectionStrategy string
+
+	// Параметры клиента по умолчанию (0 — без ограничения)
+	DefaultTrafficGB    int
+	DefaultDurationDays int
+	DefaultLimitIP      int
+	DefaultFlow         string
 }
 
 // AdminConfig содержит конфигурацию администратора
@@ -58,6 +77,47 @@ type MonitorConfig struct {
 	CheckIntervalMinutes int
 }
 
+// ExpiryConfig содержит параметры проверки сроков и трафика VPN подключений
+type ExpiryConfig struct {
+	CheckIntervalMinutes int
+	// ReminderDays - за сколько дней до окончания срока напоминать пользователю
+	ReminderDays []int
+	// TrafficReminderPercent - порог израсходованного трафика для напоминания, 0 — не напоминать
+	TrafficReminderPercent int
+}
+
+// RealityConfig содержит параметры маскировки REALITY для новых inbound
+type RealityConfig struct {
+	Dest         string
+	ServerNames  []string
+	Fingerprint  string
+	ShortIDCount int
+}
+
+// ReferralConfig содержит параметры реферальной программы
+type ReferralConfig struct {
+	// RewardDays - дополнительные дни пригласившему за первую оплату приглашенного, 0 — без награды
+	RewardDays int
+	// RewardStars - звезды на баланс пригласившему за первую оплату приглашенного, 0 — без награды
+	RewardStars int
+}
+
+// TrialConfig содержит параметры бесплатного пробного VPN
+type TrialConfig struct {
+	// DurationDays - срок пробного VPN в днях, 0 — пробный период отключен
+	DurationDays int
+	// TrafficGB - лимит трафика пробного VPN, 0 — без ограничения
+	TrafficGB int
+	// LimitIP - лимит устройств пробного VPN, 0 — без ограничения
+	LimitIP int
+}
+
+// StatsConfig содержит параметры HTTP API статистики
+type StatsConfig struct {
+	// APIToken - токен доступа к /v1/stats, пустой — endpoint отключен
+	APIToken string
+}
+
 // Load загружает конфигурацию из переменных окружения
 func Load() *Config {
 	return &Config{
@@ -77,6 +137,14 @@ func Load() *Config {
 			ServerIP:       getEnvOrDefault("VPN_SERVER_IP", ""),
 			PortRangeStart: getEnvAsInt("VPN_SERVER_PORT_RANGE_START", 20000),
 			PortRangeEnd:   getEnvAsInt("VPN_SERVER_PORT_RANGE_END", 60000),
+
+			PoolInboundMaxClients: getEnvAsInt("VPN_POOL_INBOUND_MAX_CLIENTS", 100),
+			HostSelectionStrategy: getEnvOrDefault("VPN_HOST_SELECTION_STRATEGY", "least_connections"),
+
+			DefaultTrafficGB:    getEnvAsInt("VPN_DEFAULT_TRAFFIC_GB", 10),
+			DefaultDurationDays: getEnvAsInt("VPN_DEFAULT_DURATION_DAYS", 10),
+			DefaultLimitIP:      getEnvAsInt("VPN_DEFAULT_LIMIT_IP", 0),
+			DefaultFlow:         getEnvOrDefault("VPN_DEFAULT_FLOW", ""),
 		},
 		Admin: AdminConfig{
 			GlobalAdminTgID:     getEnvAsInt64("GLOBAL_ADMIN_TG_ID", 0),
@@ -85,6 +153,29 @@ func Load() *Config {
 		Monitor: MonitorConfig{
 			CheckIntervalMinutes: getEnvAsInt("HOST_MONITOR_INTERVAL_MINUTES", 5),
 		},
+		Expiry: ExpiryConfig{
+			CheckIntervalMinutes:   getEnvAsInt("EXPIRY_CHECK_INTERVAL_MINUTES", 10),
+			ReminderDays:           getEnvAsIntList("EXPIRY_REMINDER_DAYS", []int{3, 1}),
+			TrafficReminderPercent: getEnvAsInt("EXPIRY_TRAFFIC_REMINDER_PERCENT", 90),
+		},
+		Reality: RealityConfig{
+			Dest:         getEnvOrDefault("REALITY_DEST", "yahoo.com:443"),
+			ServerNames:  getEnvAsList("REALITY_SERVER_NAMES", []string{"yahoo.com", "www.yahoo.com"}),
+			Fingerprint:  getEnvOrDefault("REALITY_FINGERPRINT", "chrome"),
+			ShortIDCount: getEnvAsInt("REALITY_SHORT_ID_COUNT", 8),
+		},
+		Stats: StatsConfig{
+			APIToken: getEnvOrDefault("STATS_API_TOKEN", ""),
+		},
+		Referral: ReferralConfig{
+			RewardDays:  getEnvAsInt("REFERRAL_REWARD_DAYS", 7),
+			RewardStars: getEnvAsInt("REFERRAL_REWARD_STARS", 0),
+		},
+		Trial: TrialConfig{
+			DurationDays: getEnvAsInt("TRIAL_DURATION_DAYS", 3),
+			TrafficGB:    getEnvAsInt("TRIAL_TRAFFIC_GB", 2),
+			LimitIP:      getEnvAsInt("TRIAL_LIMIT_IP", 1),
+		},
 	}
 }
 
@@ -140,3 +231,37 @@ func getEnvAsInt64(key string, defaultValue int64) int64 {
 	}
 	return defaultValue
 }
+
+// getEnvAsList получает значение переменной окружения как список через запятую или возвращает значение по умолчанию
+func getEnvAsList(key string, defaultValue []string) []string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	var result []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	if len(result) == 0 {
+		return defaultValue
+	}
+	return result
+}
+
+// getEnvAsIntList получает значение переменной окружения как список чисел через запятую или возвращает значение по умолчанию
+func getEnvAsIntList(key string, defaultValue []int) []int {
+	var result []int
+	for _, item := range getEnvAsList(key, nil) {
+		intValue, err := strconv.Atoi(item)
+		if err != nil {
+			return defaultValue
+		}
+		result = append(result, intValue)
+	}
+	if len(result) == 0 {
+		return defaultValue
+	}
+	return result
+}
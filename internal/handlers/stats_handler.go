@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"TelegramXUI/internal/services"
+)
+
+// maxStatsPeriods ограничение количества периодов в отчете
+const maxStatsPeriods = 366
+
+// StatsHandler отдает финансовую статистику по HTTP
+type StatsHandler struct {
+	reportService *services.ReportService
+	apiToken      string
+}
+
+// NewStatsHandler создает обработчик статистики.
+// apiToken — токен доступа; если он пустой, endpoint отключен.
+func NewStatsHandler(reportService *services.ReportService, apiToken string) *StatsHandler {
+	return &StatsHandler{
+		reportService: reportService,
+		apiToken:      apiToken,
+	}
+}
+
+// GetStats обрабатывает GET /v1/stats?period=day|week|month&count=N&format=json|csv.
+// Требует заголовок Authorization: Bearer <STATS_API_TOKEN>.
+func (h *StatsHandler) GetStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.apiToken == "" {
+		http.Error(w, "статистика отключена: не задан STATS_API_TOKEN", http.StatusForbidden)
+		return
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(h.apiToken)) != 1 {
+		http.Error(w, "неверный токен доступа", http.StatusUnauthorized)
+		return
+	}
+
+	granularity := r.URL.Query().Get("period")
+	if granularity == "" {
+		granularity = services.ReportGranularityDay
+	}
+	if !services.IsValidReportGranularity(granularity) {
+		http.Error(w, "period должен быть day, week или month", http.StatusBadRequest)
+		return
+	}
+
+	count := services.DefaultReportPeriods(granularity)
+	if countStr := r.URL.Query().Get("count"); countStr != "" {
+		n, err := strconv.Atoi(countStr)
+		if err != nil || n < 1 || n > maxStatsPeriods {
+			http.Error(w, fmt.Sprintf("count должен быть числом от 1 до %d", maxStatsPeriods), http.StatusBadRequest)
+			return
+		}
+		count = n
+	}
+
+	from, to := services.ReportRange(granularity, count, time.Now())
+	report, err := h.reportService.GetRevenueReport(granularity, from, to)
+	if err != nil {
+		log.Printf("[StatsHandler] %v", err)
+		http.Error(w, "ошибка построения отчета", http.StatusInternalServerError)
+		return
+	}
+
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=stats_%s_%s.csv", granularity, from.Format("20060102")))
+		if err := report.WriteCSV(w); err != nil {
+			log.Printf("[StatsHandler] Ошибка выгрузки CSV: %v", err)
+		}
+	default:
+		http.Error(w, "format должен быть json или csv", http.StatusBadRequest)
+	}
+}
@@ -242,7 +242,8 @@ func (h *TelegramHandler) handleCreateVPNCallback(client *telegram.TelegramClien
 		user.Username,
 		user.FirstName,
 		user.LastName,
-		1, // TODO: Получать ServerID из конфигурации или выбора
+		&services.XUIServer{ID: 1}, // TODO: Получать сервер из конфигурации или выбора
+		services.DefaultProvisionSpec(h.vpnConfig),
 		h.vpnConfig,
 	)
 	if err != nil {
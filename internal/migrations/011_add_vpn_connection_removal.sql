@@ -0,0 +1,26 @@
+-- +goose Up
+
+-- Флаг выделенного inbound: при удалении такого подключения удаляется весь inbound
+ALTER TABLE vpn_connections
+ADD COLUMN dedicated_inbound BOOLEAN NOT NULL DEFAULT TRUE,
+ADD COLUMN removal_pending BOOLEAN NOT NULL DEFAULT FALSE,
+ADD COLUMN removal_error TEXT,
+ADD COLUMN removal_attempts INTEGER NOT NULL DEFAULT 0,
+ADD COLUMN removal_requested_at TIMESTAMP;
+
+COMMENT ON COLUMN vpn_connections.dedicated_inbound IS 'Inbound создан только для этого подключения';
+COMMENT ON COLUMN vpn_connections.removal_pending IS 'Удаление на панели не завершено и ожидает повтора';
+COMMENT ON COLUMN vpn_connections.removal_error IS 'Последняя ошибка удаления на панели';
+
+CREATE INDEX IF NOT EXISTS idx_vpn_connections_removal_pending ON vpn_connections(removal_pending);
+
+-- +goose Down
+
+DROP INDEX IF EXISTS idx_vpn_connections_removal_pending;
+
+ALTER TABLE vpn_connections
+DROP COLUMN IF EXISTS removal_requested_at,
+DROP COLUMN IF EXISTS removal_attempts,
+DROP COLUMN IF EXISTS removal_error,
+DROP COLUMN IF EXISTS removal_pending,
+DROP COLUMN IF EXISTS dedicated_inbound;
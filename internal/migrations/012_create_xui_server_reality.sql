@@ -0,0 +1,18 @@
+-- +goose Up
+-- Ключи и параметры REALITY отдельно для каждого x-ui сервера
+CREATE TABLE IF NOT EXISTS xui_server_reality (
+    server_id INTEGER PRIMARY KEY REFERENCES xui_servers(id) ON DELETE CASCADE,
+    private_key VARCHAR(64) NOT NULL,
+    public_key VARCHAR(64) NOT NULL,
+    short_ids TEXT[] NOT NULL,
+    dest VARCHAR(255) NOT NULL,
+    server_names TEXT[] NOT NULL,
+    fingerprint VARCHAR(32) NOT NULL DEFAULT 'chrome',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+COMMENT ON TABLE xui_server_reality IS 'Ключи x25519 и shortIds REALITY для inbound каждого сервера';
+
+-- +goose Down
+DROP TABLE IF EXISTS xui_server_reality;
@@ -0,0 +1,44 @@
+-- +goose Up
+
+-- Режим выдачи VPN на хосте: отдельный inbound на подключение или общие pool inbound
+ALTER TABLE xui_servers
+ADD COLUMN provisioning_mode VARCHAR(16) NOT NULL DEFAULT 'per_inbound'
+    CHECK (provisioning_mode IN ('per_inbound', 'shared'));
+
+COMMENT ON COLUMN xui_servers.provisioning_mode IS 'per_inbound — inbound на каждое подключение, shared — клиенты в общих inbound';
+
+-- Общие inbound хоста, в которые добавляются клиенты в режиме shared
+CREATE TABLE IF NOT EXISTS xui_pool_inbounds (
+    id SERIAL PRIMARY KEY,
+    server_id INTEGER NOT NULL REFERENCES xui_servers(id) ON DELETE CASCADE,
+    inbound_id INTEGER NOT NULL,
+    port INTEGER NOT NULL,
+    max_clients INTEGER NOT NULL DEFAULT 0,
+    is_active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(server_id, inbound_id)
+);
+
+COMMENT ON TABLE xui_pool_inbounds IS 'Общие inbound хостов для режима shared';
+COMMENT ON COLUMN xui_pool_inbounds.max_clients IS 'Максимум клиентов в inbound, 0 — без ограничения';
+
+CREATE INDEX IF NOT EXISTS idx_xui_pool_inbounds_server_id ON xui_pool_inbounds(server_id);
+
+-- В общем inbound у одного пользователя может быть несколько клиентов,
+-- уникальность теперь по клиенту внутри inbound сервера
+ALTER TABLE vpn_connections DROP CONSTRAINT IF EXISTS vpn_connections_telegram_user_id_inbound_id_key;
+ALTER TABLE vpn_connections ADD CONSTRAINT vpn_connections_server_inbound_client_key UNIQUE (server_id, inbound_id, client_id);
+
+CREATE INDEX IF NOT EXISTS idx_vpn_connections_server_inbound ON vpn_connections(server_id, inbound_id);
+
+-- +goose Down
+
+DROP INDEX IF EXISTS idx_vpn_connections_server_inbound;
+ALTER TABLE vpn_connections DROP CONSTRAINT IF EXISTS vpn_connections_server_inbound_client_key;
+ALTER TABLE vpn_connections ADD CONSTRAINT vpn_connections_telegram_user_id_inbound_id_key UNIQUE (telegram_user_id, inbound_id);
+
+DROP INDEX IF EXISTS idx_xui_pool_inbounds_server_id;
+DROP TABLE IF EXISTS xui_pool_inbounds;
+
+ALTER TABLE xui_servers DROP COLUMN IF EXISTS provisioning_mode;
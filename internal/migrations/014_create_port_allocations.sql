@@ -0,0 +1,32 @@
+-- +goose Up
+
+-- Реестр занятых портов на x-ui серверах
+CREATE TABLE IF NOT EXISTS xui_port_allocations (
+    id SERIAL PRIMARY KEY,
+    server_id INTEGER NOT NULL REFERENCES xui_servers(id) ON DELETE CASCADE,
+    port INTEGER NOT NULL,
+    inbound_id INTEGER,
+    status VARCHAR(16) NOT NULL DEFAULT 'reserved'
+        CHECK (status IN ('reserved', 'bound', 'external')),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(server_id, port)
+);
+
+COMMENT ON TABLE xui_port_allocations IS 'Порты inbound, занятые на x-ui серверах';
+COMMENT ON COLUMN xui_port_allocations.status IS 'reserved — зарезервирован до создания inbound, bound — занят inbound бота, external — занят на панели вне бота';
+
+CREATE INDEX IF NOT EXISTS idx_xui_port_allocations_inbound ON xui_port_allocations(server_id, inbound_id);
+
+-- Переносим порты уже созданных подключений
+INSERT INTO xui_port_allocations (server_id, port, inbound_id, status)
+SELECT DISTINCT ON (server_id, port) server_id, port, inbound_id, 'bound'
+FROM vpn_connections
+WHERE is_active = true AND server_id IN (SELECT id FROM xui_servers)
+ORDER BY server_id, port, id DESC
+ON CONFLICT (server_id, port) DO NOTHING;
+
+-- +goose Down
+
+DROP INDEX IF EXISTS idx_xui_port_allocations_inbound;
+DROP TABLE IF EXISTS xui_port_allocations;
@@ -0,0 +1,21 @@
+-- +goose Up
+
+-- Параметры для выбора хоста при создании VPN
+ALTER TABLE xui_servers
+ADD COLUMN weight INTEGER NOT NULL DEFAULT 1 CHECK (weight >= 0),
+ADD COLUMN last_latency_ms INTEGER,
+ADD COLUMN last_checked_at TIMESTAMP;
+
+COMMENT ON COLUMN xui_servers.weight IS 'Вес хоста для стратегии weighted_round_robin, 0 — хост не выбирается';
+COMMENT ON COLUMN xui_servers.last_latency_ms IS 'Время ответа панели при последней проверке мониторинга, мс';
+
+CREATE INDEX IF NOT EXISTS idx_xui_servers_location ON xui_servers(server_location);
+
+-- +goose Down
+
+DROP INDEX IF EXISTS idx_xui_servers_location;
+
+ALTER TABLE xui_servers
+DROP COLUMN IF EXISTS last_checked_at,
+DROP COLUMN IF EXISTS last_latency_ms,
+DROP COLUMN IF EXISTS weight;
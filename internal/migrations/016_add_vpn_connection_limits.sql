@@ -0,0 +1,25 @@
+-- +goose Up
+
+-- Лимиты и срок действия, с которыми клиент создан на панели
+ALTER TABLE vpn_connections
+ADD COLUMN traffic_limit_bytes BIGINT NOT NULL DEFAULT 0,
+ADD COLUMN expires_at TIMESTAMP,
+ADD COLUMN limit_ip INTEGER NOT NULL DEFAULT 0,
+ADD COLUMN flow VARCHAR(32) NOT NULL DEFAULT '';
+
+COMMENT ON COLUMN vpn_connections.traffic_limit_bytes IS 'Лимит трафика в байтах, 0 — без ограничения';
+COMMENT ON COLUMN vpn_connections.expires_at IS 'Дата окончания действия, NULL — бессрочно';
+COMMENT ON COLUMN vpn_connections.limit_ip IS 'Максимум одновременных IP (устройств), 0 — без ограничения';
+COMMENT ON COLUMN vpn_connections.flow IS 'Flow клиента VLESS (например, xtls-rprx-vision)';
+
+CREATE INDEX IF NOT EXISTS idx_vpn_connections_expires_at ON vpn_connections(expires_at);
+
+-- +goose Down
+
+DROP INDEX IF EXISTS idx_vpn_connections_expires_at;
+
+ALTER TABLE vpn_connections
+DROP COLUMN IF EXISTS flow,
+DROP COLUMN IF EXISTS limit_ip,
+DROP COLUMN IF EXISTS expires_at,
+DROP COLUMN IF EXISTS traffic_limit_bytes;
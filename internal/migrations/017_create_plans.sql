@@ -0,0 +1,42 @@
+-- +goose Up
+
+-- Тарифы VPN с ценой в Telegram Stars
+CREATE TABLE IF NOT EXISTS plans (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(64) NOT NULL,
+    price_stars INTEGER NOT NULL CHECK (price_stars > 0),
+    duration_days INTEGER NOT NULL DEFAULT 30 CHECK (duration_days >= 0),
+    traffic_gb INTEGER NOT NULL DEFAULT 0 CHECK (traffic_gb >= 0),
+    limit_ip INTEGER NOT NULL DEFAULT 0 CHECK (limit_ip >= 0),
+    allowed_locations TEXT[] NOT NULL DEFAULT '{}',
+    host_strategy VARCHAR(32) NOT NULL DEFAULT '',
+    is_active BOOLEAN NOT NULL DEFAULT TRUE,
+    version INTEGER NOT NULL DEFAULT 1,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+COMMENT ON TABLE plans IS 'Тарифы VPN';
+COMMENT ON COLUMN plans.duration_days IS 'Срок действия в днях, 0 — бессрочно';
+COMMENT ON COLUMN plans.traffic_gb IS 'Лимит трафика в ГБ, 0 — без ограничения';
+COMMENT ON COLUMN plans.limit_ip IS 'Максимум устройств (limitIp), 0 — без ограничения';
+COMMENT ON COLUMN plans.allowed_locations IS 'Доступные локации (server_location), пустой список — все';
+COMMENT ON COLUMN plans.host_strategy IS 'Стратегия выбора хоста для тарифа, пустая — глобальная';
+COMMENT ON COLUMN plans.version IS 'Версия условий тарифа, увеличивается при каждом изменении';
+
+CREATE INDEX IF NOT EXISTS idx_plans_active ON plans(is_active);
+
+-- Тариф с прежними условиями единственного продукта
+INSERT INTO plans (name, price_stars, duration_days, traffic_gb, limit_ip)
+VALUES ('Базовый', 1, 10, 10, 0);
+
+ALTER TABLE vpn_connections ADD COLUMN plan_id INTEGER REFERENCES plans(id) ON DELETE SET NULL;
+ALTER TABLE transactions ADD COLUMN plan_id INTEGER REFERENCES plans(id) ON DELETE SET NULL;
+
+-- +goose Down
+
+ALTER TABLE transactions DROP COLUMN IF EXISTS plan_id;
+ALTER TABLE vpn_connections DROP COLUMN IF EXISTS plan_id;
+
+DROP INDEX IF EXISTS idx_plans_active;
+DROP TABLE IF EXISTS plans;
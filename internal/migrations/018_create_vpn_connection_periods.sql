@@ -0,0 +1,30 @@
+-- +goose Up
+
+-- Оплаченные периоды действия VPN подключений (первичная покупка и продления)
+CREATE TABLE IF NOT EXISTS vpn_connection_periods (
+    id SERIAL PRIMARY KEY,
+    vpn_connection_id INTEGER NOT NULL REFERENCES vpn_connections(id) ON DELETE CASCADE,
+    plan_id INTEGER REFERENCES plans(id) ON DELETE SET NULL,
+    starts_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP,
+    traffic_limit_bytes BIGINT NOT NULL DEFAULT 0,
+    telegram_payment_charge_id VARCHAR(255),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+COMMENT ON TABLE vpn_connection_periods IS 'Периоды действия VPN подключений';
+COMMENT ON COLUMN vpn_connection_periods.expires_at IS 'Окончание периода, NULL — бессрочно';
+COMMENT ON COLUMN vpn_connection_periods.telegram_payment_charge_id IS 'Платеж, которым оплачен период';
+
+CREATE INDEX IF NOT EXISTS idx_vpn_connection_periods_connection ON vpn_connection_periods(vpn_connection_id);
+
+-- Первый период уже существующих подключений
+INSERT INTO vpn_connection_periods (vpn_connection_id, plan_id, starts_at, expires_at, traffic_limit_bytes)
+SELECT id, plan_id, created_at, expires_at, traffic_limit_bytes
+FROM vpn_connections
+WHERE is_active = true;
+
+-- +goose Down
+
+DROP INDEX IF EXISTS idx_vpn_connection_periods_connection;
+DROP TABLE IF EXISTS vpn_connection_periods;
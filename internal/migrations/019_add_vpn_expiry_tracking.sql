@@ -0,0 +1,29 @@
+-- +goose Up
+
+-- Момент автоматического отключения подключения по окончании срока.
+-- Истекшее подключение неактивно, но остается у пользователя и может быть продлено.
+ALTER TABLE vpn_connections ADD COLUMN expired_at TIMESTAMP;
+
+COMMENT ON COLUMN vpn_connections.expired_at IS 'Подключение отключено по окончании срока, NULL — не истекало';
+
+CREATE INDEX IF NOT EXISTS idx_vpn_connections_active_expires_at ON vpn_connections(expires_at) WHERE is_active = true;
+
+-- Отправленные напоминания: каждое напоминание отправляется один раз за оплаченный период
+CREATE TABLE IF NOT EXISTS vpn_connection_reminders (
+    id SERIAL PRIMARY KEY,
+    vpn_connection_id INTEGER NOT NULL REFERENCES vpn_connections(id) ON DELETE CASCADE,
+    period_id INTEGER NOT NULL DEFAULT 0,
+    kind VARCHAR(32) NOT NULL,
+    sent_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(vpn_connection_id, period_id, kind)
+);
+
+COMMENT ON TABLE vpn_connection_reminders IS 'Напоминания об окончании срока и трафика VPN подключений';
+COMMENT ON COLUMN vpn_connection_reminders.period_id IS 'Последний период подключения на момент отправки (vpn_connection_periods.id)';
+COMMENT ON COLUMN vpn_connection_reminders.kind IS 'Тип напоминания: expiry_<дней>d, traffic_<процент>';
+
+-- +goose Down
+
+DROP TABLE IF EXISTS vpn_connection_reminders;
+DROP INDEX IF EXISTS idx_vpn_connections_active_expires_at;
+ALTER TABLE vpn_connections DROP COLUMN IF EXISTS expired_at;
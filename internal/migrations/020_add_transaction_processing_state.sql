@@ -0,0 +1,54 @@
+-- +goose Up
+
+-- Состояние обработки платежа: pending → provisioning → fulfilled / refunded / failed.
+-- Строки возвратов (type = 'refund') сохраняют статус success.
+ALTER TABLE transactions ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE transactions ADD COLUMN last_error TEXT;
+ALTER TABLE transactions ADD COLUMN processing_started_at TIMESTAMP;
+
+COMMENT ON COLUMN transactions.attempts IS 'Количество попыток выполнить оплаченное действие';
+COMMENT ON COLUMN transactions.last_error IS 'Ошибка последней попытки';
+
+-- Платежи, по которым уже был возврат
+UPDATE transactions p SET status = 'refunded'
+WHERE p.type = 'payment' AND p.status = 'success'
+  AND EXISTS (
+      SELECT 1 FROM transactions r
+      WHERE r.type = 'refund' AND r.telegram_payment_charge_id = p.telegram_payment_charge_id
+  );
+
+UPDATE transactions SET status = 'fulfilled' WHERE type = 'payment' AND status = 'success';
+
+-- Повторно записанные платежи (повторная доставка successful_payment) сохраняем в истории,
+-- но исключаем из уникальности
+UPDATE transactions t SET type = 'duplicate'
+WHERE t.type = 'payment'
+  AND EXISTS (
+      SELECT 1 FROM transactions o
+      WHERE o.type = 'payment'
+        AND o.telegram_payment_charge_id = t.telegram_payment_charge_id
+        AND o.id < t.id
+  );
+
+ALTER TABLE transactions ADD CONSTRAINT transactions_status_check
+    CHECK (status IN ('pending', 'provisioning', 'fulfilled', 'refunded', 'failed', 'success'));
+
+-- Каждый платеж Telegram обрабатывается ровно один раз
+CREATE UNIQUE INDEX IF NOT EXISTS transactions_payment_charge_key
+    ON transactions(telegram_payment_charge_id) WHERE type = 'payment';
+
+CREATE INDEX IF NOT EXISTS idx_transactions_unfinished
+    ON transactions(status) WHERE status IN ('pending', 'provisioning');
+
+-- +goose Down
+
+DROP INDEX IF EXISTS idx_transactions_unfinished;
+DROP INDEX IF EXISTS transactions_payment_charge_key;
+ALTER TABLE transactions DROP CONSTRAINT IF EXISTS transactions_status_check;
+
+UPDATE transactions SET type = 'payment' WHERE type = 'duplicate';
+UPDATE transactions SET status = 'success' WHERE type = 'payment' AND status IN ('fulfilled', 'refunded');
+
+ALTER TABLE transactions DROP COLUMN IF EXISTS processing_started_at;
+ALTER TABLE transactions DROP COLUMN IF EXISTS last_error;
+ALTER TABLE transactions DROP COLUMN IF EXISTS attempts;
@@ -0,0 +1,29 @@
+-- +goose Up
+
+-- Платеж связывается с созданным или продленным подключением,
+-- возврат — с исходным платежом
+ALTER TABLE transactions ADD COLUMN vpn_connection_id INTEGER REFERENCES vpn_connections(id) ON DELETE SET NULL;
+ALTER TABLE transactions ADD COLUMN parent_transaction_id INTEGER REFERENCES transactions(id) ON DELETE SET NULL;
+
+COMMENT ON COLUMN transactions.vpn_connection_id IS 'VPN подключение, оплаченное платежом';
+COMMENT ON COLUMN transactions.parent_transaction_id IS 'Платеж, по которому выполнен возврат';
+
+CREATE INDEX IF NOT EXISTS idx_transactions_vpn_connection ON transactions(vpn_connection_id);
+CREATE INDEX IF NOT EXISTS idx_transactions_parent ON transactions(parent_transaction_id);
+
+-- Связи для уже записанных платежей и возвратов
+UPDATE transactions t SET vpn_connection_id = p.vpn_connection_id
+FROM vpn_connection_periods p
+WHERE t.type = 'payment' AND p.telegram_payment_charge_id = t.telegram_payment_charge_id;
+
+UPDATE transactions r SET parent_transaction_id = p.id, vpn_connection_id = p.vpn_connection_id
+FROM transactions p
+WHERE r.type = 'refund' AND p.type = 'payment'
+  AND p.telegram_payment_charge_id = r.telegram_payment_charge_id;
+
+-- +goose Down
+
+DROP INDEX IF EXISTS idx_transactions_parent;
+DROP INDEX IF EXISTS idx_transactions_vpn_connection;
+ALTER TABLE transactions DROP COLUMN IF EXISTS parent_transaction_id;
+ALTER TABLE transactions DROP COLUMN IF EXISTS vpn_connection_id;
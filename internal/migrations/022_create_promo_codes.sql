@@ -0,0 +1,57 @@
+-- +goose Up
+
+-- Промокоды: скидка в процентах, фиксированная скидка в звездах или дополнительные дни
+CREATE TABLE IF NOT EXISTS promo_codes (
+    id SERIAL PRIMARY KEY,
+    code VARCHAR(32) NOT NULL UNIQUE,
+    discount_type VARCHAR(16) NOT NULL CHECK (discount_type IN ('percent', 'fixed', 'free_days')),
+    discount_value INTEGER NOT NULL CHECK (discount_value > 0),
+    max_uses INTEGER NOT NULL DEFAULT 0,
+    used_count INTEGER NOT NULL DEFAULT 0,
+    allowed_plan_ids INTEGER[] NOT NULL DEFAULT '{}',
+    expires_at TIMESTAMP,
+    is_active BOOLEAN NOT NULL DEFAULT true,
+    created_by_tg_id BIGINT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+COMMENT ON TABLE promo_codes IS 'Промокоды';
+COMMENT ON COLUMN promo_codes.code IS 'Код в верхнем регистре';
+COMMENT ON COLUMN promo_codes.discount_value IS 'Проценты, звезды или дни в зависимости от discount_type';
+COMMENT ON COLUMN promo_codes.max_uses IS 'Лимит использований, 0 — без ограничения';
+COMMENT ON COLUMN promo_codes.allowed_plan_ids IS 'Тарифы, к которым применим код, пустой список — все';
+COMMENT ON COLUMN promo_codes.expires_at IS 'Окончание действия, NULL — бессрочно';
+
+-- Промокод, введенный пользователем перед покупкой
+CREATE TABLE IF NOT EXISTS promo_code_activations (
+    telegram_user_id BIGINT PRIMARY KEY,
+    promo_code_id INTEGER NOT NULL REFERENCES promo_codes(id) ON DELETE CASCADE,
+    activated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Использования промокодов, каждый пользователь использует код один раз
+CREATE TABLE IF NOT EXISTS promo_redemptions (
+    id SERIAL PRIMARY KEY,
+    promo_code_id INTEGER NOT NULL REFERENCES promo_codes(id) ON DELETE CASCADE,
+    telegram_user_id BIGINT NOT NULL,
+    transaction_id INTEGER REFERENCES transactions(id) ON DELETE SET NULL,
+    discount_stars INTEGER NOT NULL DEFAULT 0,
+    bonus_days INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (promo_code_id, telegram_user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_promo_redemptions_transaction ON promo_redemptions(transaction_id);
+
+ALTER TABLE transactions ADD COLUMN promo_code_id INTEGER REFERENCES promo_codes(id) ON DELETE SET NULL;
+
+COMMENT ON COLUMN transactions.promo_code_id IS 'Промокод, примененный к платежу';
+
+-- +goose Down
+
+ALTER TABLE transactions DROP COLUMN IF EXISTS promo_code_id;
+DROP INDEX IF EXISTS idx_promo_redemptions_transaction;
+DROP TABLE IF EXISTS promo_redemptions;
+DROP TABLE IF EXISTS promo_code_activations;
+DROP TABLE IF EXISTS promo_codes;
@@ -0,0 +1,37 @@
+-- +goose Up
+
+-- Кто пригласил пользователя по ссылке t.me/<бот>?start=ref_<telegram_id>
+ALTER TABLE telegram_users ADD COLUMN referred_by_tg_id BIGINT;
+ALTER TABLE telegram_users ADD COLUMN referred_at TIMESTAMP;
+
+COMMENT ON COLUMN telegram_users.referred_by_tg_id IS 'Telegram ID пригласившего пользователя';
+
+CREATE INDEX IF NOT EXISTS idx_telegram_users_referred_by ON telegram_users(referred_by_tg_id);
+
+-- Награды пригласившим за первую оплату приглашенных. Дни добавляются
+-- к следующей покупке или продлению пригласившего.
+CREATE TABLE IF NOT EXISTS referral_rewards (
+    id SERIAL PRIMARY KEY,
+    referrer_tg_id BIGINT NOT NULL,
+    invitee_tg_id BIGINT NOT NULL UNIQUE,
+    transaction_id INTEGER REFERENCES transactions(id) ON DELETE SET NULL,
+    bonus_days INTEGER NOT NULL CHECK (bonus_days > 0),
+    applied_transaction_id INTEGER REFERENCES transactions(id) ON DELETE SET NULL,
+    applied_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+COMMENT ON COLUMN referral_rewards.transaction_id IS 'Первый платеж приглашенного';
+COMMENT ON COLUMN referral_rewards.applied_transaction_id IS 'Платеж пригласившего, к которому добавлены дни, NULL — еще не использованы';
+
+CREATE INDEX IF NOT EXISTS idx_referral_rewards_referrer ON referral_rewards(referrer_tg_id);
+CREATE INDEX IF NOT EXISTS idx_referral_rewards_applied ON referral_rewards(applied_transaction_id);
+
+-- +goose Down
+
+DROP INDEX IF EXISTS idx_referral_rewards_applied;
+DROP INDEX IF EXISTS idx_referral_rewards_referrer;
+DROP TABLE IF EXISTS referral_rewards;
+DROP INDEX IF EXISTS idx_telegram_users_referred_by;
+ALTER TABLE telegram_users DROP COLUMN IF EXISTS referred_at;
+ALTER TABLE telegram_users DROP COLUMN IF EXISTS referred_by_tg_id;
@@ -0,0 +1,79 @@
+-- +goose Up
+
+-- Счета баланса: счет пользователя (telegram_user_id) или системный счет (code = 'system:...').
+-- Баланс пользователя не может быть отрицательным.
+CREATE TABLE IF NOT EXISTS balance_accounts (
+    id SERIAL PRIMARY KEY,
+    code VARCHAR(64) NOT NULL UNIQUE,
+    telegram_user_id BIGINT UNIQUE REFERENCES telegram_users(telegram_id) ON DELETE RESTRICT,
+    balance INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT balance_accounts_user_balance_check CHECK (telegram_user_id IS NULL OR balance >= 0)
+);
+
+COMMENT ON TABLE balance_accounts IS 'Счета внутреннего баланса в звездах';
+
+INSERT INTO balance_accounts (code) VALUES
+    ('system:stars'),
+    ('system:sales'),
+    ('system:bonuses'),
+    ('system:adjustments')
+ON CONFLICT (code) DO NOTHING;
+
+-- Операции с балансом. Каждая операция — две проводки с противоположными суммами.
+CREATE TABLE IF NOT EXISTS ledger_entries (
+    id SERIAL PRIMARY KEY,
+    kind VARCHAR(16) NOT NULL CHECK (kind IN ('topup', 'purchase', 'refund', 'bonus', 'adjustment', 'withdrawal')),
+    telegram_user_id BIGINT NOT NULL,
+    amount INTEGER NOT NULL CHECK (amount > 0),
+    transaction_id INTEGER REFERENCES transactions(id) ON DELETE RESTRICT,
+    description TEXT,
+    created_by_tg_id BIGINT,
+    idempotency_key VARCHAR(128) UNIQUE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+COMMENT ON COLUMN ledger_entries.idempotency_key IS 'Ключ операции, повторная запись с тем же ключом не выполняется';
+COMMENT ON COLUMN ledger_entries.created_by_tg_id IS 'Администратор, выполнивший операцию вручную';
+
+CREATE INDEX IF NOT EXISTS idx_ledger_entries_user ON ledger_entries(telegram_user_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_ledger_entries_transaction ON ledger_entries(transaction_id);
+
+CREATE TABLE IF NOT EXISTS ledger_postings (
+    id SERIAL PRIMARY KEY,
+    entry_id INTEGER NOT NULL REFERENCES ledger_entries(id) ON DELETE RESTRICT,
+    account_id INTEGER NOT NULL REFERENCES balance_accounts(id) ON DELETE RESTRICT,
+    amount INTEGER NOT NULL CHECK (amount <> 0),
+    balance_after INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_ledger_postings_entry ON ledger_postings(entry_id);
+CREATE INDEX IF NOT EXISTS idx_ledger_postings_account ON ledger_postings(account_id);
+
+-- Покупки с баланса записываются платежами со способом оплаты balance
+ALTER TABLE transactions ADD COLUMN payment_method VARCHAR(16) NOT NULL DEFAULT 'stars'
+    CHECK (payment_method IN ('stars', 'balance'));
+
+COMMENT ON COLUMN transactions.payment_method IS 'stars — оплата в Telegram, balance — с внутреннего баланса';
+
+-- Награда за приглашение может начисляться днями, звездами на баланс или и тем, и другим
+ALTER TABLE referral_rewards ADD COLUMN reward_stars INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE referral_rewards DROP CONSTRAINT IF EXISTS referral_rewards_bonus_days_check;
+ALTER TABLE referral_rewards ADD CONSTRAINT referral_rewards_reward_check
+    CHECK (bonus_days >= 0 AND reward_stars >= 0 AND bonus_days + reward_stars > 0);
+
+-- +goose Down
+
+ALTER TABLE referral_rewards DROP CONSTRAINT IF EXISTS referral_rewards_reward_check;
+DELETE FROM referral_rewards WHERE bonus_days = 0;
+ALTER TABLE referral_rewards ADD CONSTRAINT referral_rewards_bonus_days_check CHECK (bonus_days > 0);
+ALTER TABLE referral_rewards DROP COLUMN IF EXISTS reward_stars;
+ALTER TABLE transactions DROP COLUMN IF EXISTS payment_method;
+DROP INDEX IF EXISTS idx_ledger_postings_account;
+DROP INDEX IF EXISTS idx_ledger_postings_entry;
+DROP TABLE IF EXISTS ledger_postings;
+DROP INDEX IF EXISTS idx_ledger_entries_transaction;
+DROP INDEX IF EXISTS idx_ledger_entries_user;
+DROP TABLE IF EXISTS ledger_entries;
+DROP TABLE IF EXISTS balance_accounts;
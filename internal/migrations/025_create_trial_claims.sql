@@ -0,0 +1,22 @@
+-- +goose Up
+
+-- Бесплатный пробный VPN выдается один раз на Telegram ID
+CREATE TABLE IF NOT EXISTS trial_claims (
+    telegram_user_id BIGINT PRIMARY KEY REFERENCES telegram_users(telegram_id) ON DELETE CASCADE,
+    vpn_connection_id INTEGER REFERENCES vpn_connections(id) ON DELETE SET NULL,
+    claimed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    activated_at TIMESTAMP,
+    offer_sent_at TIMESTAMP,
+    converted_at TIMESTAMP
+);
+
+COMMENT ON COLUMN trial_claims.activated_at IS 'Пробный VPN создан, NULL — создание еще не завершено';
+COMMENT ON COLUMN trial_claims.offer_sent_at IS 'Отправлено предложение купить тариф после окончания пробного VPN';
+COMMENT ON COLUMN trial_claims.converted_at IS 'Первая оплата после пробного периода';
+
+CREATE INDEX IF NOT EXISTS idx_trial_claims_connection ON trial_claims(vpn_connection_id);
+
+-- +goose Down
+
+DROP INDEX IF EXISTS idx_trial_claims_connection;
+DROP TABLE IF EXISTS trial_claims;
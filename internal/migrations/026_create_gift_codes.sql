@@ -0,0 +1,29 @@
+-- +goose Up
+
+-- Подарочные VPN: покупатель оплачивает счет, получатель активирует код по ссылке
+-- t.me/<бот>?start=gift_<код>. Платеж остается за покупателем, VPN создается на получателя.
+CREATE TABLE IF NOT EXISTS gift_codes (
+    id SERIAL PRIMARY KEY,
+    code VARCHAR(32) NOT NULL UNIQUE,
+    transaction_id INTEGER NOT NULL UNIQUE REFERENCES transactions(id) ON DELETE RESTRICT,
+    buyer_tg_id BIGINT NOT NULL,
+    plan_id INTEGER REFERENCES plans(id) ON DELETE SET NULL,
+    duration_days INTEGER NOT NULL DEFAULT 0 CHECK (duration_days >= 0),
+    traffic_gb INTEGER NOT NULL DEFAULT 0 CHECK (traffic_gb >= 0),
+    limit_ip INTEGER NOT NULL DEFAULT 0 CHECK (limit_ip >= 0),
+    redeemed_by_tg_id BIGINT,
+    redeemed_at TIMESTAMP,
+    vpn_connection_id INTEGER REFERENCES vpn_connections(id) ON DELETE SET NULL,
+    cancelled_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+COMMENT ON COLUMN gift_codes.duration_days IS 'Срок оплаченного VPN с учетом дополнительных дней промокода, 0 — бессрочно';
+COMMENT ON COLUMN gift_codes.cancelled_at IS 'Код аннулирован возвратом платежа';
+
+CREATE INDEX IF NOT EXISTS idx_gift_codes_buyer ON gift_codes(buyer_tg_id);
+
+-- +goose Down
+
+DROP INDEX IF EXISTS idx_gift_codes_buyer;
+DROP TABLE IF EXISTS gift_codes;
@@ -0,0 +1,27 @@
+-- +goose Up
+
+-- Подписки Telegram Stars: Telegram списывает оплату каждые 30 дней, каждое списание
+-- приходит как successful_payment и продлевает связанное VPN подключение
+CREATE TABLE IF NOT EXISTS star_subscriptions (
+    id SERIAL PRIMARY KEY,
+    telegram_user_id BIGINT NOT NULL,
+    vpn_connection_id INTEGER NOT NULL UNIQUE REFERENCES vpn_connections(id) ON DELETE CASCADE,
+    plan_id INTEGER REFERENCES plans(id) ON DELETE SET NULL,
+    charge_id VARCHAR(255) NOT NULL,
+    price_stars INTEGER NOT NULL CHECK (price_stars > 0),
+    expires_at TIMESTAMP NOT NULL,
+    cancelled_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+COMMENT ON COLUMN star_subscriptions.charge_id IS 'telegram_payment_charge_id первого платежа, по нему подписка отменяется в editUserStarSubscription';
+COMMENT ON COLUMN star_subscriptions.expires_at IS 'subscription_expiration_date последнего платежа — дата следующего списания';
+COMMENT ON COLUMN star_subscriptions.cancelled_at IS 'Продление отменено, подписка действует до expires_at';
+
+CREATE INDEX IF NOT EXISTS idx_star_subscriptions_user ON star_subscriptions(telegram_user_id);
+
+-- +goose Down
+
+DROP INDEX IF EXISTS idx_star_subscriptions_user;
+DROP TABLE IF EXISTS star_subscriptions;
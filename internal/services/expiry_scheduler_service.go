@@ -0,0 +1,326 @@
+package services
+
+import (
+	"TelegramXUI/internal/config"
+	"TelegramXUI/internal/contracts"
+	"TelegramXUI/internal/xui_client"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ExpirySchedulerService периодически проверяет сроки и трафик VPN подключений:
+// напоминает пользователям о скором окончании, отключает истекшие подключения на панели
+// и завершает истекшие пробные периоды
+type ExpirySchedulerService struct {
+	serverService          *XUIServerService
+	vpnConnectionService   *VPNConnectionService
+	trialService           *TrialService
+	subscriptionService    *SubscriptionService
+	telegramClient         contracts.TelegramMessageSender
+	checkInterval          time.Duration
+	reminderDays           []int // по убыванию
+	trafficReminderPercent int
+	stopChan               chan struct{}
+	wg                     sync.WaitGroup
+	isRunning              bool
+	mu                     sync.RWMutex
+}
+
+func NewExpirySchedulerService(
+	serverService *XUIServerService,
+	vpnConnectionService *VPNConnectionService,
+	trialService *TrialService,
+	subscriptionService *SubscriptionService,
+	telegramClient contracts.TelegramMessageSender,
+	cfg config.ExpiryConfig,
+) *ExpirySchedulerService {
+	var reminderDays []int
+	for _, days := range cfg.ReminderDays {
+		if days > 0 {
+			reminderDays = append(reminderDays, days)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(reminderDays)))
+
+	return &ExpirySchedulerService{
+		serverService:          serverService,
+		vpnConnectionService:   vpnConnectionService,
+		trialService:           trialService,
+		subscriptionService:    subscriptionService,
+		telegramClient:         telegramClient,
+		checkInterval:          time.Duration(cfg.CheckIntervalMinutes) * time.Minute,
+		reminderDays:           reminderDays,
+		trafficReminderPercent: cfg.TrafficReminderPercent,
+		stopChan:               make(chan struct{}),
+	}
+}
+
+// Start запускает проверку сроков подключений
+func (s *ExpirySchedulerService) Start() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.isRunning {
+		return fmt.Errorf("проверка сроков уже запущена")
+	}
+	if s.checkInterval <= 0 {
+		return fmt.Errorf("интервал проверки сроков должен быть больше 0")
+	}
+
+	s.stopChan = make(chan struct{})
+	s.isRunning = true
+	s.wg.Add(1)
+
+	go s.schedulerLoop()
+
+	log.Printf("[ExpiryScheduler] Проверка сроков подключений запущена с интервалом %v, напоминания за %v дн.", s.checkInterval, s.reminderDays)
+	return nil
+}
+
+// Stop останавливает проверку сроков подключений
+func (s *ExpirySchedulerService) Stop() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.isRunning {
+		return fmt.Errorf("проверка сроков не запущена")
+	}
+
+	select {
+	case <-s.stopChan:
+		log.Printf("[ExpiryScheduler] Канал остановки уже закрыт")
+	default:
+		close(s.stopChan)
+	}
+
+	s.wg.Wait()
+	s.isRunning = false
+
+	log.Printf("[ExpiryScheduler] Проверка сроков подключений остановлена")
+	return nil
+}
+
+// IsRunning проверяет, запущена ли проверка сроков
+func (s *ExpirySchedulerService) IsRunning() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.isRunning
+}
+
+// schedulerLoop основной цикл проверки
+func (s *ExpirySchedulerService) schedulerLoop() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.checkInterval)
+	defer ticker.Stop()
+
+	s.RunOnce()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.RunOnce()
+		case <-s.stopChan:
+			return
+		}
+	}
+}
+
+// RunOnce выполняет одну проверку: напоминания о сроке, отключение истекших, напоминания
+// о трафике и возврат в active пользователей с истекшим пробным периодом
+func (s *ExpirySchedulerService) RunOnce() {
+	now := time.Now()
+	s.checkExpiry(now)
+	if s.trafficReminderPercent > 0 {
+		s.checkTraffic()
+	}
+	if expired, err := s.trialService.ExpireTrialStates(); err != nil {
+		log.Printf("[ExpiryScheduler] %v", err)
+	} else if expired > 0 {
+		log.Printf("[ExpiryScheduler] Завершено пробных периодов: %d", expired)
+	}
+}
+
+// checkExpiry напоминает о скором окончании срока и отключает истекшие подключения
+func (s *ExpirySchedulerService) checkExpiry(now time.Time) {
+	horizon := now
+	if len(s.reminderDays) > 0 {
+		horizon = now.Add(time.Duration(s.reminderDays[0]) * 24 * time.Hour)
+	}
+
+	connections, err := s.vpnConnectionService.GetExpiringConnections(horizon)
+	if err != nil {
+		log.Printf("[ExpiryScheduler] %v", err)
+		return
+	}
+
+	for _, connection := range connections {
+		if !connection.ExpiresAt.After(now) {
+			s.expireConnection(connection)
+			continue
+		}
+
+		// Берем наименьший порог, в который уже попал остаток срока: если проверка
+		// пропустила порог в 3 дня, пользователь получит только напоминание за 1 день
+		left := connection.ExpiresAt.Sub(now)
+		threshold := 0
+		for _, days := range s.reminderDays {
+			if left <= time.Duration(days)*24*time.Hour {
+				threshold = days
+			}
+		}
+		if threshold == 0 {
+			continue
+		}
+		// Подключение с подпиской Telegram продлит сам до окончания срока
+		if sub, err := s.subscriptionService.GetRenewingByConnection(connection.ID); err != nil {
+			log.Printf("[ExpiryScheduler] %v", err)
+		} else if sub != nil {
+			continue
+		}
+
+		message := fmt.Sprintf("⏳ Срок действия VPN #%d заканчивается %s (осталось %s).\n\nПродлить подключение можно в /vpn кнопкой «🔄 Продлить».",
+			connection.ID, FormatExpiry(connection.ExpiresAt), formatTimeLeft(left))
+		s.remind(connection, fmt.Sprintf("expiry_%dd", threshold), message)
+	}
+}
+
+// expireConnection отключает истекшее подключение на панели и в БД и уведомляет пользователя.
+// Если панель недоступна, подключение остается активным до следующей проверки.
+func (s *ExpirySchedulerService) expireConnection(connection *VPNConnection) {
+	server, err := s.serverService.GetServerByID(connection.ServerID)
+	if err != nil {
+		log.Printf("[ExpiryScheduler] Ошибка получения сервера %d: %v", connection.ServerID, err)
+		return
+	}
+	if server != nil {
+		xui := xui_client.NewClient(server.ServerURL, server.Username, server.Password)
+		vpnService := NewVPNService(xui, s.vpnConnectionService, nil, nil, nil)
+		if err := vpnService.DisableVPNConnection(connection); err != nil {
+			log.Printf("[ExpiryScheduler] Не удалось отключить подключение %d на панели: %v", connection.ID, err)
+			return
+		}
+	}
+
+	expired, err := s.vpnConnectionService.MarkExpired(connection.ID)
+	if err != nil {
+		log.Printf("[ExpiryScheduler] %v", err)
+		return
+	}
+	if !expired {
+		return
+	}
+
+	log.Printf("[ExpiryScheduler] Подключение %d пользователя %d отключено по окончании срока", connection.ID, connection.TelegramUserID)
+	message := fmt.Sprintf("⛔ Срок действия VPN #%d истек, подключение отключено.\n\nПродлите его в /vpn кнопкой «🔄 Продлить» — ссылка для подключения не изменится.", connection.ID)
+	if offer, err := s.trialService.MarkOfferSent(connection.ID); err != nil {
+		log.Printf("[ExpiryScheduler] %v", err)
+	} else if offer {
+		message = fmt.Sprintf("🎁 Пробный период закончился, VPN #%d отключен.\n\nПонравилось? Выберите тариф в /vpn кнопкой «🔄 Продлить» у VPN #%d — "+
+			"ссылка для подключения не изменится, а лимиты станут как в тарифе. Промокод можно применить командой /promo.", connection.ID, connection.ID)
+	}
+	if err := s.telegramClient.SendMessage(connection.TelegramUserID, message); err != nil {
+		log.Printf("[ExpiryScheduler] Ошибка отправки уведомления пользователю %d: %v", connection.TelegramUserID, err)
+	}
+}
+
+// checkTraffic напоминает о расходе трафика по статистике клиентов на панелях
+func (s *ExpirySchedulerService) checkTraffic() {
+	connections, err := s.vpnConnectionService.GetTrafficLimitedConnections()
+	if err != nil {
+		log.Printf("[ExpiryScheduler] %v", err)
+		return
+	}
+
+	byServer := make(map[int][]*VPNConnection)
+	for _, connection := range connections {
+		byServer[connection.ServerID] = append(byServer[connection.ServerID], connection)
+	}
+
+	for serverID, serverConnections := range byServer {
+		traffic, err := s.serverTraffic(serverID)
+		if err != nil {
+			log.Printf("[ExpiryScheduler] Ошибка получения трафика сервера %d: %v", serverID, err)
+			continue
+		}
+
+		for _, connection := range serverConnections {
+			stats, ok := traffic[connection.Email]
+			if !ok {
+				continue
+			}
+			used := stats.Up + stats.Down
+			percent := int(used * 100 / connection.TrafficLimitBytes)
+
+			if percent >= 100 {
+				message := fmt.Sprintf("📊 Трафик VPN #%d израсходован полностью (%s).\n\nПродлите подключение в /vpn кнопкой «🔄 Продлить», чтобы восстановить доступ.",
+					connection.ID, FormatTrafficLimit(connection.TrafficLimitBytes))
+				s.remind(connection, "traffic_100", message)
+			} else if percent >= s.trafficReminderPercent {
+				message := fmt.Sprintf("📊 Израсходовано %d%% трафика VPN #%d (лимит %s).\n\nПродлить подключение можно в /vpn кнопкой «🔄 Продлить».",
+					percent, connection.ID, FormatTrafficLimit(connection.TrafficLimitBytes))
+				s.remind(connection, fmt.Sprintf("traffic_%d", s.trafficReminderPercent), message)
+			}
+		}
+	}
+}
+
+// serverTraffic возвращает статистику трафика клиентов сервера по email
+func (s *ExpirySchedulerService) serverTraffic(serverID int) (map[string]xui_client.ClientTraffic, error) {
+	server, err := s.serverService.GetServerByID(serverID)
+	if err != nil {
+		return nil, err
+	}
+	if server == nil || !server.IsActive {
+		return nil, fmt.Errorf("сервер недоступен")
+	}
+
+	xui := xui_client.NewClient(server.ServerURL, server.Username, server.Password)
+	if err := xui.Login(); err != nil {
+		return nil, fmt.Errorf("ошибка входа в x-ui: %w", err)
+	}
+	inbounds, err := xui.ListInbounds()
+	if err != nil {
+		return nil, err
+	}
+
+	traffic := make(map[string]xui_client.ClientTraffic)
+	for _, inbound := range inbounds {
+		for _, stats := range inbound.ClientStats {
+			traffic[stats.Email] = stats
+		}
+	}
+	return traffic, nil
+}
+
+// remind отправляет напоминание, если оно еще не отправлялось в текущем периоде подключения
+func (s *ExpirySchedulerService) remind(connection *VPNConnection, kind string, message string) {
+	recorded, err := s.vpnConnectionService.RecordReminder(connection.ID, kind)
+	if err != nil {
+		log.Printf("[ExpiryScheduler] %v", err)
+		return
+	}
+	if !recorded {
+		return
+	}
+
+	if err := s.telegramClient.SendMessage(connection.TelegramUserID, message); err != nil {
+		log.Printf("[ExpiryScheduler] Ошибка отправки напоминания %s пользователю %d: %v", kind, connection.TelegramUserID, err)
+		return
+	}
+	log.Printf("[ExpiryScheduler] Напоминание %s отправлено пользователю %d (VPN #%d)", kind, connection.TelegramUserID, connection.ID)
+}
+
+// formatTimeLeft форматирует оставшееся время для напоминания
+func formatTimeLeft(left time.Duration) string {
+	if days := int(left.Hours()) / 24; days > 0 {
+		return fmt.Sprintf("%d дн. %d ч.", days, int(left.Hours())%24)
+	}
+	if hours := int(left.Hours()); hours > 0 {
+		return fmt.Sprintf("%d ч.", hours)
+	}
+	return fmt.Sprintf("%d мин.", int(left.Minutes()))
+}
@@ -0,0 +1,230 @@
+package services
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// GiftStartPrefix префикс параметра /start в ссылке на подарок: gift_<код>
+const GiftStartPrefix = "gift_"
+
+// giftCodeAlphabet символы кода подарка: без похожих друг на друга 0/O и 1/I
+const giftCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// giftCodeLength длина кода подарка
+const giftCodeLength = 12
+
+// GiftStartParam возвращает параметр /start ссылки на подарок
+func GiftStartParam(code string) string {
+	return GiftStartPrefix + code
+}
+
+// ParseGiftStartParam возвращает код подарка из параметра /start
+func ParseGiftStartParam(param string) (string, bool) {
+	if !strings.HasPrefix(param, GiftStartPrefix) {
+		return "", false
+	}
+	code := strings.ToUpper(strings.TrimPrefix(param, GiftStartPrefix))
+	if code == "" {
+		return "", false
+	}
+	return code, true
+}
+
+// Gift подарочный VPN, оплаченный покупателем
+type Gift struct {
+	ID            int    `json:"id"`
+	Code          string `json:"code"`
+	TransactionID int    `json:"transaction_id"`
+	BuyerTgID     int64  `json:"buyer_tg_id"`
+	PlanID        int    `json:"plan_id"` // 0 — тариф удален
+	// DurationDays, TrafficGB и LimitIP — оплаченные условия, DurationDays включает дни промокода
+	DurationDays    int        `json:"duration_days"`
+	TrafficGB       int        `json:"traffic_gb"`
+	LimitIP         int        `json:"limit_ip"`
+	RedeemedByTgID  int64      `json:"redeemed_by_tg_id"` // 0 — не активирован
+	RedeemedAt      *time.Time `json:"redeemed_at"`
+	VPNConnectionID int        `json:"vpn_connection_id"`
+	CancelledAt     *time.Time `json:"cancelled_at"`
+	CreatedAt       time.Time  `json:"created_at"`
+}
+
+// ApplyTo возвращает тариф с оплаченными условиями подарка; plan == nil — тариф удален
+func (g *Gift) ApplyTo(plan *Plan) *Plan {
+	gifted := &Plan{ID: g.PlanID, Name: "Подарок"}
+	if plan != nil {
+		copied := *plan
+		gifted = &copied
+	}
+	gifted.DurationDays = g.DurationDays
+	gifted.TrafficGB = g.TrafficGB
+	gifted.LimitIP = g.LimitIP
+	return gifted
+}
+
+const giftColumns = `g.id, g.code, g.transaction_id, g.buyer_tg_id, COALESCE(g.plan_id, 0), g.duration_days, g.traffic_gb,
+	g.limit_ip, COALESCE(g.redeemed_by_tg_id, 0), g.redeemed_at, COALESCE(g.vpn_connection_id, 0), g.cancelled_at, g.created_at`
+
+// scanGift сканирует строку с колонками giftColumns
+func scanGift(row interface{ Scan(...interface{}) error }) (*Gift, error) {
+	gift := &Gift{}
+	err := row.Scan(&gift.ID, &gift.Code, &gift.TransactionID, &gift.BuyerTgID, &gift.PlanID, &gift.DurationDays, &gift.TrafficGB,
+		&gift.LimitIP, &gift.RedeemedByTgID, &gift.RedeemedAt, &gift.VPNConnectionID, &gift.CancelledAt, &gift.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return gift, nil
+}
+
+// GiftService управляет подарочными VPN
+type GiftService struct {
+	db *sql.DB
+}
+
+// NewGiftService создает новый сервис подарков
+func NewGiftService(db *sql.DB) *GiftService {
+	return &GiftService{db: db}
+}
+
+// newGiftCode генерирует случайный код подарка
+func newGiftCode() (string, error) {
+	buf := make([]byte, giftCodeLength)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("ошибка генерации кода подарка: %w", err)
+	}
+	for i, b := range buf {
+		buf[i] = giftCodeAlphabet[int(b)%len(giftCodeAlphabet)]
+	}
+	return string(buf), nil
+}
+
+// CreateGift создает код подарка для оплаченного платежа с условиями тарифа plan.
+// Повторный вызов для того же платежа возвращает уже созданный код.
+func (s *GiftService) CreateGift(payment *Transaction, plan *Plan) (*Gift, error) {
+	code, err := newGiftCode()
+	if err != nil {
+		return nil, err
+	}
+	_, err = s.db.Exec(`
+		INSERT INTO gift_codes (code, transaction_id, buyer_tg_id, plan_id, duration_days, traffic_gb, limit_ip)
+		VALUES ($1, $2, $3, NULLIF($4, 0), $5, $6, $7)
+		ON CONFLICT (transaction_id) DO NOTHING
+	`, code, payment.ID, payment.TelegramUserID, plan.ID, plan.DurationDays, plan.TrafficGB, plan.LimitIP)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка создания подарка: %w", err)
+	}
+	return s.GetGiftByTransaction(payment.ID)
+}
+
+// GetGiftByCode возвращает подарок по коду, nil — не найден
+func (s *GiftService) GetGiftByCode(code string) (*Gift, error) {
+	gift, err := scanGift(s.db.QueryRow(`SELECT `+giftColumns+` FROM gift_codes g WHERE g.code = $1`, code))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения подарка: %w", err)
+	}
+	return gift, nil
+}
+
+// GetGiftByTransaction возвращает подарок, оплаченный платежом, nil — платеж не за подарок
+func (s *GiftService) GetGiftByTransaction(transactionID int) (*Gift, error) {
+	gift, err := scanGift(s.db.QueryRow(`SELECT `+giftColumns+` FROM gift_codes g WHERE g.transaction_id = $1`, transactionID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения подарка: %w", err)
+	}
+	return gift, nil
+}
+
+// GetBuyerGifts возвращает последние подарки, купленные пользователем
+func (s *GiftService) GetBuyerGifts(buyerTgID int64, limit int) ([]*Gift, error) {
+	rows, err := s.db.Query(`
+		SELECT `+giftColumns+` FROM gift_codes g
+		WHERE g.buyer_tg_id = $1
+		ORDER BY g.created_at DESC
+		LIMIT $2
+	`, buyerTgID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения подарков: %w", err)
+	}
+	defer rows.Close()
+
+	var gifts []*Gift
+	for rows.Next() {
+		gift, err := scanGift(rows)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка сканирования подарка: %w", err)
+		}
+		gifts = append(gifts, gift)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка чтения подарков: %w", err)
+	}
+	return gifts, nil
+}
+
+// Claim закрепляет подарок за получателем. Код активируется один раз, только пока платеж
+// покупателя выполнен и не возвращен; активировать собственный подарок нельзя.
+// Возвращает подарок и telegram_payment_charge_id платежа покупателя, nil — активировать код нельзя.
+func (s *GiftService) Claim(code string, recipientTgID int64) (*Gift, string, error) {
+	var chargeID string
+	gift := &Gift{}
+	err := s.db.QueryRow(`
+		UPDATE gift_codes g SET redeemed_by_tg_id = $2, redeemed_at = CURRENT_TIMESTAMP
+		FROM transactions t
+		WHERE g.code = $1 AND t.id = g.transaction_id AND t.status = $3
+		  AND g.redeemed_by_tg_id IS NULL AND g.cancelled_at IS NULL AND g.buyer_tg_id <> $2
+		RETURNING `+giftColumns+`, t.telegram_payment_charge_id
+	`, code, recipientTgID, TransactionStatusFulfilled).Scan(&gift.ID, &gift.Code, &gift.TransactionID, &gift.BuyerTgID, &gift.PlanID,
+		&gift.DurationDays, &gift.TrafficGB, &gift.LimitIP, &gift.RedeemedByTgID, &gift.RedeemedAt, &gift.VPNConnectionID,
+		&gift.CancelledAt, &gift.CreatedAt, &chargeID)
+	if err == sql.ErrNoRows {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("ошибка активации подарка: %w", err)
+	}
+	return gift, chargeID, nil
+}
+
+// Unclaim снимает закрепление, если VPN по подарку создать не удалось
+func (s *GiftService) Unclaim(giftID int) error {
+	_, err := s.db.Exec(`
+		UPDATE gift_codes SET redeemed_by_tg_id = NULL, redeemed_at = NULL
+		WHERE id = $1 AND vpn_connection_id IS NULL
+	`, giftID)
+	if err != nil {
+		return fmt.Errorf("ошибка отмены активации подарка: %w", err)
+	}
+	return nil
+}
+
+// LinkConnection связывает подарок с созданным VPN получателя
+func (s *GiftService) LinkConnection(giftID int, connectionID int) error {
+	_, err := s.db.Exec(`UPDATE gift_codes SET vpn_connection_id = $2 WHERE id = $1`, giftID, connectionID)
+	if err != nil {
+		return fmt.Errorf("ошибка связывания подарка с подключением: %w", err)
+	}
+	return nil
+}
+
+// Cancel аннулирует неактивированный подарок возвращенного платежа.
+// Возвращает false, если подарка нет или он уже активирован.
+func (s *GiftService) Cancel(transactionID int) (bool, error) {
+	result, err := s.db.Exec(`
+		UPDATE gift_codes SET cancelled_at = CURRENT_TIMESTAMP
+		WHERE transaction_id = $1 AND redeemed_by_tg_id IS NULL AND cancelled_at IS NULL
+	`, transactionID)
+	if err != nil {
+		return false, fmt.Errorf("ошибка аннулирования подарка: %w", err)
+	}
+	rows, _ := result.RowsAffected()
+	return rows > 0, nil
+}
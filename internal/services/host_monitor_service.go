@@ -204,6 +204,7 @@ func (s *HostMonitorService) checkHost(server *XUIServer) HostStatus {
 	client := xui_client.NewClient(server.ServerURL, server.Username, server.Password)
 
 	// Пытаемся авторизоваться
+	startedAt := time.Now()
 	err := client.Login()
 	if err != nil {
 		status.IsActive = false
@@ -233,7 +234,11 @@ func (s *HostMonitorService) checkHost(server *XUIServer) HostStatus {
 		return status
 	}
 
-	log.Printf("[HostMonitor] Хост %s активен", server.ServerName)
+	latency := time.Since(startedAt)
+	log.Printf("[HostMonitor] Хост %s активен (ответ за %v)", server.ServerName, latency)
+	if updateErr := s.serverService.RecordLatency(server.ID, latency); updateErr != nil {
+		log.Printf("[HostMonitor] Ошибка сохранения задержки хоста %d: %v", server.ID, updateErr)
+	}
 	// Если сервер был неактивен, активируем его в базе и перечитываем
 	if !server.IsActive {
 		if updateErr := s.serverService.SetServerStatus(server.ID, true); updateErr != nil {
@@ -0,0 +1,254 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+)
+
+// Стратегии выбора хоста для нового VPN
+const (
+	HostStrategyLeastConnections   = "least_connections"
+	HostStrategyWeightedRoundRobin = "weighted_round_robin"
+	HostStrategyLowestLatency      = "lowest_latency"
+	HostStrategyLocation           = "location"
+)
+
+// ErrNoHostAvailable возвращается, когда среди кандидатов нет подходящего хоста
+var ErrNoHostAvailable = errors.New("нет доступных хостов")
+
+// HostSelectionRequest параметры выбора хоста
+type HostSelectionRequest struct {
+	TelegramUserID int64
+	// Location — выбранная пользователем локация (server_location), пустая — любая
+	Location string
+	// Strategy — стратегия тарифа, пустая — глобальная из конфигурации
+	Strategy string
+	// AllowedLocations — локации, доступные в тарифе, пустой список — все
+	AllowedLocations []string
+}
+
+// HostSelector выбирает хост из списка активных кандидатов
+type HostSelector interface {
+	Select(servers []*XUIServer, req HostSelectionRequest) (*XUIServer, error)
+}
+
+// ConnectionCounter возвращает количество активных подключений по серверам
+type ConnectionCounter interface {
+	CountActiveByServer() (map[int]int, error)
+}
+
+// LeastConnectionsSelector выбирает хост с наименьшим числом активных подключений
+type LeastConnectionsSelector struct {
+	counter ConnectionCounter
+}
+
+// NewLeastConnectionsSelector создает стратегию least_connections
+func NewLeastConnectionsSelector(counter ConnectionCounter) *LeastConnectionsSelector {
+	return &LeastConnectionsSelector{counter: counter}
+}
+
+// Select выбирает хост с наименьшим числом подключений, при равенстве — с меньшим ID
+func (s *LeastConnectionsSelector) Select(servers []*XUIServer, req HostSelectionRequest) (*XUIServer, error) {
+	if len(servers) == 0 {
+		return nil, ErrNoHostAvailable
+	}
+
+	counts, err := s.counter.CountActiveByServer()
+	if err != nil {
+		return nil, err
+	}
+
+	var best *XUIServer
+	for _, server := range servers {
+		if best == nil || counts[server.ID] < counts[best.ID] ||
+			(counts[server.ID] == counts[best.ID] && server.ID < best.ID) {
+			best = server
+		}
+	}
+	return best, nil
+}
+
+// WeightedRoundRobinSelector распределяет подключения пропорционально весам хостов
+// (плавный взвешенный round robin, как в nginx). Состояние хранится в памяти процесса.
+type WeightedRoundRobinSelector struct {
+	mu      sync.Mutex
+	current map[int]int
+}
+
+// NewWeightedRoundRobinSelector создает стратегию weighted_round_robin
+func NewWeightedRoundRobinSelector() *WeightedRoundRobinSelector {
+	return &WeightedRoundRobinSelector{current: make(map[int]int)}
+}
+
+// Select выбирает следующий хост по весам; хосты с весом 0 не выбираются
+func (s *WeightedRoundRobinSelector) Select(servers []*XUIServer, req HostSelectionRequest) (*XUIServer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var best *XUIServer
+	total := 0
+	for _, server := range servers {
+		if server.Weight <= 0 {
+			continue
+		}
+		total += server.Weight
+		s.current[server.ID] += server.Weight
+		if best == nil || s.current[server.ID] > s.current[best.ID] {
+			best = server
+		}
+	}
+	if best == nil {
+		return nil, ErrNoHostAvailable
+	}
+
+	s.current[best.ID] -= total
+	return best, nil
+}
+
+// LowestLatencySelector выбирает хост с наименьшим временем ответа по данным мониторинга.
+// Если задержка ни одного хоста еще не измерена, выбор передается fallback.
+type LowestLatencySelector struct {
+	fallback HostSelector
+}
+
+// NewLowestLatencySelector создает стратегию lowest_latency
+func NewLowestLatencySelector(fallback HostSelector) *LowestLatencySelector {
+	return &LowestLatencySelector{fallback: fallback}
+}
+
+// Select выбирает хост с минимальной последней задержкой
+func (s *LowestLatencySelector) Select(servers []*XUIServer, req HostSelectionRequest) (*XUIServer, error) {
+	var best *XUIServer
+	for _, server := range servers {
+		if server.LastLatencyMs <= 0 {
+			continue
+		}
+		if best == nil || server.LastLatencyMs < best.LastLatencyMs {
+			best = server
+		}
+	}
+	if best == nil {
+		return s.fallback.Select(servers, req)
+	}
+	return best, nil
+}
+
+// LocationSelector оставляет хосты выбранной пользователем локации
+// и передает выбор среди них стратегии inner
+type LocationSelector struct {
+	inner HostSelector
+}
+
+// NewLocationSelector создает стратегию location поверх стратегии inner
+func NewLocationSelector(inner HostSelector) *LocationSelector {
+	return &LocationSelector{inner: inner}
+}
+
+// Select выбирает хост в локации req.Location; без локации выбирает среди всех хостов
+func (s *LocationSelector) Select(servers []*XUIServer, req HostSelectionRequest) (*XUIServer, error) {
+	if req.Location == "" {
+		return s.inner.Select(servers, req)
+	}
+
+	var inLocation []*XUIServer
+	for _, server := range servers {
+		if strings.EqualFold(server.ServerLocation, req.Location) {
+			inLocation = append(inLocation, server)
+		}
+	}
+	if len(inLocation) == 0 {
+		return nil, fmt.Errorf("%w в локации %s", ErrNoHostAvailable, req.Location)
+	}
+	return s.inner.Select(inLocation, req)
+}
+
+// HostSelectionService выбирает активный хост для нового VPN по настроенной стратегии
+type HostSelectionService struct {
+	serverService   *XUIServerService
+	defaultStrategy string
+	selectors       map[string]HostSelector
+}
+
+// NewHostSelectionService создает сервис выбора хоста.
+// defaultStrategy — глобальная стратегия, неизвестное значение заменяется на least_connections.
+func NewHostSelectionService(serverService *XUIServerService, counter ConnectionCounter, defaultStrategy string) *HostSelectionService {
+	leastConnections := NewLeastConnectionsSelector(counter)
+	selectors := map[string]HostSelector{
+		HostStrategyLeastConnections:   leastConnections,
+		HostStrategyWeightedRoundRobin: NewWeightedRoundRobinSelector(),
+		HostStrategyLowestLatency:      NewLowestLatencySelector(leastConnections),
+		HostStrategyLocation:           NewLocationSelector(leastConnections),
+	}
+
+	if _, ok := selectors[defaultStrategy]; !ok {
+		if defaultStrategy != "" {
+			log.Printf("[HostSelection] Неизвестная стратегия %q, используется %s", defaultStrategy, HostStrategyLeastConnections)
+		}
+		defaultStrategy = HostStrategyLeastConnections
+	}
+
+	return &HostSelectionService{
+		serverService:   serverService,
+		defaultStrategy: defaultStrategy,
+		selectors:       selectors,
+	}
+}
+
+// IsValidStrategy проверяет, что стратегия выбора хоста поддерживается
+func (s *HostSelectionService) IsValidStrategy(strategy string) bool {
+	_, ok := s.selectors[strategy]
+	return ok
+}
+
+// SelectServer выбирает активный хост. Выбранная пользователем локация
+// учитывается при любой стратегии.
+func (s *HostSelectionService) SelectServer(req HostSelectionRequest) (*XUIServer, error) {
+	servers, err := s.serverService.GetActiveServers()
+	if err != nil {
+		return nil, err
+	}
+	return s.SelectFrom(servers, req)
+}
+
+// SelectFrom выбирает хост из переданных кандидатов
+func (s *HostSelectionService) SelectFrom(servers []*XUIServer, req HostSelectionRequest) (*XUIServer, error) {
+	if len(req.AllowedLocations) > 0 {
+		var allowed []*XUIServer
+		for _, server := range servers {
+			for _, location := range req.AllowedLocations {
+				if strings.EqualFold(server.ServerLocation, location) {
+					allowed = append(allowed, server)
+					break
+				}
+			}
+		}
+		servers = allowed
+	}
+	if len(servers) == 0 {
+		return nil, ErrNoHostAvailable
+	}
+
+	strategy := req.Strategy
+	if strategy == "" {
+		strategy = s.defaultStrategy
+	}
+	selector, ok := s.selectors[strategy]
+	if !ok {
+		return nil, fmt.Errorf("неизвестная стратегия выбора хоста: %s", strategy)
+	}
+	if _, isLocation := selector.(*LocationSelector); !isLocation && req.Location != "" {
+		selector = NewLocationSelector(selector)
+	}
+
+	server, err := selector.Select(servers, req)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("[HostSelection] Стратегия %s выбрала хост %s (ID %d) для пользователя %d",
+		strategy, server.ServerName, server.ID, req.TelegramUserID)
+	return server, nil
+}
@@ -0,0 +1,129 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// PoolInbound общий inbound хоста, в который добавляются клиенты в режиме shared
+type PoolInbound struct {
+	ID          int       `json:"id"`
+	ServerID    int       `json:"server_id"`
+	InboundID   int       `json:"inbound_id"`
+	Port        int       `json:"port"`
+	MaxClients  int       `json:"max_clients"`
+	IsActive    bool      `json:"is_active"`
+	ClientCount int       `json:"client_count"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// InboundPoolService управляет общими inbound хостов
+type InboundPoolService struct {
+	db *sql.DB
+}
+
+// NewInboundPoolService создает новый сервис общих inbound
+func NewInboundPoolService(db *sql.DB) *InboundPoolService {
+	return &InboundPoolService{db: db}
+}
+
+// poolInboundQuery выбирает pool inbound с количеством активных подключений
+const poolInboundQuery = `
+	SELECT p.id, p.server_id, p.inbound_id, p.port, p.max_clients, p.is_active, p.created_at,
+		   COUNT(c.id) AS client_count
+	FROM xui_pool_inbounds p
+	LEFT JOIN vpn_connections c
+		ON c.server_id = p.server_id AND c.inbound_id = p.inbound_id AND c.is_active = true
+`
+
+func scanPoolInbound(row rowScanner) (*PoolInbound, error) {
+	pool := &PoolInbound{}
+	err := row.Scan(
+		&pool.ID, &pool.ServerID, &pool.InboundID, &pool.Port, &pool.MaxClients,
+		&pool.IsActive, &pool.CreatedAt, &pool.ClientCount,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return pool, nil
+}
+
+// AddPoolInbound регистрирует inbound панели как общий inbound хоста
+func (s *InboundPoolService) AddPoolInbound(serverID, inboundID, port, maxClients int) (*PoolInbound, error) {
+	pool := &PoolInbound{
+		ServerID:   serverID,
+		InboundID:  inboundID,
+		Port:       port,
+		MaxClients: maxClients,
+		IsActive:   true,
+	}
+
+	err := s.db.QueryRow(`
+		INSERT INTO xui_pool_inbounds (server_id, inbound_id, port, max_clients)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (server_id, inbound_id) DO UPDATE SET
+			port = EXCLUDED.port,
+			max_clients = EXCLUDED.max_clients,
+			is_active = true,
+			updated_at = CURRENT_TIMESTAMP
+		RETURNING id, created_at
+	`, serverID, inboundID, port, maxClients).Scan(&pool.ID, &pool.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка добавления общего inbound: %w", err)
+	}
+
+	return pool, nil
+}
+
+// GetServerPoolInbounds возвращает общие inbound сервера с их загрузкой
+func (s *InboundPoolService) GetServerPoolInbounds(serverID int) ([]*PoolInbound, error) {
+	rows, err := s.db.Query(poolInboundQuery+`
+		WHERE p.server_id = $1
+		GROUP BY p.id
+		ORDER BY p.id
+	`, serverID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения общих inbound: %w", err)
+	}
+	defer rows.Close()
+
+	var pools []*PoolInbound
+	for rows.Next() {
+		pool, err := scanPoolInbound(rows)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка сканирования общего inbound: %w", err)
+		}
+		pools = append(pools, pool)
+	}
+
+	return pools, nil
+}
+
+// SelectLeastLoaded возвращает наименее загруженный активный inbound сервера,
+// в котором еще есть место, или nil, если такого нет
+func (s *InboundPoolService) SelectLeastLoaded(serverID int) (*PoolInbound, error) {
+	pool, err := scanPoolInbound(s.db.QueryRow(poolInboundQuery+`
+		WHERE p.server_id = $1 AND p.is_active = true
+		GROUP BY p.id
+		HAVING p.max_clients = 0 OR COUNT(c.id) < p.max_clients
+		ORDER BY client_count ASC, p.id ASC
+		LIMIT 1
+	`, serverID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ошибка выбора общего inbound: %w", err)
+	}
+	return pool, nil
+}
+
+// RemovePoolInbound удаляет общий inbound из пула (например, после удаления на панели)
+func (s *InboundPoolService) RemovePoolInbound(serverID, inboundID int) error {
+	_, err := s.db.Exec(`DELETE FROM xui_pool_inbounds WHERE server_id = $1 AND inbound_id = $2`, serverID, inboundID)
+	if err != nil {
+		return fmt.Errorf("ошибка удаления общего inbound: %w", err)
+	}
+	return nil
+}
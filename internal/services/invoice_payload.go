@@ -0,0 +1,77 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MaxInvoicePayloadBytes ограничение Telegram на размер payload счета
+const MaxInvoicePayloadBytes = 128
+
+// Действия, оплачиваемые счетом
+const (
+	PayloadActionCreateVPN = "create"
+	PayloadActionRenewVPN  = "renew"
+	PayloadActionTopUp     = "topup"
+	PayloadActionGiftVPN   = "gift"
+	// PayloadActionSubscribeVPN — подписка Telegram Stars на продление подключения ConnectionID
+	PayloadActionSubscribeVPN = "sub"
+)
+
+// legacyCreatePayloadPrefix формат payload счетов, выставленных до появления InvoicePayload
+const legacyCreatePayloadPrefix = "vpn_create_"
+
+// InvoicePayload данные, которые передаются через счет Telegram в successful_payment.
+// Поля сокращены, чтобы payload укладывался в MaxInvoicePayloadBytes.
+type InvoicePayload struct {
+	Action string `json:"a"`
+	UserID int64  `json:"u"`
+	// PlanID и PlanVersion — тариф и версия его условий на момент выставления счета
+	PlanID      int `json:"p,omitempty"`
+	PlanVersion int `json:"v,omitempty"`
+	// ServerID — сервер выбранной локации, локация определяется по нему при оплате:
+	// название локации произвольной длины в payload не помещается
+	ServerID int `json:"h,omitempty"`
+	// Location — название локации в счетах, выставленных до появления ServerID
+	Location string `json:"l,omitempty"`
+	// ConnectionID — продлеваемое VPN подключение (для PayloadActionRenewVPN и PayloadActionSubscribeVPN)
+	ConnectionID int `json:"c,omitempty"`
+	// PromoCodeID — промокод, со скидкой которого выставлен счет
+	PromoCodeID int `json:"d,omitempty"`
+	// Amount — сумма пополнения баланса (для PayloadActionTopUp)
+	Amount int `json:"s,omitempty"`
+}
+
+// Encode сериализует payload для sendInvoice
+func (p *InvoicePayload) Encode() (string, error) {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return "", fmt.Errorf("ошибка формирования payload счета: %w", err)
+	}
+	if len(data) > MaxInvoicePayloadBytes {
+		return "", fmt.Errorf("payload счета превышает %d байт: %d", MaxInvoicePayloadBytes, len(data))
+	}
+	return string(data), nil
+}
+
+// ParseInvoicePayload разбирает payload счета, включая старый формат vpn_create_<id>
+func ParseInvoicePayload(raw string) (*InvoicePayload, error) {
+	if strings.HasPrefix(raw, legacyCreatePayloadPrefix) {
+		userID, err := strconv.ParseInt(strings.TrimPrefix(raw, legacyCreatePayloadPrefix), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("неверный payload счета %q: %w", raw, err)
+		}
+		return &InvoicePayload{Action: PayloadActionCreateVPN, UserID: userID}, nil
+	}
+
+	payload := &InvoicePayload{}
+	if err := json.Unmarshal([]byte(raw), payload); err != nil {
+		return nil, fmt.Errorf("неверный payload счета %q: %w", raw, err)
+	}
+	if payload.Action == "" {
+		return nil, fmt.Errorf("в payload счета не указано действие")
+	}
+	return payload, nil
+}
@@ -0,0 +1,358 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Виды операций с балансом
+const (
+	LedgerKindTopUp      = "topup"      // пополнение через Telegram Stars
+	LedgerKindPurchase   = "purchase"   // покупка с баланса
+	LedgerKindRefund     = "refund"     // возврат покупки на баланс
+	LedgerKindBonus      = "bonus"      // награда за приглашение
+	LedgerKindAdjustment = "adjustment" // ручное изменение администратором
+	LedgerKindWithdrawal = "withdrawal" // списание при возврате пополнения в Telegram
+)
+
+// Системные счета, с которыми проводятся операции по счетам пользователей
+const (
+	LedgerAccountStars       = "system:stars"
+	LedgerAccountSales       = "system:sales"
+	LedgerAccountBonuses     = "system:bonuses"
+	LedgerAccountAdjustments = "system:adjustments"
+)
+
+// Пределы одного пополнения баланса в звездах
+const (
+	MinTopUpStars = 1
+	MaxTopUpStars = 10000
+)
+
+// ErrInsufficientBalance операция сделала бы баланс пользователя отрицательным
+var ErrInsufficientBalance = errors.New("недостаточно средств на балансе")
+
+// LedgerEntry операция с балансом пользователя
+type LedgerEntry struct {
+	ID             int       `json:"id"`
+	Kind           string    `json:"kind"`
+	TelegramUserID int64     `json:"telegram_user_id"`
+	Amount         int       `json:"amount"` // > 0 — зачисление, < 0 — списание
+	BalanceAfter   int       `json:"balance_after"`
+	TransactionID  int       `json:"transaction_id"` // 0 — без платежа
+	Description    string    `json:"description"`
+	CreatedByTgID  int64     `json:"created_by_tg_id"` // 0 — операция выполнена ботом
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// ledgerOperation перевод amount звезд со счета from на счет to
+type ledgerOperation struct {
+	kind           string
+	telegramUserID int64
+	amount         int
+	from, to       string
+	transactionID  int
+	description    string
+	createdByTgID  int64
+	// idempotencyKey - повторная операция с тем же ключом не выполняется, пустой — без проверки
+	idempotencyKey string
+}
+
+// userAccountCode код счета баланса пользователя
+func userAccountCode(telegramUserID int64) string {
+	return "user:" + strconv.FormatInt(telegramUserID, 10)
+}
+
+// postLedgerEntry записывает операцию и две проводки с противоположными суммами в транзакции tx.
+// Возвращает false, если операция с тем же ключом уже записана.
+func postLedgerEntry(tx *sql.Tx, op ledgerOperation) (bool, error) {
+	if op.amount <= 0 {
+		return false, fmt.Errorf("сумма операции должна быть больше 0")
+	}
+
+	var entryID int
+	err := tx.QueryRow(`
+		INSERT INTO ledger_entries (kind, telegram_user_id, amount, transaction_id, description, created_by_tg_id, idempotency_key)
+		VALUES ($1, $2, $3, NULLIF($4, 0), $5, NULLIF($6, 0), NULLIF($7, ''))
+		ON CONFLICT (idempotency_key) DO NOTHING
+		RETURNING id
+	`, op.kind, op.telegramUserID, op.amount, op.transactionID, op.description, op.createdByTgID, op.idempotencyKey).Scan(&entryID)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("ошибка записи операции с балансом: %w", err)
+	}
+
+	if _, err := tx.Exec(`
+		INSERT INTO balance_accounts (code, telegram_user_id) VALUES ($1, $2)
+		ON CONFLICT (code) DO NOTHING
+	`, userAccountCode(op.telegramUserID), op.telegramUserID); err != nil {
+		return false, fmt.Errorf("ошибка создания счета пользователя %d: %w", op.telegramUserID, err)
+	}
+
+	// Счета блокируются в порядке id, чтобы встречные операции не ждали друг друга
+	rows, err := tx.Query(`
+		SELECT id, code FROM balance_accounts WHERE code IN ($1, $2) ORDER BY id FOR UPDATE
+	`, op.from, op.to)
+	if err != nil {
+		return false, fmt.Errorf("ошибка блокировки счетов: %w", err)
+	}
+	accounts := make(map[string]int)
+	for rows.Next() {
+		var id int
+		var code string
+		if err := rows.Scan(&id, &code); err != nil {
+			rows.Close()
+			return false, fmt.Errorf("ошибка чтения счета: %w", err)
+		}
+		accounts[code] = id
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return false, fmt.Errorf("ошибка чтения счетов: %w", err)
+	}
+	if accounts[op.from] == 0 || accounts[op.to] == 0 || op.from == op.to {
+		return false, fmt.Errorf("неверные счета операции: %s → %s", op.from, op.to)
+	}
+
+	postings := []struct {
+		accountID int
+		amount    int
+	}{
+		{accounts[op.from], -op.amount},
+		{accounts[op.to], op.amount},
+	}
+	for _, posting := range postings {
+		var balance int
+		err := tx.QueryRow(`
+			UPDATE balance_accounts SET balance = balance + $2, updated_at = CURRENT_TIMESTAMP
+			WHERE id = $1 AND (telegram_user_id IS NULL OR balance + $2 >= 0)
+			RETURNING balance
+		`, posting.accountID, posting.amount).Scan(&balance)
+		if err == sql.ErrNoRows {
+			return false, ErrInsufficientBalance
+		}
+		if err != nil {
+			return false, fmt.Errorf("ошибка изменения баланса: %w", err)
+		}
+		if _, err := tx.Exec(`
+			INSERT INTO ledger_postings (entry_id, account_id, amount, balance_after) VALUES ($1, $2, $3, $4)
+		`, entryID, posting.accountID, posting.amount, balance); err != nil {
+			return false, fmt.Errorf("ошибка записи проводки: %w", err)
+		}
+	}
+	return true, nil
+}
+
+// LedgerService управляет внутренним балансом пользователей. Каждое изменение баланса —
+// операция в ledger_entries с двумя проводками в одной транзакции БД.
+type LedgerService struct {
+	db *sql.DB
+}
+
+// NewLedgerService создает новый сервис баланса
+func NewLedgerService(db *sql.DB) *LedgerService {
+	return &LedgerService{db: db}
+}
+
+// post выполняет одну операцию в отдельной транзакции БД
+func (s *LedgerService) post(op ledgerOperation) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("ошибка начала транзакции: %w", err)
+	}
+	defer tx.Rollback()
+
+	posted, err := postLedgerEntry(tx, op)
+	if err != nil {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("ошибка сохранения операции с балансом: %w", err)
+	}
+	return posted, nil
+}
+
+// GetBalance возвращает баланс пользователя в звездах
+func (s *LedgerService) GetBalance(telegramUserID int64) (int, error) {
+	var balance int
+	err := s.db.QueryRow(`SELECT balance FROM balance_accounts WHERE telegram_user_id = $1`, telegramUserID).Scan(&balance)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("ошибка получения баланса: %w", err)
+	}
+	return balance, nil
+}
+
+// GetEntries возвращает последние операции с балансом пользователя (новые первыми)
+func (s *LedgerService) GetEntries(telegramUserID int64, limit int) ([]*LedgerEntry, error) {
+	rows, err := s.db.Query(`
+		SELECT e.id, e.kind, e.telegram_user_id, p.amount, p.balance_after, COALESCE(e.transaction_id, 0),
+		       COALESCE(e.description, ''), COALESCE(e.created_by_tg_id, 0), e.created_at
+		FROM ledger_entries e
+		JOIN ledger_postings p ON p.entry_id = e.id
+		JOIN balance_accounts a ON a.id = p.account_id AND a.telegram_user_id = $1
+		ORDER BY e.id DESC
+		LIMIT $2
+	`, telegramUserID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения операций с балансом: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []*LedgerEntry
+	for rows.Next() {
+		entry := &LedgerEntry{}
+		if err := rows.Scan(&entry.ID, &entry.Kind, &entry.TelegramUserID, &entry.Amount, &entry.BalanceAfter,
+			&entry.TransactionID, &entry.Description, &entry.CreatedByTgID, &entry.CreatedAt); err != nil {
+			return nil, fmt.Errorf("ошибка сканирования операции с балансом: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка чтения операций с балансом: %w", err)
+	}
+	return entries, nil
+}
+
+// TopUp зачисляет на баланс оплаченное пополнение. Повторный вызов для того же платежа
+// ничего не меняет и возвращает false.
+func (s *LedgerService) TopUp(payment *Transaction) (bool, error) {
+	return s.post(ledgerOperation{
+		kind:           LedgerKindTopUp,
+		telegramUserID: payment.TelegramUserID,
+		amount:         payment.Amount,
+		from:           LedgerAccountStars,
+		to:             userAccountCode(payment.TelegramUserID),
+		transactionID:  payment.ID,
+		description:    "Пополнение через Telegram Stars",
+		idempotencyKey: fmt.Sprintf("topup:%d", payment.ID),
+	})
+}
+
+// PayFromBalance записывает платеж со способом оплаты balance и списывает его сумму
+// с баланса в одной транзакции БД. ErrInsufficientBalance — средств недостаточно,
+// платеж в этом случае не записывается.
+func (s *LedgerService) PayFromBalance(payment *Transaction) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("ошибка начала транзакции: %w", err)
+	}
+	defer tx.Rollback()
+
+	payment.PaymentMethod = PaymentMethodBalance
+	if err := insertPayment(tx, payment); err != nil {
+		return fmt.Errorf("ошибка регистрации платежа с баланса: %w", err)
+	}
+	if _, err := postLedgerEntry(tx, ledgerOperation{
+		kind:           LedgerKindPurchase,
+		telegramUserID: payment.TelegramUserID,
+		amount:         payment.Amount,
+		from:           userAccountCode(payment.TelegramUserID),
+		to:             LedgerAccountSales,
+		transactionID:  payment.ID,
+		description:    payment.Reason,
+		idempotencyKey: fmt.Sprintf("purchase:%d", payment.ID),
+	}); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("ошибка сохранения платежа с баланса: %w", err)
+	}
+	return nil
+}
+
+// RefundToBalance возвращает на баланс сумму покупки, оплаченной с баланса.
+// Повторный возврат того же платежа не выполняется.
+func (s *LedgerService) RefundToBalance(payment *Transaction, reason string, adminTgID int64) error {
+	_, err := s.post(ledgerOperation{
+		kind:           LedgerKindRefund,
+		telegramUserID: payment.TelegramUserID,
+		amount:         payment.Amount,
+		from:           LedgerAccountSales,
+		to:             userAccountCode(payment.TelegramUserID),
+		transactionID:  payment.ID,
+		description:    reason,
+		createdByTgID:  adminTgID,
+		idempotencyKey: fmt.Sprintf("refund:%d", payment.ID),
+	})
+	return err
+}
+
+// WithdrawTopUp списывает с баланса пополнение перед его возвратом в Telegram.
+// ErrInsufficientBalance — пополнение уже потрачено. Повторы не отсекаются ключом:
+// после отмены списания CancelWithdrawal возврат можно выполнить снова.
+func (s *LedgerService) WithdrawTopUp(topUp *Transaction, adminTgID int64) error {
+	_, err := s.post(ledgerOperation{
+		kind:           LedgerKindWithdrawal,
+		telegramUserID: topUp.TelegramUserID,
+		amount:         topUp.Amount,
+		from:           userAccountCode(topUp.TelegramUserID),
+		to:             LedgerAccountStars,
+		transactionID:  topUp.ID,
+		description:    "Возврат пополнения в Telegram Stars",
+		createdByTgID:  adminTgID,
+	})
+	return err
+}
+
+// CancelWithdrawal возвращает на баланс пополнение, списанное WithdrawTopUp,
+// если вернуть звезды в Telegram не удалось
+func (s *LedgerService) CancelWithdrawal(topUp *Transaction, adminTgID int64) error {
+	_, err := s.post(ledgerOperation{
+		kind:           LedgerKindAdjustment,
+		telegramUserID: topUp.TelegramUserID,
+		amount:         topUp.Amount,
+		from:           LedgerAccountStars,
+		to:             userAccountCode(topUp.TelegramUserID),
+		transactionID:  topUp.ID,
+		description:    "Отмена возврата пополнения: Telegram не вернул звезды",
+		createdByTgID:  adminTgID,
+	})
+	return err
+}
+
+// Adjust изменяет баланс пользователя вручную: amount > 0 — зачисление, < 0 — списание
+func (s *LedgerService) Adjust(telegramUserID int64, amount int, description string, adminTgID int64) error {
+	op := ledgerOperation{
+		kind:           LedgerKindAdjustment,
+		telegramUserID: telegramUserID,
+		amount:         amount,
+		from:           LedgerAccountAdjustments,
+		to:             userAccountCode(telegramUserID),
+		description:    description,
+		createdByTgID:  adminTgID,
+	}
+	if amount < 0 {
+		op.amount = -amount
+		op.from, op.to = op.to, op.from
+	}
+	_, err := s.post(op)
+	return err
+}
+
+// FormatLedgerKind возвращает название вида операции для пользователя
+func FormatLedgerKind(kind string) string {
+	switch kind {
+	case LedgerKindTopUp:
+		return "Пополнение"
+	case LedgerKindPurchase:
+		return "Покупка"
+	case LedgerKindRefund:
+		return "Возврат"
+	case LedgerKindBonus:
+		return "Бонус"
+	case LedgerKindAdjustment:
+		return "Корректировка"
+	case LedgerKindWithdrawal:
+		return "Вывод"
+	default:
+		return kind
+	}
+}
@@ -0,0 +1,219 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/lib/pq"
+)
+
+// maxPlanNameLength максимальная длина названия тарифа (заголовок счета Telegram)
+const maxPlanNameLength = 32
+
+// Plan тариф VPN
+type Plan struct {
+	ID           int    `json:"id"`
+	Name         string `json:"name"`
+	PriceStars   int    `json:"price_stars"`
+	DurationDays int    `json:"duration_days"` // 0 — бессрочно
+	TrafficGB    int    `json:"traffic_gb"`    // 0 — без ограничения
+	LimitIP      int    `json:"limit_ip"`      // 0 — без ограничения
+	// AllowedLocations - доступные локации, пустой список — все
+	AllowedLocations []string `json:"allowed_locations"`
+	// HostStrategy - стратегия выбора хоста, пустая — глобальная
+	HostStrategy string    `json:"host_strategy"`
+	IsActive     bool      `json:"is_active"`
+	Version      int       `json:"version"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// ProvisionSpec возвращает параметры клиента по условиям тарифа
+func (p *Plan) ProvisionSpec(flow string) ProvisionSpec {
+	return ProvisionSpec{
+		TrafficLimitBytes: int64(p.TrafficGB) * bytesInGB,
+		Duration:          time.Duration(p.DurationDays) * 24 * time.Hour,
+		LimitIP:           p.LimitIP,
+		Flow:              flow,
+		PlanID:            p.ID,
+	}
+}
+
+// AllowsLocation проверяет, доступна ли локация в тарифе
+func (p *Plan) AllowsLocation(location string) bool {
+	if len(p.AllowedLocations) == 0 {
+		return true
+	}
+	for _, allowed := range p.AllowedLocations {
+		if strings.EqualFold(allowed, location) {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate проверяет условия тарифа
+func (p *Plan) Validate() error {
+	if strings.TrimSpace(p.Name) == "" {
+		return fmt.Errorf("не указано название тарифа")
+	}
+	// Название используется как заголовок счета, Telegram ограничивает его 32 символами
+	if utf8.RuneCountInString(p.Name) > maxPlanNameLength {
+		return fmt.Errorf("название тарифа длиннее %d символов", maxPlanNameLength)
+	}
+	if p.PriceStars <= 0 {
+		return fmt.Errorf("цена должна быть больше 0")
+	}
+	if p.DurationDays < 0 || p.TrafficGB < 0 || p.LimitIP < 0 {
+		return fmt.Errorf("срок, трафик и лимит устройств не могут быть отрицательными")
+	}
+	return nil
+}
+
+// PlanService управляет тарифами
+type PlanService struct {
+	db *sql.DB
+}
+
+// NewPlanService создает новый сервис тарифов
+func NewPlanService(db *sql.DB) *PlanService {
+	return &PlanService{db: db}
+}
+
+// planColumns список колонок для scanPlan
+const planColumns = `
+	id, name, price_stars, duration_days, traffic_gb, limit_ip,
+	allowed_locations, host_strategy, is_active, version, created_at, updated_at
+`
+
+func scanPlan(row rowScanner) (*Plan, error) {
+	plan := &Plan{}
+	err := row.Scan(
+		&plan.ID, &plan.Name, &plan.PriceStars, &plan.DurationDays, &plan.TrafficGB, &plan.LimitIP,
+		pq.Array(&plan.AllowedLocations), &plan.HostStrategy, &plan.IsActive, &plan.Version,
+		&plan.CreatedAt, &plan.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// CreatePlan добавляет новый тариф
+func (s *PlanService) CreatePlan(plan *Plan) error {
+	if err := plan.Validate(); err != nil {
+		return err
+	}
+	if plan.AllowedLocations == nil {
+		plan.AllowedLocations = []string{}
+	}
+
+	err := s.db.QueryRow(`
+		INSERT INTO plans (name, price_stars, duration_days, traffic_gb, limit_ip, allowed_locations, host_strategy, is_active)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, version, created_at, updated_at
+	`, plan.Name, plan.PriceStars, plan.DurationDays, plan.TrafficGB, plan.LimitIP,
+		pq.Array(plan.AllowedLocations), plan.HostStrategy, plan.IsActive,
+	).Scan(&plan.ID, &plan.Version, &plan.CreatedAt, &plan.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("ошибка добавления тарифа: %w", err)
+	}
+	return nil
+}
+
+// UpdatePlan изменяет условия тарифа и увеличивает его версию
+func (s *PlanService) UpdatePlan(plan *Plan) error {
+	if err := plan.Validate(); err != nil {
+		return err
+	}
+	if plan.AllowedLocations == nil {
+		plan.AllowedLocations = []string{}
+	}
+
+	err := s.db.QueryRow(`
+		UPDATE plans SET
+			name = $2, price_stars = $3, duration_days = $4, traffic_gb = $5, limit_ip = $6,
+			allowed_locations = $7, host_strategy = $8,
+			version = version + 1,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		RETURNING version, updated_at
+	`, plan.ID, plan.Name, plan.PriceStars, plan.DurationDays, plan.TrafficGB, plan.LimitIP,
+		pq.Array(plan.AllowedLocations), plan.HostStrategy,
+	).Scan(&plan.Version, &plan.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("тариф с ID %d не найден", plan.ID)
+	}
+	if err != nil {
+		return fmt.Errorf("ошибка обновления тарифа: %w", err)
+	}
+	return nil
+}
+
+// SetPlanActive включает или отключает продажу тарифа
+func (s *PlanService) SetPlanActive(id int, active bool) error {
+	result, err := s.db.Exec(`
+		UPDATE plans SET is_active = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $1
+	`, id, active)
+	if err != nil {
+		return fmt.Errorf("ошибка обновления статуса тарифа: %w", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return fmt.Errorf("тариф с ID %d не найден", id)
+	}
+	return nil
+}
+
+// GetPlanByID возвращает тариф по ID или nil, если он не найден
+func (s *PlanService) GetPlanByID(id int) (*Plan, error) {
+	plan, err := scanPlan(s.db.QueryRow(`SELECT `+planColumns+` FROM plans WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения тарифа: %w", err)
+	}
+	return plan, nil
+}
+
+// GetActivePlans возвращает тарифы, доступные для покупки, по возрастанию цены
+func (s *PlanService) GetActivePlans() ([]*Plan, error) {
+	return s.queryPlans(`SELECT ` + planColumns + ` FROM plans WHERE is_active = true ORDER BY price_stars, id`)
+}
+
+// GetAllPlans возвращает все тарифы
+func (s *PlanService) GetAllPlans() ([]*Plan, error) {
+	return s.queryPlans(`SELECT ` + planColumns + ` FROM plans ORDER BY is_active DESC, price_stars, id`)
+}
+
+func (s *PlanService) queryPlans(query string) ([]*Plan, error) {
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения тарифов: %w", err)
+	}
+	defer rows.Close()
+
+	var plans []*Plan
+	for rows.Next() {
+		plan, err := scanPlan(rows)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка сканирования тарифа: %w", err)
+		}
+		plans = append(plans, plan)
+	}
+	return plans, nil
+}
+
+// FormatPlan форматирует условия тарифа для пользователя
+func FormatPlan(plan *Plan) string {
+	duration := "бессрочно"
+	if plan.DurationDays > 0 {
+		duration = fmt.Sprintf("%d дн.", plan.DurationDays)
+	}
+	return fmt.Sprintf("%s — %d ⭐, %s, трафик: %s, устройств: %s",
+		plan.Name, plan.PriceStars, duration,
+		FormatTrafficLimit(int64(plan.TrafficGB)*bytesInGB), FormatLimitIP(plan.LimitIP))
+}
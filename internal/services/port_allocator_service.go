@@ -0,0 +1,189 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+
+	"TelegramXUI/internal/xui_client"
+
+	"github.com/lib/pq"
+)
+
+// Статусы записей реестра портов
+const (
+	PortStatusReserved = "reserved"
+	PortStatusBound    = "bound"
+	PortStatusExternal = "external"
+)
+
+// ErrPortRangeExhausted возвращается, когда в диапазоне не осталось свободных портов
+var ErrPortRangeExhausted = errors.New("нет свободных портов в диапазоне")
+
+// stalePortReservation время, после которого резерв без inbound на панели считается брошенным
+const stalePortReservation = "10 minutes"
+
+// PortAllocatorService выделяет порты inbound на x-ui серверах без коллизий
+type PortAllocatorService struct {
+	db *sql.DB
+}
+
+// NewPortAllocatorService создает новый сервис выделения портов
+func NewPortAllocatorService(db *sql.DB) *PortAllocatorService {
+	return &PortAllocatorService{db: db}
+}
+
+// Reserve резервирует случайный свободный порт сервера в диапазоне [start, end).
+// Выбор и запись выполняются в одной транзакции под advisory-блокировкой сервера.
+func (s *PortAllocatorService) Reserve(serverID, start, end int) (int, error) {
+	if end <= start {
+		return 0, fmt.Errorf("неверный диапазон портов %d-%d", start, end)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("ошибка начала транзакции: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock(hashtext('xui_port_allocations'), $1)`, serverID); err != nil {
+		return 0, fmt.Errorf("ошибка блокировки портов сервера: %w", err)
+	}
+
+	var port int
+	err = tx.QueryRow(`
+		SELECT p FROM generate_series($2::int, $3::int - 1) AS p
+		WHERE NOT EXISTS (
+			SELECT 1 FROM xui_port_allocations a WHERE a.server_id = $1 AND a.port = p
+		)
+		AND p <> COALESCE((SELECT server_port FROM xui_servers WHERE id = $1), 0)
+		ORDER BY random()
+		LIMIT 1
+	`, serverID, start, end).Scan(&port)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("%w %d-%d на сервере %d", ErrPortRangeExhausted, start, end-1, serverID)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("ошибка выбора свободного порта: %w", err)
+	}
+
+	if _, err := tx.Exec(`
+		INSERT INTO xui_port_allocations (server_id, port, status) VALUES ($1, $2, $3)
+	`, serverID, port, PortStatusReserved); err != nil {
+		return 0, fmt.Errorf("ошибка резервирования порта: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("ошибка сохранения резерва порта: %w", err)
+	}
+
+	return port, nil
+}
+
+// HasFreePort проверяет, остался ли на сервере свободный порт в диапазоне [start, end).
+// Порт не резервируется, поэтому результат может устареть к моменту Reserve.
+func (s *PortAllocatorService) HasFreePort(serverID, start, end int) (bool, error) {
+	if end <= start {
+		return false, fmt.Errorf("неверный диапазон портов %d-%d", start, end)
+	}
+
+	var free bool
+	err := s.db.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM generate_series($2::int, $3::int - 1) AS p
+			WHERE NOT EXISTS (
+				SELECT 1 FROM xui_port_allocations a WHERE a.server_id = $1 AND a.port = p
+			)
+			AND p <> COALESCE((SELECT server_port FROM xui_servers WHERE id = $1), 0)
+		)
+	`, serverID, start, end).Scan(&free)
+	if err != nil {
+		return false, fmt.Errorf("ошибка проверки свободных портов: %w", err)
+	}
+	return free, nil
+}
+
+// Bind привязывает зарезервированный порт к созданному inbound
+func (s *PortAllocatorService) Bind(serverID, port, inboundID int) error {
+	_, err := s.db.Exec(`
+		INSERT INTO xui_port_allocations (server_id, port, inbound_id, status)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (server_id, port) DO UPDATE SET
+			inbound_id = EXCLUDED.inbound_id,
+			status = EXCLUDED.status,
+			updated_at = CURRENT_TIMESTAMP
+	`, serverID, port, inboundID, PortStatusBound)
+	if err != nil {
+		return fmt.Errorf("ошибка привязки порта: %w", err)
+	}
+	return nil
+}
+
+// Release освобождает порт сервера
+func (s *PortAllocatorService) Release(serverID, port int) error {
+	_, err := s.db.Exec(`DELETE FROM xui_port_allocations WHERE server_id = $1 AND port = $2`, serverID, port)
+	if err != nil {
+		return fmt.Errorf("ошибка освобождения порта: %w", err)
+	}
+	return nil
+}
+
+// ReleaseInbound освобождает порт удаленного inbound
+func (s *PortAllocatorService) ReleaseInbound(serverID, inboundID int) error {
+	_, err := s.db.Exec(`DELETE FROM xui_port_allocations WHERE server_id = $1 AND inbound_id = $2`, serverID, inboundID)
+	if err != nil {
+		return fmt.Errorf("ошибка освобождения порта inbound: %w", err)
+	}
+	return nil
+}
+
+// Reconcile сверяет реестр с фактическим списком inbound панели:
+// порты, занятые на панели, помечаются занятыми, а порты удаленных inbound
+// и брошенные резервы освобождаются
+func (s *PortAllocatorService) Reconcile(serverID int, inbounds []xui_client.Inbound) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("ошибка начала транзакции: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock(hashtext('xui_port_allocations'), $1)`, serverID); err != nil {
+		return fmt.Errorf("ошибка блокировки портов сервера: %w", err)
+	}
+
+	ports := make([]int64, 0, len(inbounds))
+	for _, inbound := range inbounds {
+		ports = append(ports, int64(inbound.Port))
+		_, err := tx.Exec(`
+			INSERT INTO xui_port_allocations (server_id, port, inbound_id, status)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (server_id, port) DO UPDATE SET
+				inbound_id = EXCLUDED.inbound_id,
+				status = CASE WHEN xui_port_allocations.status = 'external' THEN 'external' ELSE 'bound' END,
+				updated_at = CURRENT_TIMESTAMP
+		`, serverID, inbound.Port, inbound.ID, PortStatusExternal)
+		if err != nil {
+			return fmt.Errorf("ошибка сверки порта %d: %w", inbound.Port, err)
+		}
+	}
+
+	result, err := tx.Exec(`
+		DELETE FROM xui_port_allocations
+		WHERE server_id = $1
+		  AND NOT (port = ANY($2::bigint[]))
+		  AND (status <> 'reserved' OR created_at < CURRENT_TIMESTAMP - $3::interval)
+	`, serverID, pq.Array(ports), stalePortReservation)
+	if err != nil {
+		return fmt.Errorf("ошибка освобождения неиспользуемых портов: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("ошибка сохранения сверки портов: %w", err)
+	}
+
+	if released, _ := result.RowsAffected(); released > 0 {
+		log.Printf("[PortAllocator] Сервер %d: освобождено портов после сверки с панелью: %d", serverID, released)
+	}
+	return nil
+}
@@ -0,0 +1,376 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Типы скидки промокода
+const (
+	PromoDiscountPercent = "percent"
+	PromoDiscountFixed   = "fixed"
+	PromoDiscountDays    = "free_days"
+)
+
+// maxPromoCodeLength максимальная длина промокода
+const maxPromoCodeLength = 32
+
+// PromoCode промокод
+type PromoCode struct {
+	ID            int    `json:"id"`
+	Code          string `json:"code"`
+	DiscountType  string `json:"discount_type"`
+	DiscountValue int    `json:"discount_value"` // проценты, звезды или дни
+	MaxUses       int    `json:"max_uses"`       // 0 — без ограничения
+	UsedCount     int    `json:"used_count"`
+	// AllowedPlanIDs - тарифы, к которым применим код, пустой список — все
+	AllowedPlanIDs []int64    `json:"allowed_plan_ids"`
+	ExpiresAt      *time.Time `json:"expires_at"`
+	IsActive       bool       `json:"is_active"`
+	CreatedByTgID  int64      `json:"created_by_tg_id"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// NormalizePromoCode приводит введенный код к виду, в котором он хранится
+func NormalizePromoCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// Validate проверяет параметры промокода
+func (p *PromoCode) Validate() error {
+	if p.Code == "" || len(p.Code) > maxPromoCodeLength || strings.ContainsAny(p.Code, " ;") {
+		return fmt.Errorf("код должен быть не длиннее %d символов, без пробелов и «;»", maxPromoCodeLength)
+	}
+	switch p.DiscountType {
+	case PromoDiscountPercent:
+		if p.DiscountValue <= 0 || p.DiscountValue >= 100 {
+			return fmt.Errorf("скидка в процентах должна быть от 1 до 99")
+		}
+	case PromoDiscountFixed, PromoDiscountDays:
+		if p.DiscountValue <= 0 {
+			return fmt.Errorf("значение скидки должно быть больше 0")
+		}
+	default:
+		return fmt.Errorf("тип скидки должен быть %s, %s или %s", PromoDiscountPercent, PromoDiscountFixed, PromoDiscountDays)
+	}
+	if p.MaxUses < 0 {
+		return fmt.Errorf("лимит использований не может быть отрицательным")
+	}
+	return nil
+}
+
+// AllowsPlan проверяет, применим ли промокод к тарифу
+func (p *PromoCode) AllowsPlan(planID int) bool {
+	if len(p.AllowedPlanIDs) == 0 {
+		return true
+	}
+	for _, id := range p.AllowedPlanIDs {
+		if int(id) == planID {
+			return true
+		}
+	}
+	return false
+}
+
+// AppliesTo проверяет, что промокод дает выгоду по тарифу: дополнительные дни
+// не применяются к бессрочному тарифу
+func (p *PromoCode) AppliesTo(plan *Plan) bool {
+	if !p.AllowsPlan(plan.ID) {
+		return false
+	}
+	return p.DiscountType != PromoDiscountDays || plan.DurationDays > 0
+}
+
+// Apply возвращает цену со скидкой. Счет Telegram Stars не может быть меньше 1 звезды.
+func (p *PromoCode) Apply(price int) int {
+	switch p.DiscountType {
+	case PromoDiscountPercent:
+		price = price * (100 - p.DiscountValue) / 100
+	case PromoDiscountFixed:
+		price -= p.DiscountValue
+	}
+	if price < 1 {
+		price = 1
+	}
+	return price
+}
+
+// BonusDays возвращает дополнительные дни, которые дает промокод
+func (p *PromoCode) BonusDays() int {
+	if p.DiscountType == PromoDiscountDays {
+		return p.DiscountValue
+	}
+	return 0
+}
+
+// PromoService управляет промокодами и их использованием
+type PromoService struct {
+	db *sql.DB
+}
+
+// NewPromoService создает новый сервис промокодов
+func NewPromoService(db *sql.DB) *PromoService {
+	return &PromoService{db: db}
+}
+
+// promoCodeColumns список колонок для scanPromoCode
+const promoCodeColumns = `
+	pc.id, pc.code, pc.discount_type, pc.discount_value, pc.max_uses, pc.used_count,
+	pc.allowed_plan_ids, pc.expires_at, pc.is_active, COALESCE(pc.created_by_tg_id, 0),
+	pc.created_at, pc.updated_at
+`
+
+func scanPromoCode(row rowScanner) (*PromoCode, error) {
+	promo := &PromoCode{}
+	var expiresAt sql.NullTime
+	err := row.Scan(
+		&promo.ID, &promo.Code, &promo.DiscountType, &promo.DiscountValue, &promo.MaxUses, &promo.UsedCount,
+		(*pq.Int64Array)(&promo.AllowedPlanIDs), &expiresAt, &promo.IsActive, &promo.CreatedByTgID,
+		&promo.CreatedAt, &promo.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if expiresAt.Valid {
+		promo.ExpiresAt = &expiresAt.Time
+	}
+	return promo, nil
+}
+
+// CreatePromoCode добавляет новый промокод
+func (s *PromoService) CreatePromoCode(promo *PromoCode) error {
+	promo.Code = NormalizePromoCode(promo.Code)
+	if err := promo.Validate(); err != nil {
+		return err
+	}
+	if promo.AllowedPlanIDs == nil {
+		promo.AllowedPlanIDs = []int64{}
+	}
+
+	err := s.db.QueryRow(`
+		INSERT INTO promo_codes (code, discount_type, discount_value, max_uses, allowed_plan_ids, expires_at, is_active, created_by_tg_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, 0))
+		RETURNING id, created_at, updated_at
+	`, promo.Code, promo.DiscountType, promo.DiscountValue, promo.MaxUses,
+		pq.Int64Array(promo.AllowedPlanIDs), promo.ExpiresAt, promo.IsActive, promo.CreatedByTgID,
+	).Scan(&promo.ID, &promo.CreatedAt, &promo.UpdatedAt)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return fmt.Errorf("промокод %s уже существует", promo.Code)
+		}
+		return fmt.Errorf("ошибка добавления промокода: %w", err)
+	}
+	return nil
+}
+
+// SetPromoCodeActive включает или отключает промокод
+func (s *PromoService) SetPromoCodeActive(id int, active bool) error {
+	result, err := s.db.Exec(`
+		UPDATE promo_codes SET is_active = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $1
+	`, id, active)
+	if err != nil {
+		return fmt.Errorf("ошибка обновления статуса промокода: %w", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return fmt.Errorf("промокод с ID %d не найден", id)
+	}
+	return nil
+}
+
+// GetPromoCodeByCode возвращает промокод по коду или nil, если он не найден
+func (s *PromoService) GetPromoCodeByCode(code string) (*PromoCode, error) {
+	return s.getPromoCode(`WHERE pc.code = $1`, NormalizePromoCode(code))
+}
+
+// GetPromoCodeByID возвращает промокод по ID или nil, если он не найден
+func (s *PromoService) GetPromoCodeByID(id int) (*PromoCode, error) {
+	return s.getPromoCode(`WHERE pc.id = $1`, id)
+}
+
+func (s *PromoService) getPromoCode(where string, arg interface{}) (*PromoCode, error) {
+	promo, err := scanPromoCode(s.db.QueryRow(`SELECT `+promoCodeColumns+` FROM promo_codes pc `+where, arg))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения промокода: %w", err)
+	}
+	return promo, nil
+}
+
+// GetAllPromoCodes возвращает все промокоды, новые первыми
+func (s *PromoService) GetAllPromoCodes() ([]*PromoCode, error) {
+	rows, err := s.db.Query(`SELECT ` + promoCodeColumns + ` FROM promo_codes pc ORDER BY pc.is_active DESC, pc.id DESC`)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения промокодов: %w", err)
+	}
+	defer rows.Close()
+
+	var promos []*PromoCode
+	for rows.Next() {
+		promo, err := scanPromoCode(rows)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка сканирования промокода: %w", err)
+		}
+		promos = append(promos, promo)
+	}
+	return promos, nil
+}
+
+// CheckRedeemable проверяет, может ли пользователь использовать промокод.
+// Текст ошибки предназначен для пользователя.
+func (s *PromoService) CheckRedeemable(promo *PromoCode, telegramUserID int64) error {
+	if !promo.IsActive {
+		return fmt.Errorf("промокод %s больше не действует", promo.Code)
+	}
+	if promo.ExpiresAt != nil && !promo.ExpiresAt.After(time.Now()) {
+		return fmt.Errorf("срок действия промокода %s истек", promo.Code)
+	}
+	if promo.MaxUses > 0 && promo.UsedCount >= promo.MaxUses {
+		return fmt.Errorf("промокод %s уже использован максимальное число раз", promo.Code)
+	}
+
+	var used bool
+	err := s.db.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM promo_redemptions WHERE promo_code_id = $1 AND telegram_user_id = $2)
+	`, promo.ID, telegramUserID).Scan(&used)
+	if err != nil {
+		return fmt.Errorf("не удалось проверить промокод, попробуйте позже")
+	}
+	if used {
+		return fmt.Errorf("вы уже использовали промокод %s", promo.Code)
+	}
+	return nil
+}
+
+// Activate запоминает промокод, введенный пользователем, для следующей покупки
+func (s *PromoService) Activate(telegramUserID int64, promoCodeID int) error {
+	_, err := s.db.Exec(`
+		INSERT INTO promo_code_activations (telegram_user_id, promo_code_id, activated_at)
+		VALUES ($1, $2, CURRENT_TIMESTAMP)
+		ON CONFLICT (telegram_user_id) DO UPDATE SET
+			promo_code_id = EXCLUDED.promo_code_id,
+			activated_at = EXCLUDED.activated_at
+	`, telegramUserID, promoCodeID)
+	if err != nil {
+		return fmt.Errorf("ошибка активации промокода: %w", err)
+	}
+	return nil
+}
+
+// GetActivatedPromoCode возвращает промокод, введенный пользователем, или nil
+func (s *PromoService) GetActivatedPromoCode(telegramUserID int64) (*PromoCode, error) {
+	promo, err := scanPromoCode(s.db.QueryRow(`
+		SELECT `+promoCodeColumns+`
+		FROM promo_code_activations a
+		JOIN promo_codes pc ON pc.id = a.promo_code_id
+		WHERE a.telegram_user_id = $1
+	`, telegramUserID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения промокода пользователя: %w", err)
+	}
+	return promo, nil
+}
+
+// ClearActivation сбрасывает введенный пользователем промокод
+func (s *PromoService) ClearActivation(telegramUserID int64) error {
+	if _, err := s.db.Exec(`DELETE FROM promo_code_activations WHERE telegram_user_id = $1`, telegramUserID); err != nil {
+		return fmt.Errorf("ошибка сброса промокода: %w", err)
+	}
+	return nil
+}
+
+// Redeem записывает использование промокода по платежу и увеличивает счетчик использований.
+// Повторный вызов для того же пользователя ничего не меняет и возвращает false.
+// Лимит использований проверяется до оплаты, поэтому уже оплаченный счет засчитывается всегда.
+func (s *PromoService) Redeem(promoCodeID int, telegramUserID int64, transactionID int, discountStars int, bonusDays int) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("ошибка начала транзакции: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		INSERT INTO promo_redemptions (promo_code_id, telegram_user_id, transaction_id, discount_stars, bonus_days)
+		VALUES ($1, $2, NULLIF($3, 0), $4, $5)
+		ON CONFLICT (promo_code_id, telegram_user_id) DO NOTHING
+	`, promoCodeID, telegramUserID, transactionID, discountStars, bonusDays)
+	if err != nil {
+		return false, fmt.Errorf("ошибка записи использования промокода: %w", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		// Повторная обработка того же платежа считается успешной
+		var samePayment bool
+		err := tx.QueryRow(`
+			SELECT COALESCE(transaction_id = $3, false) FROM promo_redemptions
+			WHERE promo_code_id = $1 AND telegram_user_id = $2
+		`, promoCodeID, telegramUserID, transactionID).Scan(&samePayment)
+		if err != nil {
+			return false, fmt.Errorf("ошибка проверки использования промокода: %w", err)
+		}
+		return samePayment, nil
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE promo_codes SET used_count = used_count + 1, updated_at = CURRENT_TIMESTAMP WHERE id = $1
+	`, promoCodeID); err != nil {
+		return false, fmt.Errorf("ошибка обновления счетчика промокода: %w", err)
+	}
+	if _, err := tx.Exec(`
+		DELETE FROM promo_code_activations WHERE telegram_user_id = $1 AND promo_code_id = $2
+	`, telegramUserID, promoCodeID); err != nil {
+		return false, fmt.Errorf("ошибка сброса промокода: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("ошибка сохранения использования промокода: %w", err)
+	}
+	return true, nil
+}
+
+// ReleaseRedemption отменяет использование промокода по платежу, который был
+// автоматически возвращен, чтобы пользователь мог применить код снова
+func (s *PromoService) ReleaseRedemption(transactionID int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("ошибка начала транзакции: %w", err)
+	}
+	defer tx.Rollback()
+
+	var promoCodeID int
+	err = tx.QueryRow(`
+		DELETE FROM promo_redemptions WHERE transaction_id = $1 RETURNING promo_code_id
+	`, transactionID).Scan(&promoCodeID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("ошибка отмены использования промокода: %w", err)
+	}
+	if _, err := tx.Exec(`
+		UPDATE promo_codes SET used_count = GREATEST(used_count - 1, 0), updated_at = CURRENT_TIMESTAMP WHERE id = $1
+	`, promoCodeID); err != nil {
+		return fmt.Errorf("ошибка обновления счетчика промокода: %w", err)
+	}
+	return tx.Commit()
+}
+
+// FormatPromoDiscount форматирует скидку промокода для пользователя
+func FormatPromoDiscount(promo *PromoCode) string {
+	switch promo.DiscountType {
+	case PromoDiscountPercent:
+		return fmt.Sprintf("скидка %d%%", promo.DiscountValue)
+	case PromoDiscountFixed:
+		return fmt.Sprintf("скидка %d ⭐", promo.DiscountValue)
+	default:
+		return fmt.Sprintf("+%d дн. к сроку", promo.DiscountValue)
+	}
+}
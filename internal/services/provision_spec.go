@@ -0,0 +1,126 @@
+package services
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"TelegramXUI/internal/config"
+
+	"github.com/google/uuid"
+)
+
+// Допустимые значения flow клиента VLESS
+const (
+	FlowNone   = ""
+	FlowVision = "xtls-rprx-vision"
+)
+
+// bytesInGB множитель для лимитов трафика, заданных в гигабайтах
+const bytesInGB = int64(1024 * 1024 * 1024)
+
+// ProvisionSpec параметры клиента, определяемые покупкой
+type ProvisionSpec struct {
+	// TrafficLimitBytes - лимит трафика, 0 — без ограничения
+	TrafficLimitBytes int64
+	// Duration - срок действия, 0 — бессрочно
+	Duration time.Duration
+	// LimitIP - максимум одновременных IP (устройств), 0 — без ограничения
+	LimitIP int
+	// Flow - flow клиента VLESS, пустой — без flow
+	Flow string
+	// PlanID - тариф, по которому создается подключение, 0 — без тарифа
+	PlanID int
+	// PaymentChargeID - платеж Telegram, которым оплачено подключение
+	PaymentChargeID string
+}
+
+// DefaultProvisionSpec возвращает параметры клиента по умолчанию из конфигурации
+func DefaultProvisionSpec(vpnConfig *config.VPNConfig) ProvisionSpec {
+	return ProvisionSpec{
+		TrafficLimitBytes: int64(vpnConfig.DefaultTrafficGB) * bytesInGB,
+		Duration:          time.Duration(vpnConfig.DefaultDurationDays) * 24 * time.Hour,
+		LimitIP:           vpnConfig.DefaultLimitIP,
+		Flow:              vpnConfig.DefaultFlow,
+	}
+}
+
+// Validate проверяет параметры клиента
+func (s ProvisionSpec) Validate() error {
+	if s.TrafficLimitBytes < 0 {
+		return fmt.Errorf("лимит трафика не может быть отрицательным")
+	}
+	if s.Duration < 0 {
+		return fmt.Errorf("срок действия не может быть отрицательным")
+	}
+	if s.LimitIP < 0 {
+		return fmt.Errorf("лимит устройств не может быть отрицательным")
+	}
+	if s.Flow != FlowNone && s.Flow != FlowVision {
+		return fmt.Errorf("неподдерживаемый flow: %s", s.Flow)
+	}
+	return nil
+}
+
+// ExpiresAt возвращает момент окончания действия относительно from, nil — бессрочно
+func (s ProvisionSpec) ExpiresAt(from time.Time) *time.Time {
+	if s.Duration <= 0 {
+		return nil
+	}
+	expiresAt := from.Add(s.Duration)
+	return &expiresAt
+}
+
+// RenewalPeriod возвращает начало и окончание продленного периода: новый период
+// начинается с окончания текущего, если оно еще не наступило, иначе — с from
+func (s ProvisionSpec) RenewalPeriod(current *time.Time, from time.Time) (time.Time, *time.Time) {
+	startsAt := from
+	if current != nil && current.After(from) {
+		startsAt = *current
+	}
+	return startsAt, s.ExpiresAt(startsAt)
+}
+
+var emailUnsafeChars = regexp.MustCompile(`[^a-z0-9_]+`)
+
+// readableEmail формирует уникальный email клиента панели вида username_123456789_ab12,
+// чтобы клиента можно было узнать в панели x-ui
+func readableEmail(userName string, telegramUserID int64) string {
+	name := emailUnsafeChars.ReplaceAllString(strings.ToLower(userName), "")
+	if len(name) > 20 {
+		name = name[:20]
+	}
+	if name == "" {
+		name = "user"
+	}
+	suffix := strings.ReplaceAll(uuid.New().String(), "-", "")[:4]
+	return fmt.Sprintf("%s_%d_%s", name, telegramUserID, suffix)
+}
+
+// FormatTrafficLimit форматирует лимит трафика для пользователя
+func FormatTrafficLimit(limitBytes int64) string {
+	if limitBytes <= 0 {
+		return "без ограничения"
+	}
+	if limitBytes%bytesInGB == 0 {
+		return fmt.Sprintf("%d ГБ", limitBytes/bytesInGB)
+	}
+	return fmt.Sprintf("%.2f ГБ", float64(limitBytes)/float64(bytesInGB))
+}
+
+// FormatExpiry форматирует дату окончания действия для пользователя
+func FormatExpiry(expiresAt *time.Time) string {
+	if expiresAt == nil {
+		return "бессрочно"
+	}
+	return expiresAt.Format("02.01.2006 15:04")
+}
+
+// FormatLimitIP форматирует лимит устройств для пользователя
+func FormatLimitIP(limitIP int) string {
+	if limitIP <= 0 {
+		return "без ограничения"
+	}
+	return fmt.Sprintf("%d", limitIP)
+}
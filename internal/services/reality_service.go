@@ -0,0 +1,163 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+
+	"TelegramXUI/internal/config"
+	"TelegramXUI/internal/xui_client"
+
+	"github.com/lib/pq"
+)
+
+// ServerReality параметры REALITY конкретного x-ui сервера
+type ServerReality struct {
+	ServerID    int
+	PrivateKey  string
+	PublicKey   string
+	ShortIDs    []string
+	Dest        string
+	ServerNames []string
+	Fingerprint string
+}
+
+// Options возвращает параметры для генерации streamSettings inbound
+func (r *ServerReality) Options() xui_client.RealityOptions {
+	return xui_client.RealityOptions{
+		PrivateKey:  r.PrivateKey,
+		PublicKey:   r.PublicKey,
+		ShortIDs:    r.ShortIDs,
+		Dest:        r.Dest,
+		ServerNames: r.ServerNames,
+		Fingerprint: r.Fingerprint,
+	}
+}
+
+// RealityService хранит и генерирует ключи REALITY для серверов
+type RealityService struct {
+	db        *sql.DB
+	generator xui_client.RealityKeyGenerator
+	config    config.RealityConfig
+}
+
+// NewRealityService создает новый сервис ключей REALITY.
+// generator можно подменить для детерминированной генерации ключей.
+func NewRealityService(db *sql.DB, generator xui_client.RealityKeyGenerator, cfg config.RealityConfig) *RealityService {
+	if generator == nil {
+		generator = xui_client.NewRealityKeyGenerator(nil)
+	}
+	return &RealityService{
+		db:        db,
+		generator: generator,
+		config:    cfg,
+	}
+}
+
+// GetServerReality возвращает параметры REALITY сервера или nil, если они еще не созданы
+func (s *RealityService) GetServerReality(serverID int) (*ServerReality, error) {
+	reality := &ServerReality{}
+	err := s.db.QueryRow(`
+		SELECT server_id, private_key, public_key, short_ids, dest, server_names, fingerprint
+		FROM xui_server_reality WHERE server_id = $1
+	`, serverID).Scan(
+		&reality.ServerID, &reality.PrivateKey, &reality.PublicKey, pq.Array(&reality.ShortIDs),
+		&reality.Dest, pq.Array(&reality.ServerNames), &reality.Fingerprint,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения ключей REALITY: %w", err)
+	}
+	return reality, nil
+}
+
+// EnsureServerReality возвращает параметры REALITY сервера, при первом обращении генерирует и сохраняет их
+func (s *RealityService) EnsureServerReality(serverID int) (*ServerReality, error) {
+	reality, err := s.GetServerReality(serverID)
+	if err != nil {
+		return nil, err
+	}
+	if reality != nil {
+		return reality, nil
+	}
+
+	reality, err = s.generate(serverID)
+	if err != nil {
+		return nil, err
+	}
+
+	// При одновременной генерации сохраняется первый вариант, остальные перечитывают его
+	result, err := s.db.Exec(`
+		INSERT INTO xui_server_reality (server_id, private_key, public_key, short_ids, dest, server_names, fingerprint)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (server_id) DO NOTHING
+	`, reality.ServerID, reality.PrivateKey, reality.PublicKey, pq.Array(reality.ShortIDs),
+		reality.Dest, pq.Array(reality.ServerNames), reality.Fingerprint)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка сохранения ключей REALITY: %w", err)
+	}
+
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return s.GetServerReality(serverID)
+	}
+
+	log.Printf("[Reality] Сгенерированы ключи REALITY для сервера %d", serverID)
+	return reality, nil
+}
+
+// RotateServerReality генерирует новые ключи сервера. Уже созданные inbound сохраняют старые ключи.
+func (s *RealityService) RotateServerReality(serverID int) (*ServerReality, error) {
+	reality, err := s.generate(serverID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.db.Exec(`
+		INSERT INTO xui_server_reality (server_id, private_key, public_key, short_ids, dest, server_names, fingerprint)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (server_id) DO UPDATE SET
+			private_key = EXCLUDED.private_key,
+			public_key = EXCLUDED.public_key,
+			short_ids = EXCLUDED.short_ids,
+			dest = EXCLUDED.dest,
+			server_names = EXCLUDED.server_names,
+			fingerprint = EXCLUDED.fingerprint,
+			updated_at = CURRENT_TIMESTAMP
+	`, reality.ServerID, reality.PrivateKey, reality.PublicKey, pq.Array(reality.ShortIDs),
+		reality.Dest, pq.Array(reality.ServerNames), reality.Fingerprint)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка сохранения ключей REALITY: %w", err)
+	}
+
+	log.Printf("[Reality] Ключи REALITY сервера %d обновлены", serverID)
+	return reality, nil
+}
+
+// generate создает новые ключи и shortIds с параметрами маскировки из конфигурации
+func (s *RealityService) generate(serverID int) (*ServerReality, error) {
+	privateKey, publicKey, err := s.generator.GenerateKeyPair()
+	if err != nil {
+		return nil, fmt.Errorf("ошибка генерации ключей REALITY: %w", err)
+	}
+
+	count := s.config.ShortIDCount
+	if count <= 0 {
+		count = 1
+	}
+	shortIDs, err := s.generator.GenerateShortIDs(count)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка генерации shortIds REALITY: %w", err)
+	}
+
+	return &ServerReality{
+		ServerID:    serverID,
+		PrivateKey:  privateKey,
+		PublicKey:   publicKey,
+		ShortIDs:    shortIDs,
+		Dest:        s.config.Dest,
+		ServerNames: s.config.ServerNames,
+		Fingerprint: s.config.Fingerprint,
+	}, nil
+}
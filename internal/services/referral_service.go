@@ -0,0 +1,263 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReferralStartPrefix префикс параметра /start в реферальной ссылке: ref_<telegram_id>
+const ReferralStartPrefix = "ref_"
+
+// ReferralStartParam возвращает параметр /start реферальной ссылки пользователя
+func ReferralStartParam(telegramUserID int64) string {
+	return ReferralStartPrefix + strconv.FormatInt(telegramUserID, 10)
+}
+
+// ParseReferralStartParam возвращает Telegram ID пригласившего из параметра /start
+func ParseReferralStartParam(param string) (int64, bool) {
+	if !strings.HasPrefix(param, ReferralStartPrefix) {
+		return 0, false
+	}
+	id, err := strconv.ParseInt(strings.TrimPrefix(param, ReferralStartPrefix), 10, 64)
+	if err != nil || id <= 0 {
+		return 0, false
+	}
+	return id, true
+}
+
+// Invitee пользователь, пришедший по реферальной ссылке
+type Invitee struct {
+	TelegramID int64     `json:"telegram_id"`
+	Username   string    `json:"username"`
+	FirstName  string    `json:"first_name"`
+	ReferredAt time.Time `json:"referred_at"`
+	// Rewarded - приглашенный оплатил покупку и награда начислена
+	Rewarded    bool `json:"rewarded"`
+	RewardDays  int  `json:"reward_days"`
+	RewardStars int  `json:"reward_stars"`
+}
+
+// ReferralSummary приглашенные пользователя и заработанные награды
+type ReferralSummary struct {
+	Invitees    []*Invitee `json:"invitees"`
+	EarnedDays  int        `json:"earned_days"`
+	EarnedStars int        `json:"earned_stars"`
+	PendingDays int        `json:"pending_days"` // еще не добавлены к покупке
+}
+
+// ReferralService управляет приглашениями и наградами за них
+type ReferralService struct {
+	db *sql.DB
+}
+
+// NewReferralService создает новый сервис реферальной программы
+func NewReferralService(db *sql.DB) *ReferralService {
+	return &ReferralService{db: db}
+}
+
+// SetReferrer записывает пригласившего. Приглашение учитывается один раз и только
+// для пользователя без оплаченных покупок (пополнение баланса покупкой не считается);
+// пригласить себя или своего пригласившего нельзя.
+func (s *ReferralService) SetReferrer(inviteeTgID, referrerTgID int64) (bool, error) {
+	if inviteeTgID == referrerTgID {
+		return false, nil
+	}
+	result, err := s.db.Exec(`
+		UPDATE telegram_users u SET referred_by_tg_id = $2, referred_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE u.telegram_id = $1 AND u.referred_by_tg_id IS NULL
+		  AND EXISTS (
+			SELECT 1 FROM telegram_users r
+			WHERE r.telegram_id = $2 AND r.referred_by_tg_id IS DISTINCT FROM $1
+		  )
+		  AND NOT EXISTS (
+			SELECT 1 FROM transactions t
+			WHERE t.telegram_user_id = $1 AND t.type = $3 AND t.status = $4
+			  AND NOT EXISTS (SELECT 1 FROM ledger_entries e WHERE e.transaction_id = t.id AND e.kind = $5)
+		  )
+	`, inviteeTgID, referrerTgID, TransactionTypePayment, TransactionStatusFulfilled, LedgerKindTopUp)
+	if err != nil {
+		return false, fmt.Errorf("ошибка записи приглашения: %w", err)
+	}
+	rows, _ := result.RowsAffected()
+	return rows > 0, nil
+}
+
+// GrantReward начисляет пригласившему награду за первую оплату приглашенного: дни к следующей
+// покупке и звезды на баланс, в одной транзакции БД. Возвращает Telegram ID пригласившего
+// или 0, если награда не положена или уже начислена.
+func (s *ReferralService) GrantReward(inviteeTgID int64, transactionID int, bonusDays int, rewardStars int) (int64, error) {
+	if bonusDays <= 0 && rewardStars <= 0 {
+		return 0, nil
+	}
+	bonusDays, rewardStars = max(bonusDays, 0), max(rewardStars, 0)
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("ошибка начала транзакции: %w", err)
+	}
+	defer tx.Rollback()
+
+	var referrerTgID int64
+	err = tx.QueryRow(`
+		INSERT INTO referral_rewards (referrer_tg_id, invitee_tg_id, transaction_id, bonus_days, reward_stars)
+		SELECT referred_by_tg_id, telegram_id, $2, $3, $4 FROM telegram_users
+		WHERE telegram_id = $1 AND referred_by_tg_id IS NOT NULL
+		ON CONFLICT (invitee_tg_id) DO NOTHING
+		RETURNING referrer_tg_id
+	`, inviteeTgID, transactionID, bonusDays, rewardStars).Scan(&referrerTgID)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("ошибка начисления реферальной награды: %w", err)
+	}
+
+	if rewardStars > 0 {
+		if _, err := postLedgerEntry(tx, ledgerOperation{
+			kind:           LedgerKindBonus,
+			telegramUserID: referrerTgID,
+			amount:         rewardStars,
+			from:           LedgerAccountBonuses,
+			to:             userAccountCode(referrerTgID),
+			transactionID:  transactionID,
+			description:    fmt.Sprintf("Награда за приглашение пользователя %d", inviteeTgID),
+			idempotencyKey: fmt.Sprintf("bonus:referral:%d:%d", inviteeTgID, transactionID),
+		}); err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("ошибка сохранения реферальной награды: %w", err)
+	}
+	return referrerTgID, nil
+}
+
+// RevokeReward отзывает награду за приглашение, начисленную за возвращенный платеж приглашенного:
+// удаляет запись награды и списывает звезды с баланса пригласившего в одной транзакции БД.
+// Награда отзывается, только если дни еще не использованы и звезды не потрачены, иначе
+// остается без изменений и возвращается false. Telegram ID 0 — за платеж награда не начислялась.
+func (s *ReferralService) RevokeReward(transactionID int, adminTgID int64) (int64, bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, false, fmt.Errorf("ошибка начала транзакции: %w", err)
+	}
+	defer tx.Rollback()
+
+	var rewardID, rewardStars int
+	var referrerTgID, inviteeTgID int64
+	var applied bool
+	err = tx.QueryRow(`
+		SELECT id, referrer_tg_id, invitee_tg_id, reward_stars, applied_transaction_id IS NOT NULL AND bonus_days > 0
+		FROM referral_rewards WHERE transaction_id = $1
+		FOR UPDATE
+	`, transactionID).Scan(&rewardID, &referrerTgID, &inviteeTgID, &rewardStars, &applied)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("ошибка получения реферальной награды: %w", err)
+	}
+	if applied {
+		return referrerTgID, false, nil
+	}
+
+	if rewardStars > 0 {
+		_, err := postLedgerEntry(tx, ledgerOperation{
+			kind:           LedgerKindAdjustment,
+			telegramUserID: referrerTgID,
+			amount:         rewardStars,
+			from:           userAccountCode(referrerTgID),
+			to:             LedgerAccountBonuses,
+			transactionID:  transactionID,
+			description:    fmt.Sprintf("Отмена награды за приглашение пользователя %d: платеж возвращен", inviteeTgID),
+			createdByTgID:  adminTgID,
+			idempotencyKey: fmt.Sprintf("revoke:referral:%d", rewardID),
+		})
+		if errors.Is(err, ErrInsufficientBalance) {
+			return referrerTgID, false, nil
+		}
+		if err != nil {
+			return 0, false, err
+		}
+	}
+	if _, err := tx.Exec(`DELETE FROM referral_rewards WHERE id = $1`, rewardID); err != nil {
+		return 0, false, fmt.Errorf("ошибка удаления реферальной награды: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, false, fmt.Errorf("ошибка сохранения отмены реферальной награды: %w", err)
+	}
+	return referrerTgID, true, nil
+}
+
+// ClaimBonusDays закрепляет неиспользованные дни пригласившего за его платежом
+// и возвращает их сумму. Повторный вызов для того же платежа возвращает те же дни.
+func (s *ReferralService) ClaimBonusDays(referrerTgID int64, transactionID int) (int, error) {
+	var days int
+	err := s.db.QueryRow(`
+		WITH claimed AS (
+			UPDATE referral_rewards SET applied_transaction_id = $2, applied_at = CURRENT_TIMESTAMP
+			WHERE referrer_tg_id = $1 AND applied_transaction_id IS NULL
+			RETURNING bonus_days
+		)
+		SELECT COALESCE((SELECT SUM(bonus_days) FROM claimed), 0)
+		     + COALESCE((SELECT SUM(bonus_days) FROM referral_rewards WHERE applied_transaction_id = $2), 0)
+	`, referrerTgID, transactionID).Scan(&days)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка списания реферальных дней: %w", err)
+	}
+	return days, nil
+}
+
+// ReleaseBonusDays возвращает дни, закрепленные за возвращенным платежом,
+// чтобы они добавились к следующей покупке
+func (s *ReferralService) ReleaseBonusDays(transactionID int) error {
+	_, err := s.db.Exec(`
+		UPDATE referral_rewards SET applied_transaction_id = NULL, applied_at = NULL
+		WHERE applied_transaction_id = $1
+	`, transactionID)
+	if err != nil {
+		return fmt.Errorf("ошибка возврата реферальных дней: %w", err)
+	}
+	return nil
+}
+
+// GetReferralSummary возвращает приглашенных пользователем и его награды
+func (s *ReferralService) GetReferralSummary(referrerTgID int64) (*ReferralSummary, error) {
+	rows, err := s.db.Query(`
+		SELECT u.telegram_id, COALESCE(u.username, ''), COALESCE(u.first_name, ''), u.referred_at,
+		       r.id IS NOT NULL, COALESCE(r.bonus_days, 0), COALESCE(r.reward_stars, 0),
+		       r.applied_transaction_id IS NULL AND r.id IS NOT NULL
+		FROM telegram_users u
+		LEFT JOIN referral_rewards r ON r.invitee_tg_id = u.telegram_id AND r.referrer_tg_id = u.referred_by_tg_id
+		WHERE u.referred_by_tg_id = $1
+		ORDER BY u.referred_at DESC
+	`, referrerTgID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения приглашенных: %w", err)
+	}
+	defer rows.Close()
+
+	summary := &ReferralSummary{}
+	for rows.Next() {
+		invitee := &Invitee{}
+		var pending bool
+		if err := rows.Scan(&invitee.TelegramID, &invitee.Username, &invitee.FirstName, &invitee.ReferredAt,
+			&invitee.Rewarded, &invitee.RewardDays, &invitee.RewardStars, &pending); err != nil {
+			return nil, fmt.Errorf("ошибка сканирования приглашенного: %w", err)
+		}
+		summary.EarnedDays += invitee.RewardDays
+		summary.EarnedStars += invitee.RewardStars
+		if pending {
+			summary.PendingDays += invitee.RewardDays
+		}
+		summary.Invitees = append(summary.Invitees, invitee)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка чтения приглашенных: %w", err)
+	}
+	return summary, nil
+}
@@ -0,0 +1,223 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Шаг группировки финансового отчета
+const (
+	ReportGranularityDay   = "day"
+	ReportGranularityWeek  = "week"
+	ReportGranularityMonth = "month"
+)
+
+// RevenueStats суммы по оплатам и возвратам в звездах
+type RevenueStats struct {
+	Gross     int `json:"gross"`     // получено по платежам
+	Refunds   int `json:"refunds"`   // возвращено
+	Net       int `json:"net"`       // gross - refunds
+	Purchases int `json:"purchases"` // количество платежей
+	Refunded  int `json:"refunded"`  // количество возвратов
+}
+
+func (s *RevenueStats) add(other RevenueStats) {
+	s.Gross += other.Gross
+	s.Refunds += other.Refunds
+	s.Net += other.Net
+	s.Purchases += other.Purchases
+	s.Refunded += other.Refunded
+}
+
+// RevenueRow строка отчета: период и тариф
+type RevenueRow struct {
+	Period   time.Time `json:"period"`
+	PlanID   int       `json:"plan_id"` // 0 — без тарифа
+	PlanName string    `json:"plan_name"`
+	RevenueStats
+}
+
+// PeriodRevenue итог за период
+type PeriodRevenue struct {
+	Period time.Time `json:"period"`
+	RevenueStats
+}
+
+// PlanRevenue итог по тарифу за весь отчет
+type PlanRevenue struct {
+	PlanID   int    `json:"plan_id"`
+	PlanName string `json:"plan_name"`
+	RevenueStats
+}
+
+// RevenueReport финансовый отчет по транзакциям за [From, To)
+type RevenueReport struct {
+	Granularity string          `json:"granularity"`
+	From        time.Time       `json:"from"`
+	To          time.Time       `json:"to"`
+	Rows        []RevenueRow    `json:"rows"`
+	Periods     []PeriodRevenue `json:"periods"`
+	Plans       []PlanRevenue   `json:"plans"`
+	Total       RevenueStats    `json:"total"`
+}
+
+// ReportService строит финансовые отчеты по таблице transactions
+type ReportService struct {
+	db *sql.DB
+}
+
+// NewReportService создает новый сервис отчетов
+func NewReportService(db *sql.DB) *ReportService {
+	return &ReportService{db: db}
+}
+
+// IsValidReportGranularity проверяет шаг группировки отчета
+func IsValidReportGranularity(granularity string) bool {
+	switch granularity {
+	case ReportGranularityDay, ReportGranularityWeek, ReportGranularityMonth:
+		return true
+	}
+	return false
+}
+
+// DefaultReportPeriods количество периодов в отчете, если оно не указано
+func DefaultReportPeriods(granularity string) int {
+	switch granularity {
+	case ReportGranularityWeek:
+		return 8
+	case ReportGranularityMonth:
+		return 12
+	default:
+		return 7
+	}
+}
+
+// ReportRange возвращает границы отчета из count последних периодов, включая текущий
+func ReportRange(granularity string, count int, now time.Time) (time.Time, time.Time) {
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	switch granularity {
+	case ReportGranularityWeek:
+		// Неделя начинается с понедельника, как в date_trunc('week')
+		start = start.AddDate(0, 0, -(int(start.Weekday())+6)%7)
+		start = start.AddDate(0, 0, -7*(count-1))
+	case ReportGranularityMonth:
+		start = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+		start = start.AddDate(0, -(count - 1), 0)
+	default:
+		start = start.AddDate(0, 0, -(count - 1))
+	}
+	return start, now
+}
+
+// GetRevenueReport считает выручку, возвраты и покупки по периодам и тарифам за [from, to).
+// Платеж учитывается в периоде оплаты, возврат — в периоде возврата. Учитываются только
+// оплаты в Telegram: пополнение баланса уже входит в выручку, покупки с баланса — нет.
+func (s *ReportService) GetRevenueReport(granularity string, from, to time.Time) (*RevenueReport, error) {
+	if !IsValidReportGranularity(granularity) {
+		return nil, fmt.Errorf("неизвестный период отчета: %s", granularity)
+	}
+
+	// Тариф возврата берется из исходного платежа, если у строки возврата он не записан
+	rows, err := s.db.Query(`
+		SELECT
+			date_trunc($1, t.created_at) AS period,
+			COALESCE(t.plan_id, p.plan_id, 0) AS plan_id,
+			COALESCE(pl.name, '') AS plan_name,
+			COALESCE(SUM(t.amount) FILTER (WHERE t.type = 'payment'), 0) AS gross,
+			COALESCE(SUM(t.amount) FILTER (WHERE t.type = 'refund'), 0) AS refunds,
+			COUNT(*) FILTER (WHERE t.type = 'payment') AS purchases,
+			COUNT(*) FILTER (WHERE t.type = 'refund') AS refunded
+		FROM transactions t
+		LEFT JOIN transactions p ON p.id = t.parent_transaction_id
+		LEFT JOIN plans pl ON pl.id = COALESCE(t.plan_id, p.plan_id)
+		WHERE t.type IN ('payment', 'refund') AND t.payment_method = 'stars'
+		  AND t.created_at >= $2 AND t.created_at < $3
+		GROUP BY 1, 2, 3
+		ORDER BY 1, 2
+	`, granularity, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка построения отчета: %w", err)
+	}
+	defer rows.Close()
+
+	report := &RevenueReport{Granularity: granularity, From: from, To: to}
+	periods := make(map[time.Time]*PeriodRevenue)
+	plans := make(map[int]*PlanRevenue)
+	for rows.Next() {
+		var row RevenueRow
+		if err := rows.Scan(&row.Period, &row.PlanID, &row.PlanName,
+			&row.Gross, &row.Refunds, &row.Purchases, &row.Refunded); err != nil {
+			return nil, fmt.Errorf("ошибка сканирования отчета: %w", err)
+		}
+		row.Net = row.Gross - row.Refunds
+		if row.PlanName == "" {
+			row.PlanName = "Без тарифа"
+		}
+		report.Rows = append(report.Rows, row)
+
+		if periods[row.Period] == nil {
+			periods[row.Period] = &PeriodRevenue{Period: row.Period}
+		}
+		periods[row.Period].add(row.RevenueStats)
+		if plans[row.PlanID] == nil {
+			plans[row.PlanID] = &PlanRevenue{PlanID: row.PlanID, PlanName: row.PlanName}
+		}
+		plans[row.PlanID].add(row.RevenueStats)
+		report.Total.add(row.RevenueStats)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка чтения отчета: %w", err)
+	}
+
+	for _, period := range periods {
+		report.Periods = append(report.Periods, *period)
+	}
+	sort.Slice(report.Periods, func(i, j int) bool { return report.Periods[i].Period.Before(report.Periods[j].Period) })
+	for _, plan := range plans {
+		report.Plans = append(report.Plans, *plan)
+	}
+	sort.Slice(report.Plans, func(i, j int) bool { return report.Plans[i].Net > report.Plans[j].Net })
+	return report, nil
+}
+
+// WriteCSV выгружает строки отчета (период × тариф) в CSV
+func (r *RevenueReport) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write([]string{"period", "plan_id", "plan_name", "gross", "refunds", "net", "purchases", "refunded"}); err != nil {
+		return err
+	}
+	for _, row := range r.Rows {
+		record := []string{
+			row.Period.Format("2006-01-02"),
+			strconv.Itoa(row.PlanID),
+			row.PlanName,
+			strconv.Itoa(row.Gross),
+			strconv.Itoa(row.Refunds),
+			strconv.Itoa(row.Net),
+			strconv.Itoa(row.Purchases),
+			strconv.Itoa(row.Refunded),
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// FormatReportPeriod форматирует начало периода отчета для пользователя
+func FormatReportPeriod(granularity string, period time.Time) string {
+	switch granularity {
+	case ReportGranularityWeek:
+		return "нед. с " + period.Format("02.01.2006")
+	case ReportGranularityMonth:
+		return period.Format("01.2006")
+	default:
+		return period.Format("02.01.2006")
+	}
+}
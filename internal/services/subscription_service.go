@@ -0,0 +1,146 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// SubscriptionPeriodSeconds период подписки Telegram Stars: Telegram поддерживает только 30 дней
+const SubscriptionPeriodSeconds = 2592000
+
+// SubscriptionPeriodDays период подписки в днях; по подписке продаются тарифы с таким сроком
+const SubscriptionPeriodDays = SubscriptionPeriodSeconds / 86400
+
+// StarSubscription подписка Telegram Stars на продление VPN подключения
+type StarSubscription struct {
+	ID              int    `json:"id"`
+	TelegramUserID  int64  `json:"telegram_user_id"`
+	VPNConnectionID int    `json:"vpn_connection_id"`
+	PlanID          int    `json:"plan_id"` // 0 — тариф удален
+	ChargeID        string `json:"charge_id"`
+	PriceStars      int    `json:"price_stars"`
+	// ExpiresAt — дата следующего списания, после нее без оплаты подписка закончилась
+	ExpiresAt   time.Time  `json:"expires_at"`
+	CancelledAt *time.Time `json:"cancelled_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// IsRenewing проверяет, что Telegram продолжит списывать оплату по подписке
+func (s *StarSubscription) IsRenewing() bool {
+	return s.CancelledAt == nil && s.ExpiresAt.After(time.Now())
+}
+
+const subscriptionColumns = `id, telegram_user_id, vpn_connection_id, COALESCE(plan_id, 0), charge_id, price_stars,
+	expires_at, cancelled_at, created_at`
+
+// scanSubscription сканирует строку с колонками subscriptionColumns
+func scanSubscription(row interface{ Scan(...interface{}) error }) (*StarSubscription, error) {
+	sub := &StarSubscription{}
+	err := row.Scan(&sub.ID, &sub.TelegramUserID, &sub.VPNConnectionID, &sub.PlanID, &sub.ChargeID, &sub.PriceStars,
+		&sub.ExpiresAt, &sub.CancelledAt, &sub.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
+
+// SubscriptionService хранит подписки Telegram Stars
+type SubscriptionService struct {
+	db *sql.DB
+}
+
+// NewSubscriptionService создает новый сервис подписок
+func NewSubscriptionService(db *sql.DB) *SubscriptionService {
+	return &SubscriptionService{db: db}
+}
+
+// RecordPayment сохраняет платеж по подписке подключения: первый платеж (first) начинает
+// подписку с его charge_id, следующие переносят дату списания. Платеж означает, что
+// подписка не отменена.
+func (s *SubscriptionService) RecordPayment(sub *StarSubscription, first bool) (*StarSubscription, error) {
+	saved, err := scanSubscription(s.db.QueryRow(`
+		INSERT INTO star_subscriptions (telegram_user_id, vpn_connection_id, plan_id, charge_id, price_stars, expires_at)
+		VALUES ($1, $2, NULLIF($3, 0), $4, $5, $6)
+		ON CONFLICT (vpn_connection_id) DO UPDATE SET
+			telegram_user_id = EXCLUDED.telegram_user_id,
+			plan_id = EXCLUDED.plan_id,
+			charge_id = CASE WHEN $7 THEN EXCLUDED.charge_id ELSE star_subscriptions.charge_id END,
+			price_stars = EXCLUDED.price_stars,
+			expires_at = EXCLUDED.expires_at,
+			cancelled_at = NULL,
+			updated_at = CURRENT_TIMESTAMP
+		RETURNING `+subscriptionColumns,
+		sub.TelegramUserID, sub.VPNConnectionID, sub.PlanID, sub.ChargeID, sub.PriceStars, sub.ExpiresAt, first))
+	if err != nil {
+		return nil, fmt.Errorf("ошибка сохранения подписки: %w", err)
+	}
+	return saved, nil
+}
+
+// GetSubscriptionByID возвращает подписку по ID, nil — не найдена
+func (s *SubscriptionService) GetSubscriptionByID(id int) (*StarSubscription, error) {
+	sub, err := scanSubscription(s.db.QueryRow(`SELECT `+subscriptionColumns+` FROM star_subscriptions WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения подписки: %w", err)
+	}
+	return sub, nil
+}
+
+// GetRenewingByConnection возвращает неотмененную подписку подключения, nil — подписки нет
+func (s *SubscriptionService) GetRenewingByConnection(connectionID int) (*StarSubscription, error) {
+	sub, err := scanSubscription(s.db.QueryRow(`
+		SELECT `+subscriptionColumns+` FROM star_subscriptions
+		WHERE vpn_connection_id = $1 AND cancelled_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+	`, connectionID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения подписки подключения: %w", err)
+	}
+	return sub, nil
+}
+
+// GetUserSubscriptions возвращает подписки пользователя, срок которых еще не закончился,
+// включая отмененные
+func (s *SubscriptionService) GetUserSubscriptions(telegramUserID int64) ([]*StarSubscription, error) {
+	rows, err := s.db.Query(`
+		SELECT `+subscriptionColumns+` FROM star_subscriptions
+		WHERE telegram_user_id = $1 AND expires_at > CURRENT_TIMESTAMP
+		ORDER BY expires_at
+	`, telegramUserID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения подписок: %w", err)
+	}
+	defer rows.Close()
+
+	var subs []*StarSubscription
+	for rows.Next() {
+		sub, err := scanSubscription(rows)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка сканирования подписки: %w", err)
+		}
+		subs = append(subs, sub)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка чтения подписок: %w", err)
+	}
+	return subs, nil
+}
+
+// SetCancelled отмечает отмену продления подписки или ее возобновление
+func (s *SubscriptionService) SetCancelled(id int, cancelled bool) error {
+	_, err := s.db.Exec(`
+		UPDATE star_subscriptions
+		SET cancelled_at = CASE WHEN $2 THEN CURRENT_TIMESTAMP END, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+	`, id, cancelled)
+	if err != nil {
+		return fmt.Errorf("ошибка изменения подписки: %w", err)
+	}
+	return nil
+}
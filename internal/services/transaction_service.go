@@ -2,9 +2,34 @@ package services
 
 import (
 	"database/sql"
+	"fmt"
+	"strings"
 	"time"
 )
 
+// Типы транзакций
+const (
+	TransactionTypePayment = "payment"
+	TransactionTypeRefund  = "refund"
+)
+
+// Статусы платежа: pending → provisioning → fulfilled / refunded / failed.
+// Возвраты записываются со статусом success.
+const (
+	TransactionStatusPending      = "pending"
+	TransactionStatusProvisioning = "provisioning"
+	TransactionStatusFulfilled    = "fulfilled"
+	TransactionStatusRefunded     = "refunded"
+	TransactionStatusFailed       = "failed"
+	TransactionStatusSuccess      = "success"
+)
+
+// Способы оплаты платежа
+const (
+	PaymentMethodStars   = "stars"   // оплата счета в Telegram
+	PaymentMethodBalance = "balance" // списание с внутреннего баланса
+)
+
 type Transaction struct {
 	ID                      int
 	TelegramPaymentChargeID string
@@ -14,10 +39,57 @@ type Transaction struct {
 	Status                  string
 	Type                    string // 'payment' или 'refund'
 	Reason                  string
+	PlanID                  int // 0 — без тарифа
+	VPNConnectionID         int // 0 — подключение не создано
+	ParentTransactionID     int // для возврата — ID исходного платежа
+	PromoCodeID             int // 0 — без промокода
+	PaymentMethod           string
+	Attempts                int
+	LastError               string
 	CreatedAt               time.Time
 	UpdatedAt               time.Time
 }
 
+// TransactionFilter условия выборки транзакций, пустые поля не учитываются
+type TransactionFilter struct {
+	TelegramUserID int64
+	Type           string
+	Status         string
+	From           *time.Time // включительно
+	To             *time.Time // не включительно
+}
+
+// where формирует условие WHERE и аргументы запроса по фильтру
+func (f TransactionFilter) where() (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	add := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if f.TelegramUserID != 0 {
+		add("telegram_user_id = $%d", f.TelegramUserID)
+	}
+	if f.Type != "" {
+		add("type = $%d", f.Type)
+	}
+	if f.Status != "" {
+		add("status = $%d", f.Status)
+	}
+	if f.From != nil {
+		add("created_at >= $%d", *f.From)
+	}
+	if f.To != nil {
+		add("created_at < $%d", *f.To)
+	}
+
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return "WHERE " + strings.Join(conditions, " AND "), args
+}
+
 type TransactionService struct {
 	db *sql.DB
 }
@@ -26,11 +98,51 @@ func NewTransactionService(db *sql.DB) *TransactionService {
 	return &TransactionService{db: db}
 }
 
+// transactionColumns список колонок для scanTransaction
+const transactionColumns = `
+	id, telegram_payment_charge_id, telegram_user_id, amount, COALESCE(invoice_payload, ''),
+	status, type, COALESCE(reason, ''), COALESCE(plan_id, 0),
+	COALESCE(vpn_connection_id, 0), COALESCE(parent_transaction_id, 0), COALESCE(promo_code_id, 0),
+	payment_method, attempts, COALESCE(last_error, ''),
+	created_at, updated_at
+`
+
+func scanTransaction(row rowScanner) (*Transaction, error) {
+	tx := &Transaction{}
+	err := row.Scan(
+		&tx.ID,
+		&tx.TelegramPaymentChargeID,
+		&tx.TelegramUserID,
+		&tx.Amount,
+		&tx.InvoicePayload,
+		&tx.Status,
+		&tx.Type,
+		&tx.Reason,
+		&tx.PlanID,
+		&tx.VPNConnectionID,
+		&tx.ParentTransactionID,
+		&tx.PromoCodeID,
+		&tx.PaymentMethod,
+		&tx.Attempts,
+		&tx.LastError,
+		&tx.CreatedAt,
+		&tx.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
 func (s *TransactionService) AddTransaction(tx *Transaction) error {
+	if tx.PaymentMethod == "" {
+		tx.PaymentMethod = PaymentMethodStars
+	}
 	_, err := s.db.Exec(`
 		INSERT INTO transactions (
-			telegram_payment_charge_id, telegram_user_id, amount, invoice_payload, status, type, reason, created_at, updated_at
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, NOW(), NOW())
+			telegram_payment_charge_id, telegram_user_id, amount, invoice_payload, status, type, reason, plan_id,
+			vpn_connection_id, parent_transaction_id, payment_method, created_at, updated_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, 0), NULLIF($9, 0), NULLIF($10, 0), $11, NOW(), NOW())
 	`,
 		tx.TelegramPaymentChargeID,
 		tx.TelegramUserID,
@@ -39,17 +151,243 @@ func (s *TransactionService) AddTransaction(tx *Transaction) error {
 		tx.Status,
 		tx.Type,
 		tx.Reason,
+		tx.PlanID,
+		tx.VPNConnectionID,
+		tx.ParentTransactionID,
+		tx.PaymentMethod,
 	)
 	return err
 }
 
-func (s *TransactionService) GetAllTransactions() ([]*Transaction, error) {
-	rows, err := s.db.Query(`
-		SELECT id, telegram_payment_charge_id, telegram_user_id, amount, invoice_payload, status, type, reason, created_at, updated_at
+// AddRefund записывает возврат по платежу payment
+func (s *TransactionService) AddRefund(payment *Transaction, reason string) error {
+	refund := &Transaction{
+		TelegramPaymentChargeID: payment.TelegramPaymentChargeID,
+		TelegramUserID:          payment.TelegramUserID,
+		Amount:                  payment.Amount,
+		InvoicePayload:          payment.InvoicePayload,
+		Status:                  TransactionStatusSuccess,
+		Type:                    TransactionTypeRefund,
+		Reason:                  reason,
+		PlanID:                  payment.PlanID,
+		VPNConnectionID:         payment.VPNConnectionID,
+		ParentTransactionID:     payment.ID,
+		PaymentMethod:           payment.PaymentMethod,
+	}
+	if err := s.AddTransaction(refund); err != nil {
+		return fmt.Errorf("ошибка записи возврата по платежу %d: %w", payment.ID, err)
+	}
+	return nil
+}
+
+// RegisterPayment сохраняет полученный платеж в статусе pending.
+// Если платеж с таким telegram_payment_charge_id уже записан, возвращает false
+// и заполняет tx данными сохраненной записи.
+func (s *TransactionService) RegisterPayment(tx *Transaction) (bool, error) {
+	err := insertPayment(s.db, tx)
+	if err == sql.ErrNoRows {
+		existing, err := s.GetByChargeID(tx.TelegramPaymentChargeID)
+		if err != nil {
+			return false, err
+		}
+		if existing == nil {
+			return false, fmt.Errorf("платеж %s не найден после конфликта", tx.TelegramPaymentChargeID)
+		}
+		*tx = *existing
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("ошибка регистрации платежа: %w", err)
+	}
+	return true, nil
+}
+
+// queryRower выполняет запрос с одной строкой результата: *sql.DB или *sql.Tx
+type queryRower interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// insertPayment записывает платеж в статусе pending. Если платеж с таким
+// telegram_payment_charge_id уже есть, возвращает sql.ErrNoRows.
+func insertPayment(q queryRower, tx *Transaction) error {
+	if tx.PaymentMethod == "" {
+		tx.PaymentMethod = PaymentMethodStars
+	}
+	err := q.QueryRow(`
+		INSERT INTO transactions (
+			telegram_payment_charge_id, telegram_user_id, amount, invoice_payload, status, type, reason, plan_id,
+			vpn_connection_id, promo_code_id, payment_method, created_at, updated_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, 0), NULLIF($9, 0), NULLIF($10, 0), $11, NOW(), NOW())
+		ON CONFLICT (telegram_payment_charge_id) WHERE type = 'payment' DO NOTHING
+		RETURNING id, status, created_at, updated_at
+	`,
+		tx.TelegramPaymentChargeID,
+		tx.TelegramUserID,
+		tx.Amount,
+		tx.InvoicePayload,
+		TransactionStatusPending,
+		TransactionTypePayment,
+		tx.Reason,
+		tx.PlanID,
+		tx.VPNConnectionID,
+		tx.PromoCodeID,
+		tx.PaymentMethod,
+	).Scan(&tx.ID, &tx.Status, &tx.CreatedAt, &tx.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	tx.Type = TransactionTypePayment
+	return nil
+}
+
+// GetTransactionByID возвращает транзакцию по ID или nil, если она не найдена
+func (s *TransactionService) GetTransactionByID(id int) (*Transaction, error) {
+	tx, err := scanTransaction(s.db.QueryRow(`
+		SELECT `+transactionColumns+`
+		FROM transactions
+		WHERE id = $1
+	`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения транзакции: %w", err)
+	}
+	return tx, nil
+}
+
+// GetByChargeID возвращает платеж по telegram_payment_charge_id или nil, если он не найден
+func (s *TransactionService) GetByChargeID(chargeID string) (*Transaction, error) {
+	tx, err := scanTransaction(s.db.QueryRow(`
+		SELECT `+transactionColumns+`
+		FROM transactions
+		WHERE telegram_payment_charge_id = $1 AND type = $2
+	`, chargeID, TransactionTypePayment))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения платежа: %w", err)
+	}
+	return tx, nil
+}
+
+// StartProvisioning переводит платеж в статус provisioning. Возвращает false, если платеж
+// уже обрабатывается или завершен. resume разрешает повторно захватить платеж,
+// обработка которого была прервана (используется при запуске сервиса).
+func (s *TransactionService) StartProvisioning(id int, resume bool) (bool, error) {
+	claimable := `status = 'pending'`
+	if resume {
+		claimable = `status IN ('pending', 'provisioning')`
+	}
+	result, err := s.db.Exec(`
+		UPDATE transactions SET
+			status = 'provisioning',
+			attempts = attempts + 1,
+			processing_started_at = CURRENT_TIMESTAMP,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND type = 'payment' AND `+claimable, id)
+	if err != nil {
+		return false, fmt.Errorf("ошибка начала обработки платежа: %w", err)
+	}
+	rows, _ := result.RowsAffected()
+	return rows > 0, nil
+}
+
+// FinishPayment завершает обработку платежа статусом fulfilled, refunded или failed
+func (s *TransactionService) FinishPayment(id int, status string, lastErr error) error {
+	switch status {
+	case TransactionStatusFulfilled, TransactionStatusRefunded, TransactionStatusFailed:
+	default:
+		return fmt.Errorf("недопустимый итоговый статус платежа: %s", status)
+	}
+
+	errText := ""
+	if lastErr != nil {
+		errText = lastErr.Error()
+	}
+	_, err := s.db.Exec(`
+		UPDATE transactions SET
+			status = $2,
+			last_error = NULLIF($3, ''),
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND type = 'payment'
+	`, id, status, errText)
+	if err != nil {
+		return fmt.Errorf("ошибка сохранения статуса платежа: %w", err)
+	}
+	return nil
+}
+
+// ClaimRefund переводит выполненный платеж в статус refunded перед возвратом звезд.
+// Возвращает false, если платеж уже возвращен или не был выполнен, — повторное
+// подтверждение возврата не отправит деньги второй раз. Если возврат в Telegram
+// не удался, статус восстанавливается через FinishPayment.
+func (s *TransactionService) ClaimRefund(id int) (bool, error) {
+	result, err := s.db.Exec(`
+		UPDATE transactions SET status = 'refunded', updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND type = 'payment' AND status = 'fulfilled'
+	`, id)
+	if err != nil {
+		return false, fmt.Errorf("ошибка начала возврата платежа: %w", err)
+	}
+	rows, _ := result.RowsAffected()
+	return rows > 0, nil
+}
+
+// LinkConnection связывает платеж с оплаченным VPN подключением
+func (s *TransactionService) LinkConnection(id int, connectionID int) error {
+	_, err := s.db.Exec(`
+		UPDATE transactions SET vpn_connection_id = $2, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+	`, id, connectionID)
+	if err != nil {
+		return fmt.Errorf("ошибка связи платежа с подключением: %w", err)
+	}
+	return nil
+}
+
+// GetUnfinishedPayments возвращает платежи, обработка которых не завершена
+func (s *TransactionService) GetUnfinishedPayments() ([]*Transaction, error) {
+	return s.queryTransactions(`
+		SELECT `+transactionColumns+`
 		FROM transactions
-		ORDER BY created_at DESC
-		LIMIT 100
-	`)
+		WHERE type = $1 AND status IN ($2, $3)
+		ORDER BY created_at ASC
+	`, TransactionTypePayment, TransactionStatusPending, TransactionStatusProvisioning)
+}
+
+// GetTransactions возвращает страницу транзакций по фильтру (новые первыми)
+// и общее количество транзакций, подходящих под фильтр
+func (s *TransactionService) GetTransactions(filter TransactionFilter, limit, offset int) ([]*Transaction, int, error) {
+	where, args := filter.where()
+
+	var total int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM transactions `+where, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("ошибка подсчета транзакций: %w", err)
+	}
+
+	args = append(args, limit, offset)
+	transactions, err := s.queryTransactions(fmt.Sprintf(`
+		SELECT `+transactionColumns+`
+		FROM transactions
+		%s
+		ORDER BY created_at DESC, id DESC
+		LIMIT $%d OFFSET $%d
+	`, where, len(args)-1, len(args)), args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("ошибка получения транзакций: %w", err)
+	}
+	return transactions, total, nil
+}
+
+func (s *TransactionService) GetAllTransactions() ([]*Transaction, error) {
+	transactions, _, err := s.GetTransactions(TransactionFilter{}, 100, 0)
+	return transactions, err
+}
+
+func (s *TransactionService) queryTransactions(query string, args ...interface{}) ([]*Transaction, error) {
+	rows, err := s.db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -57,19 +395,7 @@ func (s *TransactionService) GetAllTransactions() ([]*Transaction, error) {
 
 	var transactions []*Transaction
 	for rows.Next() {
-		tx := &Transaction{}
-		err := rows.Scan(
-			&tx.ID,
-			&tx.TelegramPaymentChargeID,
-			&tx.TelegramUserID,
-			&tx.Amount,
-			&tx.InvoicePayload,
-			&tx.Status,
-			&tx.Type,
-			&tx.Reason,
-			&tx.CreatedAt,
-			&tx.UpdatedAt,
-		)
+		tx, err := scanTransaction(rows)
 		if err != nil {
 			return nil, err
 		}
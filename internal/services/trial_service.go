@@ -0,0 +1,269 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// TrialService выдает бесплатный пробный VPN один раз на Telegram ID и переводит
+// пользователя в состояние trial с записью в user_state_history
+type TrialService struct {
+	db *sql.DB
+}
+
+// NewTrialService создает новый сервис пробного периода
+func NewTrialService(db *sql.DB) *TrialService {
+	return &TrialService{db: db}
+}
+
+// IsAvailable проверяет, что пользователь еще не получал пробный VPN и ничего не покупал.
+// Пополнение баланса покупкой не считается.
+func (s *TrialService) IsAvailable(telegramUserID int64) (bool, error) {
+	var available bool
+	err := s.db.QueryRow(`
+		SELECT NOT EXISTS (SELECT 1 FROM trial_claims WHERE telegram_user_id = $1)
+		   AND NOT EXISTS (
+			SELECT 1 FROM transactions t
+			WHERE t.telegram_user_id = $1 AND t.type = $2 AND t.status = $3
+			  AND NOT EXISTS (SELECT 1 FROM ledger_entries e WHERE e.transaction_id = t.id AND e.kind = $4)
+		   )
+	`, telegramUserID, TransactionTypePayment, TransactionStatusFulfilled, LedgerKindTopUp).Scan(&available)
+	if err != nil {
+		return false, fmt.Errorf("ошибка проверки пробного периода: %w", err)
+	}
+	return available, nil
+}
+
+// Claim закрепляет пробный период за пользователем перед созданием VPN.
+// Возвращает false, если пробный период уже получен или у пользователя есть оплаченные покупки.
+func (s *TrialService) Claim(telegramUserID int64) (bool, error) {
+	result, err := s.db.Exec(`
+		INSERT INTO trial_claims (telegram_user_id)
+		SELECT $1 WHERE NOT EXISTS (
+			SELECT 1 FROM transactions t
+			WHERE t.telegram_user_id = $1 AND t.type = $2 AND t.status = $3
+			  AND NOT EXISTS (SELECT 1 FROM ledger_entries e WHERE e.transaction_id = t.id AND e.kind = $4)
+		)
+		ON CONFLICT (telegram_user_id) DO NOTHING
+	`, telegramUserID, TransactionTypePayment, TransactionStatusFulfilled, LedgerKindTopUp)
+	if err != nil {
+		return false, fmt.Errorf("ошибка получения пробного периода: %w", err)
+	}
+	rows, _ := result.RowsAffected()
+	return rows > 0, nil
+}
+
+// Release отменяет закрепление, если пробный VPN создать не удалось
+func (s *TrialService) Release(telegramUserID int64) error {
+	_, err := s.db.Exec(`
+		DELETE FROM trial_claims WHERE telegram_user_id = $1 AND activated_at IS NULL
+	`, telegramUserID)
+	if err != nil {
+		return fmt.Errorf("ошибка отмены пробного периода: %w", err)
+	}
+	return nil
+}
+
+// Activate связывает пробный период с созданным VPN и переводит пользователя в состояние trial.
+// Возвращает окончание состояния trial (по default_expiry_duration из user_states), nil — бессрочно.
+func (s *TrialService) Activate(telegramUserID int64, connectionID int) (*time.Time, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("ошибка начала транзакции: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		UPDATE trial_claims SET vpn_connection_id = $2, activated_at = CURRENT_TIMESTAMP
+		WHERE telegram_user_id = $1 AND activated_at IS NULL
+	`, telegramUserID, connectionID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка сохранения пробного VPN: %w", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return nil, fmt.Errorf("пробный период пользователя %d не найден или уже активирован", telegramUserID)
+	}
+
+	expiresAt, err := setUserState(tx, telegramUserID, UserStateTrial, "Получен пробный VPN", map[string]interface{}{
+		"trial_connection_id": connectionID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("ошибка сохранения пробного периода: %w", err)
+	}
+	return expiresAt, nil
+}
+
+// Convert отмечает первую оплату после пробного периода и возвращает пользователя
+// из состояния trial в active. Возвращает false, если пользователь не на пробном периоде.
+func (s *TrialService) Convert(telegramUserID int64, transactionID int) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("ошибка начала транзакции: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		UPDATE trial_claims SET converted_at = CURRENT_TIMESTAMP
+		WHERE telegram_user_id = $1 AND converted_at IS NULL
+	`, telegramUserID)
+	if err != nil {
+		return false, fmt.Errorf("ошибка отметки оплаты после пробного периода: %w", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return false, nil
+	}
+	if _, err := setUserStateFrom(tx, telegramUserID, UserStateTrial, UserStateActive, "Оплата после пробного периода", map[string]interface{}{
+		"transaction_id": transactionID,
+	}); err != nil {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("ошибка сохранения оплаты после пробного периода: %w", err)
+	}
+	return true, nil
+}
+
+// ExpireTrialStates возвращает в active пользователей, у которых истекло состояние trial.
+// Возвращает количество пользователей.
+func (s *TrialService) ExpireTrialStates() (int, error) {
+	rows, err := s.db.Query(`
+		SELECT telegram_id FROM telegram_users
+		WHERE state = $1 AND state_expires_at IS NOT NULL AND state_expires_at < CURRENT_TIMESTAMP
+	`, UserStateTrial)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка получения истекших пробных периодов: %w", err)
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("ошибка сканирования пользователя: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("ошибка чтения истекших пробных периодов: %w", err)
+	}
+
+	expired := 0
+	for _, id := range ids {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return expired, fmt.Errorf("ошибка начала транзакции: %w", err)
+		}
+		changed, err := setUserStateFrom(tx, id, UserStateTrial, UserStateActive, "Пробный период истек", nil)
+		if err == nil {
+			err = tx.Commit()
+		}
+		tx.Rollback()
+		if err != nil {
+			return expired, err
+		}
+		if changed {
+			expired++
+		}
+	}
+	return expired, nil
+}
+
+// MarkOfferSent отмечает предложение купить тариф после окончания пробного VPN.
+// Возвращает false, если подключение не пробное, предложение уже отправлено или пользователь уже оплатил.
+func (s *TrialService) MarkOfferSent(connectionID int) (bool, error) {
+	result, err := s.db.Exec(`
+		UPDATE trial_claims SET offer_sent_at = CURRENT_TIMESTAMP
+		WHERE vpn_connection_id = $1 AND offer_sent_at IS NULL AND converted_at IS NULL
+	`, connectionID)
+	if err != nil {
+		return false, fmt.Errorf("ошибка отметки предложения после пробного периода: %w", err)
+	}
+	rows, _ := result.RowsAffected()
+	return rows > 0, nil
+}
+
+// setUserState переводит пользователя в состояние newState в транзакции tx и записывает
+// историю. Ожидаемое действие и срок состояния берутся из user_states и state_action_mappings.
+func setUserState(tx *sql.Tx, telegramID int64, newState UserState, reason string, metadata map[string]interface{}) (*time.Time, error) {
+	var userID int
+	var oldState, oldAction string
+	err := tx.QueryRow(`
+		SELECT id, state, expected_action FROM telegram_users WHERE telegram_id = $1 FOR UPDATE
+	`, telegramID).Scan(&userID, &oldState, &oldAction)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("пользователь с Telegram ID %d не найден", telegramID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения состояния пользователя: %w", err)
+	}
+
+	var expiresAt sql.NullTime
+	var newAction string
+	err = tx.QueryRow(`
+		SELECT CASE WHEN s.auto_expire THEN CURRENT_TIMESTAMP + s.default_expiry_duration END,
+		       COALESCE(m.action_code, $2)
+		FROM user_states s
+		LEFT JOIN state_action_mappings m ON m.state_code = s.state_code AND m.is_default
+		WHERE s.state_code = $1
+	`, newState, ExpectedActionNone).Scan(&expiresAt, &newAction)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения определения состояния %s: %w", newState, err)
+	}
+	var expires *time.Time
+	if expiresAt.Valid {
+		expires = &expiresAt.Time
+	}
+
+	metadataJSON, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка сериализации метаданных: %w", err)
+	}
+	if _, err := tx.Exec(`
+		UPDATE telegram_users SET
+			state = $2,
+			expected_action = $3,
+			state_changed_at = CURRENT_TIMESTAMP,
+			state_reason = $4,
+			state_changed_by_tg_id = NULL,
+			state_changed_by_username = 'system',
+			state_expires_at = $5,
+			state_metadata = $6,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE telegram_id = $1
+	`, telegramID, newState, newAction, reason, expires, metadataJSON); err != nil {
+		return nil, fmt.Errorf("ошибка обновления состояния пользователя: %w", err)
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO user_state_history (
+			user_id, telegram_id, old_state, new_state, old_action, new_action,
+			reason, changed_by_username, expires_at, metadata
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, 'system', $8, $9)
+	`, userID, telegramID, oldState, newState, oldAction, newAction, reason, expires, metadataJSON); err != nil {
+		return nil, fmt.Errorf("ошибка добавления записи в историю: %w", err)
+	}
+	return expires, nil
+}
+
+// setUserStateFrom переводит пользователя в newState, только если он сейчас в состоянии from
+func setUserStateFrom(tx *sql.Tx, telegramID int64, from, newState UserState, reason string, metadata map[string]interface{}) (bool, error) {
+	var state string
+	err := tx.QueryRow(`SELECT state FROM telegram_users WHERE telegram_id = $1 FOR UPDATE`, telegramID).Scan(&state)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("ошибка получения состояния пользователя: %w", err)
+	}
+	if UserState(state) != from {
+		return false, nil
+	}
+	if _, err := setUserState(tx, telegramID, newState, reason, metadata); err != nil {
+		return false, err
+	}
+	return true, nil
+}
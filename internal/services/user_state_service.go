@@ -17,6 +17,7 @@ const (
 	UserStatePendingVerification UserState = "pending_verification"
 	UserStateSuspended           UserState = "suspended"
 	UserStateDeleted             UserState = "deleted"
+	UserStateTrial               UserState = "trial"
 )
 
 // ExpectedAction представляет ожидаемое действие от пользователя
@@ -541,6 +542,10 @@ func (s *UserStateService) CanUserPerformAction(telegramID int64) (bool, string,
 			return true, "", nil
 		}
 		return false, "Пользователь приостановлен", nil
+	case UserStateTrial:
+		// На пробном периоде можно покупать тарифы; истекшее состояние
+		// возвращает в active планировщик сроков
+		return true, "", nil
 	case UserStatePendingVerification:
 		return false, "Требуется верификация", nil
 	case UserStateDeleted:
@@ -24,6 +24,73 @@ type VPNConnection struct {
 	IsActive       bool      `json:"is_active"`
 	CreatedAt      time.Time `json:"created_at"`
 	UpdatedAt      time.Time `json:"updated_at"`
+
+	// DedicatedInbound - inbound создан только под это подключение и удаляется вместе с ним
+	DedicatedInbound bool `json:"dedicated_inbound"`
+	// RemovalPending - удаление на панели не удалось и будет повторено
+	RemovalPending  bool   `json:"removal_pending"`
+	RemovalError    string `json:"removal_error"`
+	RemovalAttempts int    `json:"removal_attempts"`
+
+	// TrafficLimitBytes - лимит трафика, 0 — без ограничения
+	TrafficLimitBytes int64 `json:"traffic_limit_bytes"`
+	// ExpiresAt - окончание действия, nil — бессрочно
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// LimitIP - максимум одновременных IP, 0 — без ограничения
+	LimitIP int    `json:"limit_ip"`
+	Flow    string `json:"flow"`
+	// PlanID - тариф подключения, 0 — создано без тарифа
+	PlanID int `json:"plan_id"`
+	// ExpiredAt - подключение отключено по окончании срока и может быть продлено
+	ExpiredAt *time.Time `json:"expired_at,omitempty"`
+}
+
+// IsExpired проверяет, что подключение отключено по окончании срока
+func (c *VPNConnection) IsExpired() bool {
+	return !c.IsActive && c.ExpiredAt != nil
+}
+
+// vpnConnectionColumns список колонок для scanVPNConnection
+const vpnConnectionColumns = `
+	id, telegram_user_id, username, first_name, last_name,
+	server_id, inbound_id, client_id, email, port,
+	vpn_login, vpn_password, vless_link, is_active,
+	created_at, updated_at,
+	dedicated_inbound, removal_pending, COALESCE(removal_error, ''), removal_attempts,
+	traffic_limit_bytes, expires_at, limit_ip, flow, COALESCE(plan_id, 0), expired_at
+`
+
+// rowScanner общий интерфейс для *sql.Row и *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanVPNConnection читает строку, выбранную с колонками vpnConnectionColumns
+func scanVPNConnection(row rowScanner) (*VPNConnection, error) {
+	connection := &VPNConnection{}
+	var expiresAt, expiredAt sql.NullTime
+	err := row.Scan(
+		&connection.ID, &connection.TelegramUserID, &connection.Username,
+		&connection.FirstName, &connection.LastName, &connection.ServerID,
+		&connection.InboundID, &connection.ClientID, &connection.Email,
+		&connection.Port, &connection.VPNLogin, &connection.VPNPassword,
+		&connection.VlessLink, &connection.IsActive, &connection.CreatedAt,
+		&connection.UpdatedAt,
+		&connection.DedicatedInbound, &connection.RemovalPending,
+		&connection.RemovalError, &connection.RemovalAttempts,
+		&connection.TrafficLimitBytes, &expiresAt, &connection.LimitIP, &connection.Flow,
+		&connection.PlanID, &expiredAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if expiresAt.Valid {
+		connection.ExpiresAt = &expiresAt.Time
+	}
+	if expiredAt.Valid {
+		connection.ExpiredAt = &expiredAt.Time
+	}
+	return connection, nil
 }
 
 // VPNConnectionService управляет VPN подключениями
@@ -36,40 +103,63 @@ func NewVPNConnectionService(db *sql.DB) *VPNConnectionService {
 	return &VPNConnectionService{db: db}
 }
 
-// SaveVPNConnection сохраняет VPN подключение в базу данных
-func (s *VPNConnectionService) SaveVPNConnection(connection *VPNConnection) error {
+// SaveVPNConnection сохраняет VPN подключение и его первый оплаченный период в одной транзакции.
+// Период начинается с создания подключения.
+func (s *VPNConnectionService) SaveVPNConnection(connection *VPNConnection, period *VPNConnectionPeriod) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("ошибка начала транзакции: %w", err)
+	}
+	defer tx.Rollback()
+
 	query := `
 		INSERT INTO vpn_connections (
 			telegram_user_id, username, first_name, last_name,
 			server_id, inbound_id, client_id, email, port,
-			vpn_login, vpn_password, vless_link
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+			vpn_login, vpn_password, vless_link, dedicated_inbound,
+			traffic_limit_bytes, expires_at, limit_ip, flow, plan_id
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, NULLIF($18, 0))
 		RETURNING id, created_at, updated_at
 	`
 
-	err := s.db.QueryRow(
+	err = tx.QueryRow(
 		query,
 		connection.TelegramUserID, connection.Username, connection.FirstName, connection.LastName,
 		connection.ServerID, connection.InboundID, connection.ClientID, connection.Email, connection.Port,
-		connection.VPNLogin, connection.VPNPassword, connection.VlessLink,
+		connection.VPNLogin, connection.VPNPassword, connection.VlessLink, connection.DedicatedInbound,
+		connection.TrafficLimitBytes, connection.ExpiresAt, connection.LimitIP, connection.Flow, connection.PlanID,
 	).Scan(&connection.ID, &connection.CreatedAt, &connection.UpdatedAt)
 
 	if err != nil {
 		return fmt.Errorf("ошибка сохранения VPN подключения: %w", err)
 	}
 
+	period.VPNConnectionID = connection.ID
+	period.StartsAt = connection.CreatedAt
+	err = tx.QueryRow(`
+		INSERT INTO vpn_connection_periods (
+			vpn_connection_id, plan_id, starts_at, expires_at, traffic_limit_bytes, telegram_payment_charge_id
+		) VALUES ($1, NULLIF($2, 0), $3, $4, $5, NULLIF($6, ''))
+		RETURNING id, created_at
+	`, period.VPNConnectionID, period.PlanID, period.StartsAt, period.ExpiresAt,
+		period.TrafficLimitBytes, period.TelegramPaymentChargeID,
+	).Scan(&period.ID, &period.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("ошибка сохранения периода VPN подключения: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("ошибка сохранения VPN подключения: %w", err)
+	}
 	return nil
 }
 
-// GetUserVPNConnections получает все VPN подключения пользователя
+// GetUserVPNConnections получает активные и истекшие (доступные для продления) VPN подключения пользователя
 func (s *VPNConnectionService) GetUserVPNConnections(telegramUserID int64) ([]*VPNConnection, error) {
 	query := `
-		SELECT id, telegram_user_id, username, first_name, last_name,
-			   server_id, inbound_id, client_id, email, port,
-			   vpn_login, vpn_password, vless_link, is_active,
-			   created_at, updated_at
+		SELECT ` + vpnConnectionColumns + `
 		FROM vpn_connections
-		WHERE telegram_user_id = $1 AND is_active = true
+		WHERE telegram_user_id = $1 AND (is_active = true OR expired_at IS NOT NULL) AND removal_pending = false
 		ORDER BY created_at DESC
 	`
 
@@ -81,15 +171,7 @@ func (s *VPNConnectionService) GetUserVPNConnections(telegramUserID int64) ([]*V
 
 	var connections []*VPNConnection
 	for rows.Next() {
-		connection := &VPNConnection{}
-		err := rows.Scan(
-			&connection.ID, &connection.TelegramUserID, &connection.Username,
-			&connection.FirstName, &connection.LastName, &connection.ServerID,
-			&connection.InboundID, &connection.ClientID, &connection.Email,
-			&connection.Port, &connection.VPNLogin, &connection.VPNPassword,
-			&connection.VlessLink, &connection.IsActive, &connection.CreatedAt,
-			&connection.UpdatedAt,
-		)
+		connection, err := scanVPNConnection(rows)
 		if err != nil {
 			return nil, fmt.Errorf("ошибка сканирования VPN подключения: %w", err)
 		}
@@ -102,23 +184,12 @@ func (s *VPNConnectionService) GetUserVPNConnections(telegramUserID int64) ([]*V
 // GetVPNConnectionByID получает VPN подключение по ID
 func (s *VPNConnectionService) GetVPNConnectionByID(id int) (*VPNConnection, error) {
 	query := `
-		SELECT id, telegram_user_id, username, first_name, last_name,
-			   server_id, inbound_id, client_id, email, port,
-			   vpn_login, vpn_password, vless_link, is_active,
-			   created_at, updated_at
+		SELECT ` + vpnConnectionColumns + `
 		FROM vpn_connections
 		WHERE id = $1
 	`
 
-	connection := &VPNConnection{}
-	err := s.db.QueryRow(query, id).Scan(
-		&connection.ID, &connection.TelegramUserID, &connection.Username,
-		&connection.FirstName, &connection.LastName, &connection.ServerID,
-		&connection.InboundID, &connection.ClientID, &connection.Email,
-		&connection.Port, &connection.VPNLogin, &connection.VPNPassword,
-		&connection.VlessLink, &connection.IsActive, &connection.CreatedAt,
-		&connection.UpdatedAt,
-	)
+	connection, err := scanVPNConnection(s.db.QueryRow(query, id))
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -135,6 +206,9 @@ func (s *VPNConnectionService) DeactivateVPNConnection(id int) error {
 	query := `
 		UPDATE vpn_connections SET
 			is_active = false,
+			expired_at = NULL,
+			removal_pending = false,
+			removal_error = NULL,
 			updated_at = CURRENT_TIMESTAMP
 		WHERE id = $1
 	`
@@ -181,7 +255,7 @@ func (s *VPNConnectionService) DeleteVPNConnection(id int) error {
 func (s *VPNConnectionService) GetUserVPNConnectionsCount(telegramUserID int64) (int, error) {
 	query := `
 		SELECT COUNT(*) FROM vpn_connections
-		WHERE telegram_user_id = $1 AND is_active = true
+		WHERE telegram_user_id = $1 AND is_active = true AND removal_pending = false
 	`
 
 	var count int
@@ -192,3 +266,289 @@ func (s *VPNConnectionService) GetUserVPNConnectionsCount(telegramUserID int64)
 
 	return count, nil
 }
+
+// CountActiveByServer возвращает количество активных подключений на каждом сервере
+func (s *VPNConnectionService) CountActiveByServer() (map[int]int, error) {
+	rows, err := s.db.Query(`
+		SELECT server_id, COUNT(*) FROM vpn_connections
+		WHERE is_active = true
+		GROUP BY server_id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка подсчета подключений по серверам: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[int]int)
+	for rows.Next() {
+		var serverID, count int
+		if err := rows.Scan(&serverID, &count); err != nil {
+			return nil, fmt.Errorf("ошибка сканирования количества подключений: %w", err)
+		}
+		counts[serverID] = count
+	}
+
+	return counts, nil
+}
+
+// MarkRemovalPending отмечает, что удаление на панели не удалось и должно быть повторено
+func (s *VPNConnectionService) MarkRemovalPending(id int, removalErr error) error {
+	query := `
+		UPDATE vpn_connections SET
+			removal_pending = true,
+			removal_error = $2,
+			removal_attempts = removal_attempts + 1,
+			removal_requested_at = COALESCE(removal_requested_at, CURRENT_TIMESTAMP),
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+	`
+
+	errText := ""
+	if removalErr != nil {
+		errText = removalErr.Error()
+	}
+
+	if _, err := s.db.Exec(query, id, errText); err != nil {
+		return fmt.Errorf("ошибка сохранения статуса удаления VPN подключения: %w", err)
+	}
+
+	return nil
+}
+
+// GetPendingRemovals получает подключения, удаление которых на панели нужно повторить
+func (s *VPNConnectionService) GetPendingRemovals(limit int) ([]*VPNConnection, error) {
+	query := `
+		SELECT ` + vpnConnectionColumns + `
+		FROM vpn_connections
+		WHERE removal_pending = true AND (is_active = true OR expired_at IS NOT NULL)
+		ORDER BY removal_requested_at ASC
+		LIMIT $1
+	`
+
+	rows, err := s.db.Query(query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения подключений для удаления: %w", err)
+	}
+	defer rows.Close()
+
+	var connections []*VPNConnection
+	for rows.Next() {
+		connection, err := scanVPNConnection(rows)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка сканирования VPN подключения: %w", err)
+		}
+		connections = append(connections, connection)
+	}
+
+	return connections, nil
+}
+
+// VPNConnectionPeriod оплаченный период действия VPN подключения
+type VPNConnectionPeriod struct {
+	ID              int
+	VPNConnectionID int
+	PlanID          int // 0 — без тарифа
+	StartsAt        time.Time
+	// ExpiresAt - окончание периода, nil — бессрочно
+	ExpiresAt         *time.Time
+	TrafficLimitBytes int64
+	// TelegramPaymentChargeID - платеж, которым оплачен период
+	TelegramPaymentChargeID string
+	CreatedAt               time.Time
+}
+
+// GetConnectionPeriods возвращает периоды подключения в порядке оплаты
+func (s *VPNConnectionService) GetConnectionPeriods(connectionID int) ([]*VPNConnectionPeriod, error) {
+	rows, err := s.db.Query(`
+		SELECT id, vpn_connection_id, COALESCE(plan_id, 0), starts_at, expires_at, traffic_limit_bytes,
+		       COALESCE(telegram_payment_charge_id, ''), created_at
+		FROM vpn_connection_periods
+		WHERE vpn_connection_id = $1
+		ORDER BY id
+	`, connectionID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения периодов VPN подключения: %w", err)
+	}
+	defer rows.Close()
+
+	var periods []*VPNConnectionPeriod
+	for rows.Next() {
+		period := &VPNConnectionPeriod{}
+		if err := rows.Scan(&period.ID, &period.VPNConnectionID, &period.PlanID, &period.StartsAt, &period.ExpiresAt,
+			&period.TrafficLimitBytes, &period.TelegramPaymentChargeID, &period.CreatedAt); err != nil {
+			return nil, fmt.Errorf("ошибка сканирования периода VPN подключения: %w", err)
+		}
+		periods = append(periods, period)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка чтения периодов VPN подключения: %w", err)
+	}
+	return periods, nil
+}
+
+// RevertPeriod сохраняет условия подключения после отмены оплаченного периода
+// и удаляет период в одной транзакции
+func (s *VPNConnectionService) RevertPeriod(connection *VPNConnection, periodID int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("ошибка начала транзакции: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE vpn_connections SET
+			expires_at = $2,
+			traffic_limit_bytes = $3,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+	`, connection.ID, connection.ExpiresAt, connection.TrafficLimitBytes)
+	if err != nil {
+		return fmt.Errorf("ошибка изменения срока VPN подключения: %w", err)
+	}
+	result, err := tx.Exec(`DELETE FROM vpn_connection_periods WHERE id = $1 AND vpn_connection_id = $2`, periodID, connection.ID)
+	if err != nil {
+		return fmt.Errorf("ошибка удаления периода VPN подключения: %w", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return fmt.Errorf("период %d VPN подключения %d не найден", periodID, connection.ID)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("ошибка сохранения отмены периода VPN подключения: %w", err)
+	}
+	return nil
+}
+
+// ExtendVPNConnection сохраняет новые условия подключения после продления
+// и записывает оплаченный период в одной транзакции. Истекшее подключение снова становится активным.
+func (s *VPNConnectionService) ExtendVPNConnection(connection *VPNConnection, period *VPNConnectionPeriod) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("ошибка начала транзакции: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		UPDATE vpn_connections SET
+			expires_at = $2,
+			traffic_limit_bytes = $3,
+			limit_ip = $4,
+			plan_id = NULLIF($5, 0),
+			vless_link = $6,
+			is_active = true,
+			expired_at = NULL,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND (is_active = true OR expired_at IS NOT NULL) AND removal_pending = false
+	`, connection.ID, connection.ExpiresAt, connection.TrafficLimitBytes, connection.LimitIP, connection.PlanID, connection.VlessLink)
+	if err != nil {
+		return fmt.Errorf("ошибка продления VPN подключения: %w", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return fmt.Errorf("VPN подключение с ID %d не найдено или удалено", connection.ID)
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO vpn_connection_periods (
+			vpn_connection_id, plan_id, starts_at, expires_at, traffic_limit_bytes, telegram_payment_charge_id
+		) VALUES ($1, NULLIF($2, 0), $3, $4, $5, NULLIF($6, ''))
+	`, connection.ID, period.PlanID, period.StartsAt, period.ExpiresAt,
+		period.TrafficLimitBytes, period.TelegramPaymentChargeID)
+	if err != nil {
+		return fmt.Errorf("ошибка сохранения периода VPN подключения: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("ошибка сохранения продления VPN подключения: %w", err)
+	}
+	return nil
+}
+
+// GetExpiringConnections получает активные подключения, срок которых истекает до before
+// (включая уже истекшие)
+func (s *VPNConnectionService) GetExpiringConnections(before time.Time) ([]*VPNConnection, error) {
+	return s.queryConnections(`
+		SELECT `+vpnConnectionColumns+`
+		FROM vpn_connections
+		WHERE is_active = true AND removal_pending = false
+		  AND expires_at IS NOT NULL AND expires_at <= $1
+		ORDER BY expires_at ASC
+	`, before)
+}
+
+// GetTrafficLimitedConnections получает активные подключения с лимитом трафика
+func (s *VPNConnectionService) GetTrafficLimitedConnections() ([]*VPNConnection, error) {
+	return s.queryConnections(`
+		SELECT ` + vpnConnectionColumns + `
+		FROM vpn_connections
+		WHERE is_active = true AND removal_pending = false AND traffic_limit_bytes > 0
+		ORDER BY server_id, id
+	`)
+}
+
+func (s *VPNConnectionService) queryConnections(query string, args ...interface{}) ([]*VPNConnection, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения VPN подключений: %w", err)
+	}
+	defer rows.Close()
+
+	var connections []*VPNConnection
+	for rows.Next() {
+		connection, err := scanVPNConnection(rows)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка сканирования VPN подключения: %w", err)
+		}
+		connections = append(connections, connection)
+	}
+	return connections, nil
+}
+
+// MarkExpired отключает подключение по окончании срока.
+// Возвращает false, если подключение уже не активно.
+func (s *VPNConnectionService) MarkExpired(id int) (bool, error) {
+	result, err := s.db.Exec(`
+		UPDATE vpn_connections SET
+			is_active = false,
+			expired_at = CURRENT_TIMESTAMP,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND is_active = true
+	`, id)
+	if err != nil {
+		return false, fmt.Errorf("ошибка отключения истекшего VPN подключения: %w", err)
+	}
+	rows, _ := result.RowsAffected()
+	return rows > 0, nil
+}
+
+// RecordReminder отмечает отправку напоминания kind для текущего периода подключения.
+// Возвращает false, если такое напоминание уже отправлялось.
+func (s *VPNConnectionService) RecordReminder(connectionID int, kind string) (bool, error) {
+	result, err := s.db.Exec(`
+		INSERT INTO vpn_connection_reminders (vpn_connection_id, period_id, kind)
+		SELECT $1, COALESCE(MAX(id), 0), $2 FROM vpn_connection_periods WHERE vpn_connection_id = $1
+		ON CONFLICT (vpn_connection_id, period_id, kind) DO NOTHING
+	`, connectionID, kind)
+	if err != nil {
+		return false, fmt.Errorf("ошибка сохранения напоминания: %w", err)
+	}
+	rows, _ := result.RowsAffected()
+	return rows > 0, nil
+}
+
+// GetConnectionIDByChargeID возвращает подключение, период которого оплачен платежом chargeID, 0 — нет такого
+func (s *VPNConnectionService) GetConnectionIDByChargeID(chargeID string) (int, error) {
+	var connectionID int
+	err := s.db.QueryRow(`
+		SELECT vpn_connection_id FROM vpn_connection_periods
+		WHERE telegram_payment_charge_id = $1
+		ORDER BY id DESC
+		LIMIT 1
+	`, chargeID).Scan(&connectionID)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("ошибка поиска подключения по платежу: %w", err)
+	}
+	return connectionID, nil
+}
@@ -0,0 +1,155 @@
+package services
+
+import (
+	"fmt"
+	"log"
+
+	"TelegramXUI/internal/xui_client"
+)
+
+// VPNRemovalService удаляет VPN подключения на панели x-ui и в базе данных
+type VPNRemovalService struct {
+	serverService        *XUIServerService
+	vpnConnectionService *VPNConnectionService
+	inboundPoolService   *InboundPoolService
+	portAllocator        *PortAllocatorService
+}
+
+// NewVPNRemovalService создает новый сервис удаления VPN подключений
+func NewVPNRemovalService(serverService *XUIServerService, vpnConnectionService *VPNConnectionService, inboundPoolService *InboundPoolService, portAllocator *PortAllocatorService) *VPNRemovalService {
+	return &VPNRemovalService{
+		serverService:        serverService,
+		vpnConnectionService: vpnConnectionService,
+		inboundPoolService:   inboundPoolService,
+		portAllocator:        portAllocator,
+	}
+}
+
+// RemoveVPNConnection удаляет подключение на панели и только после подтверждения
+// панели деактивирует запись в БД. При ошибке подключение помечается для повтора.
+func (s *VPNRemovalService) RemoveVPNConnection(connection *VPNConnection) error {
+	if err := s.removeFromPanel(connection); err != nil {
+		log.Printf("[VPNRemoval] Не удалось удалить подключение %d на панели: %v", connection.ID, err)
+		if markErr := s.vpnConnectionService.MarkRemovalPending(connection.ID, err); markErr != nil {
+			log.Printf("[VPNRemoval] Ошибка сохранения статуса удаления %d: %v", connection.ID, markErr)
+		}
+		return fmt.Errorf("ошибка удаления на панели: %w", err)
+	}
+
+	if err := s.vpnConnectionService.DeactivateVPNConnection(connection.ID); err != nil {
+		return err
+	}
+
+	log.Printf("[VPNRemoval] Подключение %d удалено (server=%d, inbound=%d, email=%s)",
+		connection.ID, connection.ServerID, connection.InboundID, connection.Email)
+	return nil
+}
+
+// RetryPendingRemovals повторяет незавершенные удаления, возвращает количество успешных
+func (s *VPNRemovalService) RetryPendingRemovals() (int, error) {
+	connections, err := s.vpnConnectionService.GetPendingRemovals(100)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, connection := range connections {
+		if err := s.RemoveVPNConnection(connection); err != nil {
+			continue
+		}
+		removed++
+	}
+
+	if len(connections) > 0 {
+		log.Printf("[VPNRemoval] Повтор удаления: успешно %d из %d", removed, len(connections))
+	}
+	return removed, nil
+}
+
+// removeFromPanel удаляет клиента (или весь выделенный inbound) на панели.
+// Если inbound или клиент уже отсутствуют, удаление считается выполненным.
+func (s *VPNRemovalService) removeFromPanel(connection *VPNConnection) error {
+	server, err := s.serverService.GetServerByID(connection.ServerID)
+	if err != nil {
+		return err
+	}
+	if server == nil {
+		log.Printf("[VPNRemoval] Сервер %d для подключения %d не найден, удаляем только запись", connection.ServerID, connection.ID)
+		return nil
+	}
+
+	xui := xui_client.NewClient(server.ServerURL, server.Username, server.Password)
+	if err := xui.Login(); err != nil {
+		return fmt.Errorf("ошибка входа в x-ui: %w", err)
+	}
+
+	inbounds, err := xui.ListInbounds()
+	if err != nil {
+		return err
+	}
+
+	var inbound *xui_client.Inbound
+	for i := range inbounds {
+		if inbounds[i].ID == connection.InboundID {
+			inbound = &inbounds[i]
+			break
+		}
+	}
+	if inbound == nil {
+		log.Printf("[VPNRemoval] Inbound %d уже отсутствует на сервере %s", connection.InboundID, server.ServerName)
+		if connection.DedicatedInbound {
+			s.releaseInboundPort(connection.ServerID, connection.InboundID)
+		}
+		return nil
+	}
+
+	// Панель не позволяет удалить последнего клиента inbound,
+	// поэтому выделенный inbound удаляется целиком
+	if connection.DedicatedInbound {
+		return s.deleteInbound(xui, connection.ServerID, inbound.ID)
+	}
+
+	clients, err := inbound.Clients()
+	if err != nil {
+		return err
+	}
+	for _, client := range clients {
+		if client.Email != connection.Email {
+			continue
+		}
+		if len(clients) > 1 {
+			return xui.DeleteClient(inbound.ID, client.Key(inbound.Protocol))
+		}
+		// Последний клиент общего inbound: удаляем inbound и убираем его из пула,
+		// при следующей покупке сервер создаст новый
+		if err := s.deleteInbound(xui, connection.ServerID, inbound.ID); err != nil {
+			return err
+		}
+		if s.inboundPoolService != nil {
+			return s.inboundPoolService.RemovePoolInbound(connection.ServerID, inbound.ID)
+		}
+		return nil
+	}
+
+	log.Printf("[VPNRemoval] Клиент %s уже отсутствует в inbound %d", connection.Email, inbound.ID)
+	return nil
+}
+
+// deleteInbound удаляет inbound на панели и освобождает его порт в реестре
+func (s *VPNRemovalService) deleteInbound(xui *xui_client.Client, serverID, inboundID int) error {
+	if err := xui.DeleteInbound(inboundID); err != nil {
+		return err
+	}
+	s.releaseInboundPort(serverID, inboundID)
+	return nil
+}
+
+// releaseInboundPort освобождает порт inbound; ошибка не критична, реестр исправит сверка с панелью
+func (s *VPNRemovalService) releaseInboundPort(serverID, inboundID int) {
+	if s.portAllocator == nil {
+		return
+	}
+	if err := s.portAllocator.ReleaseInbound(serverID, inboundID); err != nil {
+		log.Printf("[VPNRemoval] Ошибка освобождения порта inbound %d: %v", inboundID, err)
+	}
+}
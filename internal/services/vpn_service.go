@@ -3,7 +3,7 @@ package services
 import (
 	"fmt"
 	"log"
-	"math/rand"
+	"time"
 
 	"TelegramXUI/internal/config"
 	"TelegramXUI/internal/xui_client"
@@ -13,13 +13,19 @@ import (
 type VPNService struct {
 	xuiClient            *xui_client.Client
 	vpnConnectionService *VPNConnectionService
+	realityService       *RealityService
+	inboundPoolService   *InboundPoolService
+	portAllocator        *PortAllocatorService
 }
 
 // NewVPNService создает новый сервис VPN
-func NewVPNService(xuiClient *xui_client.Client, vpnConnectionService *VPNConnectionService) *VPNService {
+func NewVPNService(xuiClient *xui_client.Client, vpnConnectionService *VPNConnectionService, realityService *RealityService, inboundPoolService *InboundPoolService, portAllocator *PortAllocatorService) *VPNService {
 	return &VPNService{
 		xuiClient:            xuiClient,
 		vpnConnectionService: vpnConnectionService,
+		realityService:       realityService,
+		inboundPoolService:   inboundPoolService,
+		portAllocator:        portAllocator,
 	}
 }
 
@@ -31,48 +37,86 @@ func (s *VPNService) CheckStatus() error {
 	return s.xuiClient.Login()
 }
 
-// CreateVPNForUser создает VPN подключение для пользователя
-func (s *VPNService) CreateVPNForUser(telegramUserID int64, userName string, firstName string, lastName string, serverID int, vpnConfig *config.VPNConfig) (*VPNConnection, error) {
+// CreateVPNForUser создает VPN подключение для пользователя.
+// В режиме shared клиент добавляется в наименее загруженный общий inbound сервера,
+// иначе для подключения создается отдельный inbound.
+func (s *VPNService) CreateVPNForUser(telegramUserID int64, userName string, firstName string, lastName string, server *XUIServer, spec ProvisionSpec, vpnConfig *config.VPNConfig) (*VPNConnection, error) {
 	if s.xuiClient == nil {
 		return nil, fmt.Errorf("x-ui клиент не инициализирован")
 	}
 
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+
 	// Логируем в x-ui
 	if err := s.xuiClient.Login(); err != nil {
 		return nil, fmt.Errorf("ошибка входа в x-ui: %w", err)
 	}
 
-	// Используем случайный порт для inbound из конфигурации
-	portRange := vpnConfig.PortRangeEnd - vpnConfig.PortRangeStart
-	port := vpnConfig.PortRangeStart + rand.Intn(portRange)
-	inboundName := fmt.Sprintf("VPN для %s (ID: %d)", userName, telegramUserID)
-
-	log.Printf("[VPN] Создание inbound для пользователя %s (ID: %d) на порту %d", userName, telegramUserID, port)
-
-	emptyInbound := xui_client.GenerateEmptyInboundForm(port, inboundName)
-	inboundId, err := s.xuiClient.AddInbound(emptyInbound)
-	if err != nil || inboundId == 0 {
-		return nil, fmt.Errorf("ошибка создания inbound: %w", err)
+	var inboundId, port int
+	var err error
+	if server.IsShared() {
+		inboundId, port, err = s.acquirePoolInbound(server, vpnConfig)
+	} else {
+		inboundName := fmt.Sprintf("VPN для %s (ID: %d)", userName, telegramUserID)
+		inboundId, port, err = s.createInbound(server, inboundName, vpnConfig)
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	log.Printf("[VPN] Inbound создан успешно: id=%d, port=%d", inboundId, port)
+	// Выделенный inbound создан только для этого подключения: при любой ошибке дальше
+	// он удаляется с панели вместе с портом, чтобы не остался inbound без владельца
+	discardInbound := func() {
+		if !server.IsShared() {
+			s.rollbackInbound(server.ID, inboundId, port)
+		}
+	}
 
-	// Создаем случайного клиента
-	clientId, email, subId, settings := xui_client.GenerateRandomClientSettings(10)
+	// Создаем клиента с параметрами покупки
+	expiresAt := spec.ExpiresAt(time.Now())
+	expiryTime := int64(0)
+	if expiresAt != nil {
+		expiryTime = expiresAt.UnixMilli()
+	}
+	email := readableEmail(userName, telegramUserID)
+	inboundClient := xui_client.NewInboundClient(email, telegramUserID, spec.TrafficLimitBytes, expiryTime, spec.LimitIP, spec.Flow)
+	settings, err := xui_client.ClientSettingsJSON(inboundClient)
+	if err != nil {
+		discardInbound()
+		return nil, fmt.Errorf("ошибка формирования клиента: %w", err)
+	}
 	addClientForm := &xui_client.AddClientForm{
 		Id:       inboundId,
 		Settings: settings,
 	}
 
 	if err := s.xuiClient.AddClientToInbound(addClientForm); err != nil {
+		discardInbound()
 		return nil, fmt.Errorf("ошибка добавления клиента: %w", err)
 	}
 
-	log.Printf("[VPN] Клиент добавлен успешно: id=%d, email=%s, subId=%s", clientId, email, subId)
+	clientId, subId := inboundClient.ID, inboundClient.SubID
+	log.Printf("[VPN] Клиент добавлен успешно: id=%s, email=%s, subId=%s, трафик=%d, истекает=%d, limitIp=%d",
+		clientId, email, subId, spec.TrafficLimitBytes, expiryTime, spec.LimitIP)
+
+	// Дальше клиент уже на панели: при ошибке его нужно убрать, иначе он займет
+	// место в общем inbound без записи в vpn_connections
+	rollback := func() {
+		if server.IsShared() {
+			s.rollbackPoolClient(inboundId, clientId)
+		} else {
+			discardInbound()
+		}
+	}
 
-	// Генерируем VLESS ссылку
-	vlessLink := fmt.Sprintf("vless://%s@%s:%d?encryption=none&security=tls&sni=%s&fp=chrome&type=ws&path=/&host=%s#%s",
-		clientId, vpnConfig.ServerIP, port, vpnConfig.ServerIP, vpnConfig.ServerIP, email)
+	// Генерируем ссылку по фактическим настройкам inbound на панели
+	vlessLink, err := s.buildShareLink(inboundId, email, server.LinkAddress(vpnConfig.ServerIP))
+	if err != nil {
+		rollback()
+		return nil, fmt.Errorf("ошибка генерации ссылки подключения: %w", err)
+	}
 
 	// Создаем VPN подключение
 	vpnConnection := &VPNConnection{
@@ -80,7 +124,7 @@ func (s *VPNService) CreateVPNForUser(telegramUserID int64, userName string, fir
 		Username:       userName,
 		FirstName:      firstName,
 		LastName:       lastName,
-		ServerID:       serverID,
+		ServerID:       server.ID,
 		InboundID:      inboundId,
 		ClientID:       clientId,
 		Email:          email,
@@ -89,10 +133,27 @@ func (s *VPNService) CreateVPNForUser(telegramUserID int64, userName string, fir
 		VPNPassword:    subId,
 		VlessLink:      vlessLink,
 		IsActive:       true,
+
+		DedicatedInbound: !server.IsShared(),
+
+		TrafficLimitBytes: spec.TrafficLimitBytes,
+		ExpiresAt:         expiresAt,
+		LimitIP:           spec.LimitIP,
+		Flow:              spec.Flow,
+		PlanID:            spec.PlanID,
 	}
 
-	// Сохраняем в базу данных
-	if err := s.vpnConnectionService.SaveVPNConnection(vpnConnection); err != nil {
+	// Первый период хранит платеж: по нему повторная обработка платежа находит уже созданное
+	// подключение, поэтому подключение и период сохраняются одной транзакцией
+	period := &VPNConnectionPeriod{
+		PlanID:            spec.PlanID,
+		ExpiresAt:         expiresAt,
+		TrafficLimitBytes: spec.TrafficLimitBytes,
+
+		TelegramPaymentChargeID: spec.PaymentChargeID,
+	}
+	if err := s.vpnConnectionService.SaveVPNConnection(vpnConnection, period); err != nil {
+		rollback()
 		return nil, fmt.Errorf("ошибка сохранения VPN подключения: %w", err)
 	}
 
@@ -100,3 +161,307 @@ func (s *VPNService) CreateVPNForUser(telegramUserID int64, userName string, fir
 
 	return vpnConnection, nil
 }
+
+// RenewVPNConnection продлевает подключение по условиям spec: срок добавляется
+// к текущему окончанию, счетчик трафика сбрасывается и лимит устанавливается заново.
+// chargeID — платеж, которым оплачено продление, address — адрес сервера для ссылки подключения.
+func (s *VPNService) RenewVPNConnection(connection *VPNConnection, spec ProvisionSpec, chargeID string, address string) (*VPNConnection, error) {
+	if s.xuiClient == nil {
+		return nil, fmt.Errorf("x-ui клиент не инициализирован")
+	}
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+
+	if err := s.xuiClient.Login(); err != nil {
+		return nil, fmt.Errorf("ошибка входа в x-ui: %w", err)
+	}
+
+	inbound, err := s.xuiClient.GetInbound(connection.InboundID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения inbound %d: %w", connection.InboundID, err)
+	}
+	client, err := inbound.FindClient(connection.Email)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка поиска клиента на панели: %w", err)
+	}
+
+	startsAt, expiresAt := spec.RenewalPeriod(connection.ExpiresAt, time.Now())
+	expiryTime := int64(0)
+	if expiresAt != nil {
+		expiryTime = expiresAt.UnixMilli()
+	}
+
+	// Сначала сбрасываем трафик: если сброс не удался, условия на панели не меняются
+	if err := s.xuiClient.ResetClientTraffic(connection.InboundID, connection.Email); err != nil {
+		return nil, fmt.Errorf("ошибка сброса трафика клиента: %w", err)
+	}
+
+	client.ExpiryTime = expiryTime
+	client.TotalGB = spec.TrafficLimitBytes
+	client.LimitIP = spec.LimitIP
+	client.Enable = true
+	if err := s.xuiClient.UpdateClient(connection.InboundID, client.Key(inbound.Protocol), *client); err != nil {
+		return nil, fmt.Errorf("ошибка обновления клиента: %w", err)
+	}
+
+	if connection.DedicatedInbound && !inbound.Enable {
+		if err := s.xuiClient.SetInboundEnable(connection.InboundID, true); err != nil {
+			return nil, fmt.Errorf("ошибка включения inbound %d: %w", connection.InboundID, err)
+		}
+	}
+
+	log.Printf("[VPN] Подключение %d продлено: истекает=%d, трафик=%d, limitIp=%d",
+		connection.ID, expiryTime, spec.TrafficLimitBytes, spec.LimitIP)
+
+	renewed := *connection
+	renewed.ExpiresAt = expiresAt
+	renewed.TrafficLimitBytes = spec.TrafficLimitBytes
+	renewed.LimitIP = spec.LimitIP
+	renewed.PlanID = spec.PlanID
+
+	// Ссылка пересобирается по адресу сервера: у старых подключений мог быть общий VPN_SERVER_IP
+	if vlessLink, err := s.buildShareLink(connection.InboundID, connection.Email, address); err != nil {
+		log.Printf("[VPN] Не удалось обновить ссылку подключения %d: %v", connection.ID, err)
+	} else {
+		renewed.VlessLink = vlessLink
+	}
+
+	period := &VPNConnectionPeriod{
+		VPNConnectionID:         connection.ID,
+		PlanID:                  spec.PlanID,
+		StartsAt:                startsAt,
+		ExpiresAt:               expiresAt,
+		TrafficLimitBytes:       spec.TrafficLimitBytes,
+		TelegramPaymentChargeID: chargeID,
+	}
+	if err := s.vpnConnectionService.ExtendVPNConnection(&renewed, period); err != nil {
+		return nil, err
+	}
+
+	return &renewed, nil
+}
+
+// RevertPeriod отменяет оплаченный период продления periods[index] (периоды подключения в порядке
+// оплаты): срок подключения сокращается на длительность периода, а если период последний,
+// лимит трафика возвращается к предыдущему периоду. Условия меняются на панели и в БД, период удаляется.
+func (s *VPNService) RevertPeriod(connection *VPNConnection, periods []*VPNConnectionPeriod, index int) (*VPNConnection, error) {
+	if s.xuiClient == nil {
+		return nil, fmt.Errorf("x-ui клиент не инициализирован")
+	}
+	if index <= 0 || index >= len(periods) {
+		return nil, fmt.Errorf("период %d не является продлением подключения %d", index, connection.ID)
+	}
+	period, previous := periods[index], periods[index-1]
+	latest := index == len(periods)-1
+
+	reverted := *connection
+	switch {
+	case period.ExpiresAt == nil:
+		// Бессрочное продление: срок возвращается к предыдущему периоду, только если после него не продлевали
+		if latest {
+			reverted.ExpiresAt = previous.ExpiresAt
+		}
+	case connection.ExpiresAt != nil:
+		expiresAt := connection.ExpiresAt.Add(-period.ExpiresAt.Sub(period.StartsAt))
+		reverted.ExpiresAt = &expiresAt
+	}
+	if latest {
+		reverted.TrafficLimitBytes = previous.TrafficLimitBytes
+	}
+
+	if err := s.xuiClient.Login(); err != nil {
+		return nil, fmt.Errorf("ошибка входа в x-ui: %w", err)
+	}
+	inbound, err := s.xuiClient.GetInbound(connection.InboundID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения inbound %d: %w", connection.InboundID, err)
+	}
+	client, err := inbound.FindClient(connection.Email)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка поиска клиента на панели: %w", err)
+	}
+	client.ExpiryTime = 0
+	if reverted.ExpiresAt != nil {
+		client.ExpiryTime = reverted.ExpiresAt.UnixMilli()
+	}
+	client.TotalGB = reverted.TrafficLimitBytes
+	if err := s.xuiClient.UpdateClient(connection.InboundID, client.Key(inbound.Protocol), *client); err != nil {
+		return nil, fmt.Errorf("ошибка обновления клиента: %w", err)
+	}
+
+	if err := s.vpnConnectionService.RevertPeriod(&reverted, period.ID); err != nil {
+		return nil, err
+	}
+	log.Printf("[VPN] Период %d подключения %d отменен: истекает=%s, трафик=%d",
+		period.ID, connection.ID, FormatExpiry(reverted.ExpiresAt), reverted.TrafficLimitBytes)
+	return &reverted, nil
+}
+
+// DisableVPNConnection отключает клиента подключения на панели, выделенный inbound
+// отключается целиком. Отсутствие inbound или клиента на панели не считается ошибкой.
+func (s *VPNService) DisableVPNConnection(connection *VPNConnection) error {
+	if s.xuiClient == nil {
+		return fmt.Errorf("x-ui клиент не инициализирован")
+	}
+	if err := s.xuiClient.Login(); err != nil {
+		return fmt.Errorf("ошибка входа в x-ui: %w", err)
+	}
+
+	inbounds, err := s.xuiClient.ListInbounds()
+	if err != nil {
+		return err
+	}
+	var inbound *xui_client.Inbound
+	for i := range inbounds {
+		if inbounds[i].ID == connection.InboundID {
+			inbound = &inbounds[i]
+			break
+		}
+	}
+	if inbound == nil {
+		log.Printf("[VPN] Inbound %d подключения %d отсутствует на панели", connection.InboundID, connection.ID)
+		return nil
+	}
+
+	client, err := inbound.FindClient(connection.Email)
+	if err != nil {
+		log.Printf("[VPN] Клиент подключения %d отсутствует на панели: %v", connection.ID, err)
+		return nil
+	}
+	if client.Enable {
+		client.Enable = false
+		if err := s.xuiClient.UpdateClient(inbound.ID, client.Key(inbound.Protocol), *client); err != nil {
+			return fmt.Errorf("ошибка отключения клиента: %w", err)
+		}
+	}
+	if connection.DedicatedInbound && inbound.Enable {
+		if err := s.xuiClient.SetInboundEnable(inbound.ID, false); err != nil {
+			return fmt.Errorf("ошибка отключения inbound %d: %w", inbound.ID, err)
+		}
+	}
+
+	log.Printf("[VPN] Подключение %d отключено на панели", connection.ID)
+	return nil
+}
+
+// createInbound создает на панели пустой inbound с ключами REALITY сервера
+func (s *VPNService) createInbound(server *XUIServer, remark string, vpnConfig *config.VPNConfig) (int, int, error) {
+	if s.realityService == nil {
+		return 0, 0, fmt.Errorf("сервис ключей REALITY не инициализирован")
+	}
+
+	// Ключи REALITY уникальны для каждого сервера
+	reality, err := s.realityService.EnsureServerReality(server.ID)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	port, err := s.reservePort(server.ID, vpnConfig)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	log.Printf("[VPN] Создание inbound \"%s\" на сервере %d, порт %d", remark, server.ID, port)
+
+	emptyInbound := xui_client.GenerateEmptyInboundForm(port, remark, reality.Options())
+	inboundId, err := s.xuiClient.AddInbound(emptyInbound)
+	if err != nil || inboundId == 0 {
+		if releaseErr := s.portAllocator.Release(server.ID, port); releaseErr != nil {
+			log.Printf("[VPN] Ошибка освобождения порта %d: %v", port, releaseErr)
+		}
+		return 0, 0, fmt.Errorf("ошибка создания inbound: %w", err)
+	}
+
+	if err := s.portAllocator.Bind(server.ID, port, inboundId); err != nil {
+		log.Printf("[VPN] Ошибка привязки порта %d к inbound %d: %v", port, inboundId, err)
+	}
+
+	log.Printf("[VPN] Inbound создан успешно: id=%d, port=%d", inboundId, port)
+	return inboundId, port, nil
+}
+
+// reservePort сверяет реестр портов с панелью и резервирует свободный порт из диапазона конфигурации
+func (s *VPNService) reservePort(serverID int, vpnConfig *config.VPNConfig) (int, error) {
+	if s.portAllocator == nil {
+		return 0, fmt.Errorf("сервис выделения портов не инициализирован")
+	}
+
+	inbounds, err := s.xuiClient.ListInbounds()
+	if err != nil {
+		return 0, fmt.Errorf("ошибка получения inbound для сверки портов: %w", err)
+	}
+	if err := s.portAllocator.Reconcile(serverID, inbounds); err != nil {
+		return 0, err
+	}
+
+	return s.portAllocator.Reserve(serverID, vpnConfig.PortRangeStart, vpnConfig.PortRangeEnd)
+}
+
+// acquirePoolInbound возвращает наименее загруженный общий inbound сервера,
+// при отсутствии свободного создает новый и добавляет его в пул
+func (s *VPNService) acquirePoolInbound(server *XUIServer, vpnConfig *config.VPNConfig) (int, int, error) {
+	if s.inboundPoolService == nil {
+		return 0, 0, fmt.Errorf("сервис общих inbound не инициализирован")
+	}
+
+	pool, err := s.inboundPoolService.SelectLeastLoaded(server.ID)
+	if err != nil {
+		return 0, 0, err
+	}
+	if pool != nil {
+		log.Printf("[VPN] Выбран общий inbound %d (клиентов: %d) на сервере %d", pool.InboundID, pool.ClientCount, server.ID)
+		return pool.InboundID, pool.Port, nil
+	}
+
+	remark := fmt.Sprintf("Pool %s #%d", server.ServerName, time.Now().Unix())
+	inboundId, port, err := s.createInbound(server, remark, vpnConfig)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if _, err := s.inboundPoolService.AddPoolInbound(server.ID, inboundId, port, vpnConfig.PoolInboundMaxClients); err != nil {
+		s.rollbackInbound(server.ID, inboundId, port)
+		return 0, 0, err
+	}
+
+	log.Printf("[VPN] Новый общий inbound %d добавлен в пул сервера %d", inboundId, server.ID)
+	return inboundId, port, nil
+}
+
+// rollbackInbound удаляет с панели inbound, созданный для невыданного подключения, и освобождает его порт.
+// Если inbound удалить не удалось, порт остается за ним в реестре — inbound на панели его занимает.
+func (s *VPNService) rollbackInbound(serverID, inboundID, port int) {
+	if err := s.xuiClient.DeleteInbound(inboundID); err != nil {
+		log.Printf("[VPN] Не удалось удалить inbound %d после ошибки: %v", inboundID, err)
+		return
+	}
+	if err := s.portAllocator.Release(serverID, port); err != nil {
+		log.Printf("[VPN] Ошибка освобождения порта %d: %v", port, err)
+	}
+	log.Printf("[VPN] Inbound %d и порт %d освобождены после ошибки", inboundID, port)
+}
+
+// rollbackPoolClient удаляет из общего inbound клиента, подключение которого не удалось сохранить
+func (s *VPNService) rollbackPoolClient(inboundID int, clientID string) {
+	if err := s.xuiClient.DeleteClient(inboundID, clientID); err != nil {
+		log.Printf("[VPN] Не удалось удалить клиента %s из общего inbound %d: %v", clientID, inboundID, err)
+		return
+	}
+	log.Printf("[VPN] Клиент %s удален из общего inbound %d после ошибки", clientID, inboundID)
+}
+
+// buildShareLink получает inbound с панели и формирует ссылку для клиента с указанным email
+func (s *VPNService) buildShareLink(inboundID int, email string, address string) (string, error) {
+	inbound, err := s.xuiClient.GetInbound(inboundID)
+	if err != nil {
+		return "", err
+	}
+
+	client, err := inbound.FindClient(email)
+	if err != nil {
+		return "", err
+	}
+
+	return xui_client.BuildShareLink(inbound, client, address, email)
+}
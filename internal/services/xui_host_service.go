@@ -6,6 +6,14 @@ import (
 	"time"
 )
 
+// Режимы выдачи VPN на хосте
+const (
+	// ProvisioningModePerInbound — отдельный inbound на каждое подключение
+	ProvisioningModePerInbound = "per_inbound"
+	// ProvisioningModeShared — клиенты добавляются в общие (pool) inbound хоста
+	ProvisioningModeShared = "shared"
+)
+
 type XUIServer struct {
 	ID              int       `json:"id"`
 	ServerURL       string    `json:"server_url"`
@@ -22,6 +30,46 @@ type XUIServer struct {
 	AddedByUsername string    `json:"added_by_username"`
 	CreatedAt       time.Time `json:"created_at"`
 	UpdatedAt       time.Time `json:"updated_at"`
+
+	ProvisioningMode string `json:"provisioning_mode"`
+	Weight           int    `json:"weight"`
+	LastLatencyMs    int    `json:"last_latency_ms"` // 0 — задержка еще не измерена
+}
+
+// IsShared сообщает, выдаются ли подключения через общие inbound
+func (s *XUIServer) IsShared() bool {
+	return s.ProvisioningMode == ProvisioningModeShared
+}
+
+// LinkAddress адрес сервера для ссылок подключения; fallback — общий VPN_SERVER_IP,
+// если адрес сервера не задан
+func (s *XUIServer) LinkAddress(fallback string) string {
+	if s.ServerIP != "" {
+		return s.ServerIP
+	}
+	return fallback
+}
+
+// xuiServerColumns список колонок xui_servers в порядке scanXUIServer
+const xuiServerColumns = `id, server_url, COALESCE(server_name, ''), COALESCE(server_location, ''), COALESCE(server_ip, ''),
+			   COALESCE(server_port, 0), COALESCE(username, ''), COALESCE(password, ''), COALESCE(secret_key, ''),
+			   COALESCE(two_factor_secret, ''), COALESCE(is_active, false), added_by_tg_id, COALESCE(added_by_username, ''),
+			   created_at, updated_at, provisioning_mode, weight, COALESCE(last_latency_ms, 0)`
+
+// scanXUIServer читает строку, выбранную с колонками xuiServerColumns
+func scanXUIServer(row rowScanner) (*XUIServer, error) {
+	server := &XUIServer{}
+	err := row.Scan(
+		&server.ID, &server.ServerURL, &server.ServerName, &server.ServerLocation,
+		&server.ServerIP, &server.ServerPort, &server.Username, &server.Password,
+		&server.SecretKey, &server.TwoFactorSecret, &server.IsActive,
+		&server.AddedByTgID, &server.AddedByUsername, &server.CreatedAt, &server.UpdatedAt,
+		&server.ProvisioningMode, &server.Weight, &server.LastLatencyMs,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return server, nil
 }
 
 type XUIServerService struct {
@@ -40,7 +88,7 @@ func (s *XUIServerService) AddServer(server *XUIServer) error {
 			server_port, username, password, secret_key, two_factor_secret, is_active, 
 			added_by_tg_id, added_by_username
 		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
-		RETURNING id, created_at, updated_at
+		RETURNING id, created_at, updated_at, provisioning_mode, weight
 	`
 
 	err := s.db.QueryRow(
@@ -49,7 +97,7 @@ func (s *XUIServerService) AddServer(server *XUIServer) error {
 		server.ServerPort, server.Username, server.Password, server.SecretKey,
 		server.TwoFactorSecret, server.IsActive,
 		server.AddedByTgID, server.AddedByUsername,
-	).Scan(&server.ID, &server.CreatedAt, &server.UpdatedAt)
+	).Scan(&server.ID, &server.CreatedAt, &server.UpdatedAt, &server.ProvisioningMode, &server.Weight)
 
 	if err != nil {
 		return fmt.Errorf("ошибка добавления сервера: %w", err)
@@ -61,9 +109,7 @@ func (s *XUIServerService) AddServer(server *XUIServer) error {
 // GetServersByAddedBy получает все серверы, добавленные конкретным пользователем
 func (s *XUIServerService) GetServersByAddedBy(tgID int64) ([]*XUIServer, error) {
 	query := `
-		SELECT id, server_url, server_name, server_location, server_ip,
-			   server_port, username, password, secret_key, two_factor_secret, is_active, 
-			   added_by_tg_id, added_by_username, created_at, updated_at
+		SELECT ` + xuiServerColumns + `
 		FROM xui_servers
 		WHERE added_by_tg_id = $1
 		ORDER BY created_at DESC
@@ -77,13 +123,7 @@ func (s *XUIServerService) GetServersByAddedBy(tgID int64) ([]*XUIServer, error)
 
 	var servers []*XUIServer
 	for rows.Next() {
-		server := &XUIServer{}
-		err := rows.Scan(
-			&server.ID, &server.ServerURL, &server.ServerName, &server.ServerLocation,
-			&server.ServerIP, &server.ServerPort, &server.Username, &server.Password,
-			&server.SecretKey, &server.TwoFactorSecret, &server.IsActive,
-			&server.AddedByTgID, &server.AddedByUsername, &server.CreatedAt, &server.UpdatedAt,
-		)
+		server, err := scanXUIServer(rows)
 		if err != nil {
 			return nil, fmt.Errorf("ошибка сканирования сервера: %w", err)
 		}
@@ -96,9 +136,7 @@ func (s *XUIServerService) GetServersByAddedBy(tgID int64) ([]*XUIServer, error)
 // GetAllServers получает все серверы с пагинацией
 func (s *XUIServerService) GetAllServers(limit, offset int) ([]*XUIServer, error) {
 	query := `
-		SELECT id, server_url, server_name, server_location, server_ip,
-			   server_port, username, password, secret_key, two_factor_secret, is_active,
-			   added_by_tg_id, added_by_username, created_at, updated_at
+		SELECT ` + xuiServerColumns + `
 		FROM xui_servers
 		ORDER BY created_at DESC
 		LIMIT $1 OFFSET $2
@@ -112,13 +150,7 @@ func (s *XUIServerService) GetAllServers(limit, offset int) ([]*XUIServer, error
 
 	var servers []*XUIServer
 	for rows.Next() {
-		server := &XUIServer{}
-		err := rows.Scan(
-			&server.ID, &server.ServerURL, &server.ServerName, &server.ServerLocation,
-			&server.ServerIP, &server.ServerPort, &server.Username, &server.Password,
-			&server.SecretKey, &server.TwoFactorSecret, &server.IsActive,
-			&server.AddedByTgID, &server.AddedByUsername, &server.CreatedAt, &server.UpdatedAt,
-		)
+		server, err := scanXUIServer(rows)
 		if err != nil {
 			return nil, fmt.Errorf("ошибка сканирования сервера: %w", err)
 		}
@@ -131,20 +163,12 @@ func (s *XUIServerService) GetAllServers(limit, offset int) ([]*XUIServer, error
 // GetServerByID получает сервер по ID
 func (s *XUIServerService) GetServerByID(id int) (*XUIServer, error) {
 	query := `
-		SELECT id, server_url, server_name, server_location, server_ip,
-			   server_port, username, password, secret_key, two_factor_secret, is_active,
-			   added_by_tg_id, added_by_username, created_at, updated_at
+		SELECT ` + xuiServerColumns + `
 		FROM xui_servers
 		WHERE id = $1
 	`
 
-	server := &XUIServer{}
-	err := s.db.QueryRow(query, id).Scan(
-		&server.ID, &server.ServerURL, &server.ServerName, &server.ServerLocation,
-		&server.ServerIP, &server.ServerPort, &server.Username, &server.Password,
-		&server.SecretKey, &server.TwoFactorSecret, &server.IsActive,
-		&server.AddedByTgID, &server.AddedByUsername, &server.CreatedAt, &server.UpdatedAt,
-	)
+	server, err := scanXUIServer(s.db.QueryRow(query, id))
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -159,20 +183,12 @@ func (s *XUIServerService) GetServerByID(id int) (*XUIServer, error) {
 // GetServerByURL получает сервер по URL
 func (s *XUIServerService) GetServerByURL(url string) (*XUIServer, error) {
 	query := `
-		SELECT id, server_url, server_name, server_location, server_ip,
-			   server_port, username, password, secret_key, two_factor_secret, is_active,
-			   added_by_tg_id, added_by_username, created_at, updated_at
+		SELECT ` + xuiServerColumns + `
 		FROM xui_servers
 		WHERE server_url = $1
 	`
 
-	server := &XUIServer{}
-	err := s.db.QueryRow(query, url).Scan(
-		&server.ID, &server.ServerURL, &server.ServerName, &server.ServerLocation,
-		&server.ServerIP, &server.ServerPort, &server.Username, &server.Password,
-		&server.SecretKey, &server.TwoFactorSecret, &server.IsActive,
-		&server.AddedByTgID, &server.AddedByUsername, &server.CreatedAt, &server.UpdatedAt,
-	)
+	server, err := scanXUIServer(s.db.QueryRow(query, url))
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -247,9 +263,7 @@ func (s *XUIServerService) GetServersCount() (int, error) {
 // GetActiveServers получает только активные серверы
 func (s *XUIServerService) GetActiveServers() ([]*XUIServer, error) {
 	query := `
-		SELECT id, server_url, server_name, server_location, server_ip,
-			   server_port, username, password, secret_key, two_factor_secret, is_active,
-			   added_by_tg_id, added_by_username, created_at, updated_at
+		SELECT ` + xuiServerColumns + `
 		FROM xui_servers
 		WHERE is_active = true
 		ORDER BY created_at DESC
@@ -263,13 +277,7 @@ func (s *XUIServerService) GetActiveServers() ([]*XUIServer, error) {
 
 	var servers []*XUIServer
 	for rows.Next() {
-		server := &XUIServer{}
-		err := rows.Scan(
-			&server.ID, &server.ServerURL, &server.ServerName, &server.ServerLocation,
-			&server.ServerIP, &server.ServerPort, &server.Username, &server.Password,
-			&server.SecretKey, &server.TwoFactorSecret, &server.IsActive,
-			&server.AddedByTgID, &server.AddedByUsername, &server.CreatedAt, &server.UpdatedAt,
-		)
+		server, err := scanXUIServer(rows)
 		if err != nil {
 			return nil, fmt.Errorf("ошибка сканирования сервера: %w", err)
 		}
@@ -282,9 +290,7 @@ func (s *XUIServerService) GetActiveServers() ([]*XUIServer, error) {
 // GetServersByDateRange получает серверы в определенном диапазоне дат
 func (s *XUIServerService) GetServersByDateRange(startDate, endDate time.Time) ([]*XUIServer, error) {
 	query := `
-		SELECT id, server_url, server_name, server_location, server_ip,
-			   server_port, username, password, secret_key, two_factor_secret, is_active,
-			   added_by_tg_id, added_by_username, created_at, updated_at
+		SELECT ` + xuiServerColumns + `
 		FROM xui_servers
 		WHERE created_at BETWEEN $1 AND $2
 		ORDER BY created_at DESC
@@ -298,13 +304,7 @@ func (s *XUIServerService) GetServersByDateRange(startDate, endDate time.Time) (
 
 	var servers []*XUIServer
 	for rows.Next() {
-		server := &XUIServer{}
-		err := rows.Scan(
-			&server.ID, &server.ServerURL, &server.ServerName, &server.ServerLocation,
-			&server.ServerIP, &server.ServerPort, &server.Username, &server.Password,
-			&server.SecretKey, &server.TwoFactorSecret, &server.IsActive,
-			&server.AddedByTgID, &server.AddedByUsername, &server.CreatedAt, &server.UpdatedAt,
-		)
+		server, err := scanXUIServer(rows)
 		if err != nil {
 			return nil, fmt.Errorf("ошибка сканирования сервера: %w", err)
 		}
@@ -340,12 +340,86 @@ func (s *XUIServerService) SetServerStatus(serverID int, isActive bool) error {
 	return nil
 }
 
+// SetProvisioningMode устанавливает режим выдачи VPN на сервере
+func (s *XUIServerService) SetProvisioningMode(serverID int, mode string) error {
+	if mode != ProvisioningModePerInbound && mode != ProvisioningModeShared {
+		return fmt.Errorf("неизвестный режим выдачи: %s", mode)
+	}
+
+	result, err := s.db.Exec(`
+		UPDATE xui_servers SET
+			provisioning_mode = $2,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+	`, serverID, mode)
+	if err != nil {
+		return fmt.Errorf("ошибка обновления режима выдачи: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("ошибка получения количества обновленных строк: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("сервер с ID %d не найден", serverID)
+	}
+
+	return nil
+}
+
+// SetServerWeight устанавливает вес сервера для взвешенного выбора хоста
+func (s *XUIServerService) SetServerWeight(serverID int, weight int) error {
+	if weight < 0 {
+		return fmt.Errorf("вес не может быть отрицательным")
+	}
+
+	result, err := s.db.Exec(`
+		UPDATE xui_servers SET
+			weight = $2,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+	`, serverID, weight)
+	if err != nil {
+		return fmt.Errorf("ошибка обновления веса сервера: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("ошибка получения количества обновленных строк: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("сервер с ID %d не найден", serverID)
+	}
+
+	return nil
+}
+
+// RecordLatency сохраняет время ответа панели, измеренное мониторингом
+func (s *XUIServerService) RecordLatency(serverID int, latency time.Duration) error {
+	latencyMs := int(latency.Milliseconds())
+	if latencyMs < 1 {
+		latencyMs = 1
+	}
+
+	_, err := s.db.Exec(`
+		UPDATE xui_servers SET
+			last_latency_ms = $2,
+			last_checked_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+	`, serverID, latencyMs)
+	if err != nil {
+		return fmt.Errorf("ошибка сохранения задержки сервера: %w", err)
+	}
+
+	return nil
+}
+
 // GetInactiveServers получает неактивные серверы
 func (s *XUIServerService) GetInactiveServers() ([]*XUIServer, error) {
 	query := `
-		SELECT id, server_url, server_name, server_location, server_ip,
-			   server_port, username, password, secret_key, two_factor_secret, is_active,
-			   added_by_tg_id, added_by_username, created_at, updated_at
+		SELECT ` + xuiServerColumns + `
 		FROM xui_servers
 		WHERE is_active = false
 		ORDER BY updated_at DESC
@@ -359,13 +433,7 @@ func (s *XUIServerService) GetInactiveServers() ([]*XUIServer, error) {
 
 	var servers []*XUIServer
 	for rows.Next() {
-		server := &XUIServer{}
-		err := rows.Scan(
-			&server.ID, &server.ServerURL, &server.ServerName, &server.ServerLocation,
-			&server.ServerIP, &server.ServerPort, &server.Username, &server.Password,
-			&server.SecretKey, &server.TwoFactorSecret, &server.IsActive,
-			&server.AddedByTgID, &server.AddedByUsername, &server.CreatedAt, &server.UpdatedAt,
-		)
+		server, err := scanXUIServer(rows)
 		if err != nil {
 			return nil, fmt.Errorf("ошибка сканирования сервера: %w", err)
 		}
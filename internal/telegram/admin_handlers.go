@@ -2,7 +2,11 @@ package telegram
 
 import (
 	"TelegramXUI/internal/services"
+	"TelegramXUI/internal/xui_client"
+	"errors"
 	"fmt"
+	"log"
+	"strconv"
 	"strings"
 )
 
@@ -15,6 +19,18 @@ func (p *MessageProcessor) handleCallback(client *TelegramClient, update Update)
 		return p.handleCheckHostsCallback(client, update)
 	} else if strings.HasPrefix(data, "refund_") {
 		return p.handleRefundCallback(client, update)
+	} else if strings.HasPrefix(data, "txp_") {
+		return p.handleTransactionsPageCallback(client, update)
+	} else if strings.HasPrefix(data, "bal_") {
+		return p.handleBalancePayCallback(client, update)
+	} else if strings.HasPrefix(data, "topup_") {
+		return p.handleTopUpCallback(client, update)
+	} else if strings.HasPrefix(data, "sub_") {
+		return p.handleSubscriptionCallback(client, update)
+	} else if strings.HasPrefix(data, "gift_plan_") {
+		return p.handleGiftPlanCallback(client, update)
+	} else if data == trialCallbackData {
+		return p.handleTrialCallback(client, update)
 	} else if data == "create_vpn" || strings.HasPrefix(data, "vpn_") {
 		return p.handleCallbackVPN(client, update)
 	}
@@ -43,53 +59,247 @@ func (p *MessageProcessor) handleCheckHostsCallback(client *TelegramClient, upda
 	return p.sendMessageHTML(client, int(userID), "🔍 Принудительная проверка хостов запущена (детальная реализация — см. сервисы)")
 }
 
-// handleRefundCallback - обработка callback для возврата средств
+// handleRefundCallback - обработка callback для возврата средств:
+// refund_<id> — запрос подтверждения, refund_confirm_<id> — возврат, refund_cancel_<id> — отмена
 func (p *MessageProcessor) handleRefundCallback(client *TelegramClient, update Update) error {
 	userID := int64(update.CallbackQuery.From.ID)
 	if !p.adminService.IsGlobalAdmin(userID) {
 		return p.sendErrorMessage(client, int(userID), "Нет прав для возврата средств")
 	}
-	parts := strings.Split(update.CallbackQuery.Data, "_")
-	if len(parts) != 2 {
-		return p.sendErrorMessage(client, int(userID), "Неверный формат callback для возврата")
+	data := update.CallbackQuery.Data
+	if strings.HasPrefix(data, "refund_cancel_") {
+		return p.sendMessageHTML(client, int(userID), "❌ Возврат отменен")
 	}
-	var txID int
-	if _, err := fmt.Sscanf(parts[1], "%d", &txID); err != nil {
+	confirmed := strings.HasPrefix(data, "refund_confirm_")
+	data = strings.TrimPrefix(strings.TrimPrefix(data, "refund_confirm_"), "refund_")
+
+	txID, err := strconv.Atoi(data)
+	if err != nil {
 		return p.sendErrorMessage(client, int(userID), "Неверный ID транзакции")
 	}
-	transactions, err := p.transactionService.GetAllTransactions()
+	tx, err := p.transactionService.GetTransactionByID(txID)
 	if err != nil {
 		return p.sendErrorMessage(client, int(userID), "Ошибка поиска транзакции")
 	}
-	var tx *services.Transaction
-	for _, t := range transactions {
-		if t.ID == txID {
-			tx = t
-			break
-		}
-	}
 	if tx == nil {
 		return p.sendErrorMessage(client, int(userID), "Транзакция не найдена")
 	}
-	if tx.Type != "payment" || tx.Status != "success" {
+	if tx.Type != services.TransactionTypePayment || tx.Status != services.TransactionStatusFulfilled {
 		return p.sendErrorMessage(client, int(userID), "Возврат возможен только для успешных платежей")
 	}
 	if tx.TelegramPaymentChargeID == "" {
 		return p.sendErrorMessage(client, int(userID), "В транзакции отсутствует идентификатор платежа (telegram_payment_charge_id). Возврат невозможен.")
 	}
-	errRefund := client.RefundStarPayment(tx.TelegramUserID, tx.TelegramPaymentChargeID, tx.Amount, "Возврат по запросу админа")
+
+	if !confirmed {
+		return p.sendRefundConfirmation(client, int(userID), tx)
+	}
+	return p.refundPayment(client, int(userID), tx)
+}
+
+// sendRefundConfirmation показывает администратору, что произойдет при возврате, и просит подтверждения
+func (p *MessageProcessor) sendRefundConfirmation(client *TelegramClient, chatID int, tx *services.Transaction) error {
+	message := fmt.Sprintf("↩️ <b>Возврат платежа %d</b>\n\nПользователь: <code>%d</code>\nСумма: <b>%d ⭐</b>\nДата: %s\n",
+		tx.ID, tx.TelegramUserID, tx.Amount, tx.CreatedAt.Format("02.01.2006 15:04"))
+	if tx.VPNConnectionID != 0 {
+		vpn, _, index, err := p.refundedVPNPeriod(tx)
+		switch {
+		case err != nil:
+			log.Printf("[sendRefundConfirmation] %v", err)
+			message += fmt.Sprintf("\n⚠️ Не удалось проверить VPN #%d, оплаченный этим платежом.\n", tx.VPNConnectionID)
+		case vpn == nil:
+			message += fmt.Sprintf("\nℹ️ VPN #%d, оплаченный этим платежом, уже удален.\n", tx.VPNConnectionID)
+		case index == 0:
+			message += fmt.Sprintf("\n⚠️ VPN #%d, оплаченный этим платежом, будет удален на сервере.\n", tx.VPNConnectionID)
+		case index > 0:
+			message += fmt.Sprintf("\n⚠️ Продление VPN #%d этим платежом будет отменено: срок сократится на оплаченный период.\n", tx.VPNConnectionID)
+		default:
+			message += fmt.Sprintf("\nℹ️ Период VPN #%d, оплаченный этим платежом, не найден — срок не изменится.\n", tx.VPNConnectionID)
+		}
+	}
+	if gift, err := p.giftService.GetGiftByTransaction(tx.ID); err != nil {
+		log.Printf("[sendRefundConfirmation] %v", err)
+	} else if gift != nil && gift.RedeemedByTgID == 0 && gift.CancelledAt == nil {
+		message += "\n⚠️ Неактивированный подарочный код этого платежа будет аннулирован.\n"
+	}
+	message += "\nПодтвердите возврат средств."
+
+	keyboard := &InlineKeyboardMarkup{InlineKeyboard: [][]InlineKeyboardButton{{
+		{Text: "✅ Подтвердить возврат", CallbackData: fmt.Sprintf("refund_confirm_%d", tx.ID)},
+		{Text: "❌ Отмена", CallbackData: fmt.Sprintf("refund_cancel_%d", tx.ID)},
+	}}}
+	_, err := client.SendMessageWithKeyboard(chatID, message, keyboard)
+	return err
+}
+
+// refundPayment возвращает звезды по платежу (оплату с баланса — на баланс), отменяет оплаченный им VPN или продление
+// и уведомляет пользователя
+func (p *MessageProcessor) refundPayment(client *TelegramClient, chatID int, tx *services.Transaction) error {
+	claimed, err := p.transactionService.ClaimRefund(tx.ID)
+	if err != nil {
+		log.Printf("[refundPayment] %v", err)
+		return p.sendErrorMessage(client, chatID, "Ошибка начала возврата")
+	}
+	if !claimed {
+		return p.sendErrorMessage(client, chatID, "Возврат по этому платежу уже выполнен")
+	}
+
+	errRefund := p.returnPaymentFunds(client, tx, "Возврат по запросу админа", int64(chatID))
 	if errRefund != nil {
-		return p.sendErrorMessage(client, int(userID), fmt.Sprintf("Ошибка возврата: %v", errRefund))
-	}
-	refundTx := &services.Transaction{
-		TelegramPaymentChargeID: tx.TelegramPaymentChargeID,
-		TelegramUserID:          tx.TelegramUserID,
-		Amount:                  tx.Amount,
-		InvoicePayload:          tx.InvoicePayload,
-		Status:                  "success",
-		Type:                    "refund",
-		Reason:                  "Возврат по запросу админа",
-	}
-	_ = p.transactionService.AddTransaction(refundTx)
-	return p.sendMessageHTML(client, int(userID), "✅ Возврат средств инициирован через Telegram Stars")
+		if err := p.transactionService.FinishPayment(tx.ID, services.TransactionStatusFulfilled, fmt.Errorf("возврат: %w", errRefund)); err != nil {
+			log.Printf("[refundPayment] %v", err)
+		}
+		if errors.Is(errRefund, services.ErrInsufficientBalance) {
+			return p.sendErrorMessage(client, chatID, "Пользователь уже потратил это пополнение, вернуть его нельзя")
+		}
+		return p.sendErrorMessage(client, chatID, fmt.Sprintf("Ошибка возврата: %v", errRefund))
+	}
+	if err := p.transactionService.AddRefund(tx, "Возврат по запросу админа"); err != nil {
+		log.Printf("[refundPayment] %v", err)
+	}
+	if tx.PromoCodeID != 0 {
+		if err := p.promoService.ReleaseRedemption(tx.ID); err != nil {
+			log.Printf("[refundPayment] Платеж %d: %v", tx.ID, err)
+		}
+	}
+	if err := p.referralService.ReleaseBonusDays(tx.ID); err != nil {
+		log.Printf("[refundPayment] Платеж %d: %v", tx.ID, err)
+	}
+	log.Printf("[refundPayment] Платеж %d пользователя %d возвращен (%d ⭐)", tx.ID, tx.TelegramUserID, tx.Amount)
+
+	result := "✅ Возврат средств выполнен через Telegram Stars"
+	userMessage := fmt.Sprintf("↩️ Администратор вернул вам %d ⭐ за платеж от %s.", tx.Amount, tx.CreatedAt.Format("02.01.2006"))
+	if tx.PaymentMethod == services.PaymentMethodBalance {
+		result = "✅ Средства возвращены на баланс пользователя"
+		userMessage = fmt.Sprintf("↩️ Администратор вернул на ваш баланс %d ⭐ за покупку от %s.", tx.Amount, tx.CreatedAt.Format("02.01.2006"))
+	} else if isTopUp(tx) {
+		result += ", пополнение списано с баланса"
+	}
+	result += p.revokeReferralReward(client, tx, int64(chatID))
+	if cancelled, err := p.giftService.Cancel(tx.ID); err != nil {
+		log.Printf("[refundPayment] %v", err)
+	} else if cancelled {
+		result += "\n🎁 Подарочный код аннулирован."
+		userMessage += " Подарочный код больше не действует."
+	}
+	if tx.VPNConnectionID != 0 {
+		vpnResult, vpnMessage := p.revokeRefundedVPN(client, tx)
+		result += vpnResult
+		userMessage += vpnMessage
+	}
+
+	if err := p.sendMessage(client, int(tx.TelegramUserID), userMessage); err != nil {
+		log.Printf("[refundPayment] Ошибка уведомления пользователя %d: %v", tx.TelegramUserID, err)
+	}
+	return p.sendMessageHTML(client, chatID, result)
+}
+
+// revokeReferralReward отзывает награду пригласившему за возвращенный платеж приглашенного
+// и уведомляет пригласившего. Возвращает дополнение к отчету администратору.
+func (p *MessageProcessor) revokeReferralReward(client *TelegramClient, tx *services.Transaction, adminTgID int64) string {
+	referrerID, revoked, err := p.referralService.RevokeReward(tx.ID, adminTgID)
+	switch {
+	case err != nil:
+		log.Printf("[revokeReferralReward] Платеж %d: %v", tx.ID, err)
+		return "\n⚠️ Не удалось отозвать награду за приглашение, проверьте /referrals пригласившего."
+	case referrerID == 0:
+		return ""
+	case !revoked:
+		return fmt.Sprintf("\nℹ️ Пригласивший <code>%d</code> уже использовал награду за этот платеж, она не отозвана.", referrerID)
+	}
+	log.Printf("[revokeReferralReward] Награда пользователю %d за платеж %d отозвана", referrerID, tx.ID)
+	if err := p.sendMessage(client, int(referrerID), "↩️ Приглашенный вами друг получил возврат первой оплаты, награда за приглашение отменена."); err != nil {
+		log.Printf("[revokeReferralReward] Ошибка уведомления пользователя %d: %v", referrerID, err)
+	}
+	return fmt.Sprintf("\n👥 Награда пригласившему <code>%d</code> отозвана.", referrerID)
+}
+
+// refundedVPNPeriod находит подключение платежа и оплаченный им период. Индекс 0 — платеж создал
+// подключение, больше 0 — продлил его, -1 — период не найден. vpn nil — подключение уже удалено.
+func (p *MessageProcessor) refundedVPNPeriod(tx *services.Transaction) (*services.VPNConnection, []*services.VPNConnectionPeriod, int, error) {
+	vpn, err := p.vpnConnectionService.GetVPNConnectionByID(tx.VPNConnectionID)
+	if err != nil {
+		return nil, nil, -1, err
+	}
+	if vpn == nil || !(vpn.IsActive || vpn.IsExpired()) || vpn.RemovalPending {
+		return nil, nil, -1, nil
+	}
+	periods, err := p.vpnConnectionService.GetConnectionPeriods(vpn.ID)
+	if err != nil {
+		return nil, nil, -1, err
+	}
+	for i, period := range periods {
+		if period.TelegramPaymentChargeID == tx.TelegramPaymentChargeID {
+			return vpn, periods, i, nil
+		}
+	}
+	// Периоды подключений, созданных до их учета, не хранят платеж: платеж за создание
+	// по-прежнему удаляет подключение, а продление не трогает срок
+	payload, err := services.ParseInvoicePayload(tx.InvoicePayload)
+	if err != nil || payload.Action == services.PayloadActionCreateVPN || payload.Action == services.PayloadActionGiftVPN {
+		return vpn, periods, 0, nil
+	}
+	return vpn, periods, -1, nil
+}
+
+// revokeRefundedVPN отменяет то, что оплатил возвращенный платеж: подключение, созданное платежом,
+// удаляется, а продление отменяется — срок сокращается на оплаченный период. Подписка отменяется,
+// если подключение удалено или возвращен платеж по подписке.
+// Возвращает дополнения к отчету администратору и к уведомлению пользователя.
+func (p *MessageProcessor) revokeRefundedVPN(client *TelegramClient, tx *services.Transaction) (string, string) {
+	connectionID := tx.VPNConnectionID
+	vpn, periods, index, err := p.refundedVPNPeriod(tx)
+	if err != nil {
+		log.Printf("[revokeRefundedVPN] Платеж %d: %v", tx.ID, err)
+		return fmt.Sprintf("\n⚠️ Не удалось проверить VPN #%d, отмените оплаченный срок вручную.", connectionID), ""
+	}
+	if vpn == nil {
+		return fmt.Sprintf("\nℹ️ VPN #%d уже был удален.", connectionID), ""
+	}
+
+	var result, userMessage string
+	subscription := false
+	if payload, err := services.ParseInvoicePayload(tx.InvoicePayload); err == nil {
+		subscription = payload.Action == services.PayloadActionSubscribeVPN
+	}
+	if (index == 0 || subscription) && p.cancelConnectionSubscription(client, connectionID) {
+		result += fmt.Sprintf("\n🔁 Подписка на VPN #%d отменена.", connectionID)
+		userMessage += " Подписка отменена."
+	}
+
+	switch {
+	case index == 0:
+		if err := p.vpnRemovalService.RemoveVPNConnection(vpn); err != nil {
+			log.Printf("[revokeRefundedVPN] Ошибка удаления VPN %d: %v", connectionID, err)
+			result += fmt.Sprintf("\n⚠️ VPN #%d не удалось удалить на сервере, удаление будет повторено (/retry_removals).", connectionID)
+		} else {
+			result += fmt.Sprintf("\n🗑️ VPN #%d удален.", connectionID)
+		}
+		userMessage += fmt.Sprintf(" VPN #%d отключен.", connectionID)
+	case index > 0:
+		reverted, err := p.revertVPNPeriod(vpn, periods, index)
+		if err != nil {
+			log.Printf("[revokeRefundedVPN] Ошибка отмены продления VPN %d: %v", connectionID, err)
+			return result + fmt.Sprintf("\n⚠️ Не удалось отменить продление VPN #%d, сократите срок вручную.", connectionID), userMessage
+		}
+		result += fmt.Sprintf("\n⏪ Продление VPN #%d отменено, действует до %s.", connectionID, services.FormatExpiry(reverted.ExpiresAt))
+		userMessage += fmt.Sprintf(" Продление VPN #%d отменено, VPN действует до %s.", connectionID, services.FormatExpiry(reverted.ExpiresAt))
+	default:
+		result += fmt.Sprintf("\nℹ️ Период VPN #%d, оплаченный платежом, не найден — срок не изменен.", connectionID)
+	}
+	return result, userMessage
+}
+
+// revertVPNPeriod отменяет оплаченный период продления подключения на его сервере
+func (p *MessageProcessor) revertVPNPeriod(vpn *services.VPNConnection, periods []*services.VPNConnectionPeriod, index int) (*services.VPNConnection, error) {
+	server, err := p.xuiServerService.GetServerByID(vpn.ServerID)
+	if err != nil {
+		return nil, err
+	}
+	if server == nil {
+		return nil, fmt.Errorf("сервер %d подключения %d не найден", vpn.ServerID, vpn.ID)
+	}
+	xui := xui_client.NewClient(server.ServerURL, server.Username, server.Password)
+	vpnService := services.NewVPNService(xui, p.vpnConnectionService, p.realityService, p.inboundPoolService, p.portAllocator)
+	return vpnService.RevertPeriod(vpn, periods, index)
 }
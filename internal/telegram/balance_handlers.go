@@ -0,0 +1,344 @@
+package telegram
+
+import (
+	"TelegramXUI/internal/services"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// topUpAmounts суммы пополнения на кнопках /balance
+var topUpAmounts = []int{50, 100, 250, 500}
+
+// balanceEntriesShown сколько последних операций показывает /balance
+const balanceEntriesShown = 10
+
+// balanceChargePrefix префикс идентификатора платежа с баланса вместо telegram_payment_charge_id
+const balanceChargePrefix = "balance_"
+
+// handleBalanceCommand показывает баланс и последние операции: /balance,
+// администратор может посмотреть баланс пользователя: /balance <user_id>
+func (p *MessageProcessor) handleBalanceCommand(client *TelegramClient, update Update, args []string) error {
+	chatID := update.Message.Chat.ID
+	userID := int64(update.Message.From.ID)
+	own := true
+	if len(args) > 0 && p.adminService.IsGlobalAdmin(userID) {
+		id, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			return p.sendErrorMessage(client, chatID, "Неверный ID пользователя")
+		}
+		userID, own = id, id == userID
+	}
+
+	balance, err := p.ledgerService.GetBalance(userID)
+	if err != nil {
+		log.Printf("[handleBalanceCommand] %v", err)
+		return p.sendErrorMessage(client, chatID, "Ошибка получения баланса")
+	}
+	entries, err := p.ledgerService.GetEntries(userID, balanceEntriesShown)
+	if err != nil {
+		log.Printf("[handleBalanceCommand] %v", err)
+		return p.sendErrorMessage(client, chatID, "Ошибка получения операций")
+	}
+
+	var sb strings.Builder
+	if own {
+		sb.WriteString(fmt.Sprintf("💰 <b>Ваш баланс: %d ⭐</b>\n\n", balance))
+	} else {
+		sb.WriteString(fmt.Sprintf("💰 <b>Баланс пользователя <code>%d</code>: %d ⭐</b>\n\n", userID, balance))
+	}
+	if len(entries) == 0 {
+		sb.WriteString("Операций пока нет.\n")
+	} else {
+		sb.WriteString("<b>Последние операции:</b>\n")
+	}
+	for _, entry := range entries {
+		sb.WriteString(fmt.Sprintf("%s %s: <b>%+d ⭐</b> → %d ⭐", entry.CreatedAt.Format("02.01.06 15:04"),
+			services.FormatLedgerKind(entry.Kind), entry.Amount, entry.BalanceAfter))
+		if entry.Description != "" {
+			sb.WriteString(" — " + html.EscapeString(entry.Description))
+		}
+		sb.WriteString("\n")
+	}
+	if !own {
+		return p.sendMessageHTML(client, chatID, sb.String())
+	}
+
+	sb.WriteString("\nС баланса можно оплатить покупку или продление VPN. Пополнить на другую сумму: <code>/topup СУММА</code>")
+	var row []InlineKeyboardButton
+	for _, amount := range topUpAmounts {
+		row = append(row, InlineKeyboardButton{Text: fmt.Sprintf("+%d ⭐", amount), CallbackData: fmt.Sprintf("topup_%d", amount)})
+	}
+	return p.sendMessageWithKeyboard(client, chatID, sb.String(), &InlineKeyboardMarkup{InlineKeyboard: [][]InlineKeyboardButton{row}})
+}
+
+// handleTopUpCommand выставляет счет на пополнение баланса: /topup СУММА
+func (p *MessageProcessor) handleTopUpCommand(client *TelegramClient, update Update, args []string) error {
+	chatID := update.Message.Chat.ID
+	if len(args) != 1 {
+		return p.sendMessageHTML(client, chatID, "Использование: <code>/topup СУММА</code>")
+	}
+	amount, err := strconv.Atoi(args[0])
+	if err != nil {
+		return p.sendErrorMessage(client, chatID, "Сумма должна быть числом")
+	}
+	return p.sendTopUpInvoice(client, chatID, int64(update.Message.From.ID), amount)
+}
+
+// handleTopUpCallback выставляет счет на пополнение по кнопке topup_<сумма>
+func (p *MessageProcessor) handleTopUpCallback(client *TelegramClient, update Update) error {
+	userID := int64(update.CallbackQuery.From.ID)
+	var amount int
+	if _, err := fmt.Sscanf(update.CallbackQuery.Data, "topup_%d", &amount); err != nil {
+		return p.sendErrorMessage(client, int(userID), "Неверный формат callback данных")
+	}
+	return p.sendTopUpInvoice(client, int(userID), userID, amount)
+}
+
+// sendTopUpInvoice выставляет счет на пополнение баланса
+func (p *MessageProcessor) sendTopUpInvoice(client *TelegramClient, chatID int, userID int64, amount int) error {
+	if amount < services.MinTopUpStars || amount > services.MaxTopUpStars {
+		return p.sendErrorMessage(client, chatID, fmt.Sprintf("Сумма пополнения должна быть от %d до %d ⭐", services.MinTopUpStars, services.MaxTopUpStars))
+	}
+	payload, err := (&services.InvoicePayload{
+		Action: services.PayloadActionTopUp,
+		UserID: userID,
+		Amount: amount,
+	}).Encode()
+	if err != nil {
+		log.Printf("[sendTopUpInvoice] %v", err)
+		return p.sendErrorMessage(client, chatID, "Не удалось сформировать счет на пополнение")
+	}
+	title := "Пополнение баланса"
+	description := fmt.Sprintf("Пополнение внутреннего баланса на %d ⭐. С баланса можно оплатить покупку или продление VPN.", amount)
+	prices := []LabeledPrice{{Label: title, Amount: amount}}
+	return client.SendInvoice(chatID, title, description, payload, "", "XTR", prices, false)
+}
+
+// validateTopUp проверяет счет на пополнение перед списанием
+func validateTopUp(payload *services.InvoicePayload, totalAmount int) string {
+	if payload.Amount < services.MinTopUpStars || payload.Amount > services.MaxTopUpStars || payload.Amount != totalAmount {
+		return "Неверная сумма пополнения. Выставите новый счет через /balance."
+	}
+	return ""
+}
+
+// fulfillTopUp зачисляет оплаченное пополнение на баланс. Если зачислить не удалось,
+// звезды возвращаются пользователю.
+func (p *MessageProcessor) fulfillTopUp(client *TelegramClient, chatID int, trx *services.Transaction) {
+	if _, err := p.ledgerService.TopUp(trx); err != nil {
+		log.Printf("[ERROR] Не удалось зачислить пополнение %d: %v", trx.ID, err)
+		refundErr := client.RefundStarPayment(trx.TelegramUserID, trx.TelegramPaymentChargeID, trx.Amount, "Не удалось пополнить баланс, возврат средств")
+		if refundErr != nil {
+			log.Printf("[ERROR] Ошибка возврата средств: %v", refundErr)
+			p.finishPayment(trx, services.TransactionStatusFailed, fmt.Errorf("%v; возврат: %v", err, refundErr))
+			p.sendMessageHTML(client, chatID, "❌ Не удалось пополнить баланс и вернуть средства. Обратитесь к администратору.")
+			return
+		}
+		if err := p.transactionService.AddRefund(trx, "Автоматический возврат: не удалось пополнить баланс"); err != nil {
+			log.Printf("[ERROR] %v", err)
+		}
+		p.finishPayment(trx, services.TransactionStatusRefunded, err)
+		p.sendMessageHTML(client, chatID, "❌ Не удалось пополнить баланс. Ваши средства возвращены.")
+		return
+	}
+
+	// Пополнение не покупка: награда пригласившему и выход из пробного периода — при оплате с баланса
+	p.finishPayment(trx, services.TransactionStatusFulfilled, nil)
+	balance, err := p.ledgerService.GetBalance(trx.TelegramUserID)
+	if err != nil {
+		log.Printf("[fulfillTopUp] %v", err)
+		p.sendMessageHTML(client, chatID, fmt.Sprintf("✅ Баланс пополнен на %d ⭐", trx.Amount))
+		return
+	}
+	p.sendMessageHTML(client, chatID, fmt.Sprintf("✅ Баланс пополнен на %d ⭐. Текущий баланс: <b>%d ⭐</b>", trx.Amount, balance))
+}
+
+// offerBalancePayment предлагает оплатить заказ с баланса, если на нем хватает средств.
+// callbackData — bal_c_<ID тарифа>_<ID сервера локации>, bal_r_<ID подключения>_<ID тарифа> или bal_g_<ID тарифа>.
+func (p *MessageProcessor) offerBalancePayment(client *TelegramClient, chatID int, userID int64, price int, callbackData string) error {
+	balance, err := p.ledgerService.GetBalance(userID)
+	if err != nil {
+		log.Printf("[offerBalancePayment] %v", err)
+		return nil
+	}
+	if balance < price {
+		return nil
+	}
+	keyboard := &InlineKeyboardMarkup{InlineKeyboard: [][]InlineKeyboardButton{{
+		{Text: fmt.Sprintf("💰 Оплатить с баланса (%d ⭐)", price), CallbackData: callbackData},
+	}}}
+	return p.sendMessageWithKeyboard(client, chatID, fmt.Sprintf("💰 На балансе <b>%d ⭐</b> — можно оплатить без счета.", balance), keyboard)
+}
+
+// handleBalancePayCallback оплачивает создание (bal_c_<ID тарифа>_<ID сервера>), продление
+// (bal_r_<ID подключения>_<ID тарифа>) или подарок (bal_g_<ID тарифа>) VPN с баланса. Заказ проверяется так же, как счет
+// в pre_checkout_query, затем платеж и списание записываются одной транзакцией.
+func (p *MessageProcessor) handleBalancePayCallback(client *TelegramClient, update Update) error {
+	userID := int64(update.CallbackQuery.From.ID)
+	chatID := int(userID)
+	data := update.CallbackQuery.Data
+
+	var order *vpnOrder
+	var reason string
+	if strings.HasPrefix(data, "bal_c_") {
+		var planID, serverID int
+		if _, err := fmt.Sscanf(data, "bal_c_%d_%d", &planID, &serverID); err != nil {
+			return p.sendErrorMessage(client, chatID, "Неверный формат callback данных")
+		}
+		plan, err := p.getActivePlan(planID)
+		if err != nil {
+			return p.sendErrorMessage(client, chatID, err.Error())
+		}
+		if _, err := p.planLocation(plan, serverID); err != nil {
+			return p.sendErrorMessage(client, chatID, err.Error())
+		}
+		order = p.createVPNOrder(userID, plan, serverID)
+		reason = "Оплата VPN с баланса"
+	} else if strings.HasPrefix(data, "bal_g_") {
+		var planID int
+		if _, err := fmt.Sscanf(data, "bal_g_%d", &planID); err != nil {
+			return p.sendErrorMessage(client, chatID, "Неверный формат callback данных")
+		}
+		plan, err := p.getActivePlan(planID)
+		if err != nil {
+			return p.sendErrorMessage(client, chatID, err.Error())
+		}
+		order = p.giftVPNOrder(userID, plan)
+		reason = "Подарочный VPN с баланса"
+	} else {
+		var vpnID, planID int
+		if _, err := fmt.Sscanf(data, "bal_r_%d_%d", &vpnID, &planID); err != nil {
+			return p.sendErrorMessage(client, chatID, "Неверный формат callback данных")
+		}
+		vpn, err := p.vpnConnectionService.GetVPNConnectionByID(vpnID)
+		if err != nil || vpn == nil || vpn.TelegramUserID != userID {
+			return p.sendErrorMessage(client, chatID, "VPN подключение не найдено")
+		}
+		plan, err := p.getActivePlan(planID)
+		if err != nil {
+			return p.sendErrorMessage(client, chatID, err.Error())
+		}
+		order = p.renewVPNOrder(vpn, plan)
+		reason = fmt.Sprintf("Продление VPN #%d с баланса", vpn.ID)
+	}
+
+	payload, err := order.payload.Encode()
+	if err != nil {
+		log.Printf("[handleBalancePayCallback] %v", err)
+		return p.sendErrorMessage(client, chatID, "Не удалось сформировать заказ")
+	}
+	if msg := p.validatePreCheckout(&PreCheckoutQuery{
+		From:           update.CallbackQuery.From,
+		Currency:       "XTR",
+		TotalAmount:    order.price,
+		InvoicePayload: payload,
+	}); msg != "" {
+		return p.sendErrorMessage(client, chatID, msg)
+	}
+
+	trx := &services.Transaction{
+		TelegramPaymentChargeID: balanceChargePrefix + uuid.NewString(),
+		TelegramUserID:          userID,
+		Amount:                  order.price,
+		InvoicePayload:          payload,
+		Reason:                  reason,
+		PlanID:                  order.payload.PlanID,
+		VPNConnectionID:         order.payload.ConnectionID,
+		PromoCodeID:             order.payload.PromoCodeID,
+	}
+	if err := p.ledgerService.PayFromBalance(trx); err != nil {
+		if errors.Is(err, services.ErrInsufficientBalance) {
+			return p.sendErrorMessage(client, chatID, fmt.Sprintf("Недостаточно средств на балансе для оплаты %d ⭐. Пополнить: /balance", order.price))
+		}
+		log.Printf("[handleBalancePayCallback] %v", err)
+		return p.sendErrorMessage(client, chatID, "Не удалось списать средства с баланса, попробуйте позже")
+	}
+	log.Printf("[handleBalancePayCallback] Платеж %d пользователя %d оплачен с баланса (%d ⭐)", trx.ID, userID, trx.Amount)
+
+	p.fulfillPayment(client, chatID, trx, false)
+	return nil
+}
+
+// isTopUp проверяет, что платеж — пополнение баланса
+func isTopUp(trx *services.Transaction) bool {
+	payload, err := services.ParseInvoicePayload(trx.InvoicePayload)
+	return err == nil && payload.Action == services.PayloadActionTopUp
+}
+
+// returnPaymentFunds возвращает деньги за платеж: оплату с баланса — на баланс, пополнение —
+// в Telegram Stars после списания с баланса, остальные платежи — в Telegram Stars.
+// adminTgID — администратор, выполнивший возврат, 0 — автоматический возврат.
+func (p *MessageProcessor) returnPaymentFunds(client *TelegramClient, trx *services.Transaction, reason string, adminTgID int64) error {
+	if trx.PaymentMethod == services.PaymentMethodBalance {
+		return p.ledgerService.RefundToBalance(trx, reason, adminTgID)
+	}
+	if !isTopUp(trx) {
+		return client.RefundStarPayment(trx.TelegramUserID, trx.TelegramPaymentChargeID, trx.Amount, reason)
+	}
+
+	if err := p.ledgerService.WithdrawTopUp(trx, adminTgID); err != nil {
+		return err
+	}
+	if err := client.RefundStarPayment(trx.TelegramUserID, trx.TelegramPaymentChargeID, trx.Amount, reason); err != nil {
+		if cancelErr := p.ledgerService.CancelWithdrawal(trx, adminTgID); cancelErr != nil {
+			log.Printf("[ERROR] Платеж %d: возврат в Telegram не выполнен, списание с баланса не отменено: %v", trx.ID, cancelErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// handleBalanceAdjustCommand изменяет баланс пользователя: /balance_adjust USER_ID; сумма; причина.
+// Положительная сумма зачисляется, отрицательная списывается.
+func (p *MessageProcessor) handleBalanceAdjustCommand(client *TelegramClient, update Update, args []string) error {
+	chatID := update.Message.Chat.ID
+	adminID := int64(update.Message.From.ID)
+	if !p.adminService.IsGlobalAdmin(adminID) {
+		return p.sendMessage(client, chatID, "❌ Только глобальные администраторы могут изменять баланс.")
+	}
+
+	usage := "Использование: <code>/balance_adjust USER_ID; сумма (+/-); причина</code>"
+	fields := splitPlanArgs(args)
+	if len(fields) != 3 || fields[2] == "" {
+		return p.sendMessageHTML(client, chatID, usage)
+	}
+	userID, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return p.sendErrorMessage(client, chatID, "Неверный ID пользователя")
+	}
+	amount, err := strconv.Atoi(fields[1])
+	if err != nil || amount == 0 {
+		return p.sendErrorMessage(client, chatID, "Сумма должна быть ненулевым числом")
+	}
+	user, err := p.userService.GetUserByTelegramID(userID)
+	if err != nil {
+		return p.sendErrorMessage(client, chatID, "Ошибка получения пользователя")
+	}
+	if user == nil {
+		return p.sendErrorMessage(client, chatID, "Пользователь не найден")
+	}
+
+	if err := p.ledgerService.Adjust(userID, amount, fields[2], adminID); err != nil {
+		if errors.Is(err, services.ErrInsufficientBalance) {
+			return p.sendErrorMessage(client, chatID, "Списание сделает баланс пользователя отрицательным")
+		}
+		log.Printf("[handleBalanceAdjustCommand] %v", err)
+		return p.sendErrorMessage(client, chatID, "Не удалось изменить баланс")
+	}
+	balance, err := p.ledgerService.GetBalance(userID)
+	if err != nil {
+		log.Printf("[handleBalanceAdjustCommand] %v", err)
+	}
+	log.Printf("[handleBalanceAdjustCommand] Администратор %d изменил баланс пользователя %d на %+d ⭐: %s", adminID, userID, amount, fields[2])
+
+	if err := p.sendMessage(client, int(userID), fmt.Sprintf("💰 Администратор изменил ваш баланс на %+d ⭐: %s. Текущий баланс: %d ⭐", amount, fields[2], balance)); err != nil {
+		log.Printf("[handleBalanceAdjustCommand] Ошибка уведомления пользователя %d: %v", userID, err)
+	}
+	return p.sendMessageHTML(client, chatID, fmt.Sprintf("✅ Баланс пользователя <code>%d</code> изменен на <b>%+d ⭐</b>, текущий баланс: <b>%d ⭐</b>", userID, amount, balance))
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"mime/multipart"
 	"net/http"
 	"net/url"
 	"os"
@@ -111,6 +112,11 @@ type SuccessfulPayment struct {
 	InvoicePayload          string `json:"invoice_payload"`
 	TelegramPaymentChargeID string `json:"telegram_payment_charge_id"`
 	ProviderPaymentChargeID string `json:"provider_payment_charge_id"`
+	// SubscriptionExpirationDate — окончание оплаченного периода подписки (unix), 0 — не подписка
+	SubscriptionExpirationDate int64 `json:"subscription_expiration_date,omitempty"`
+	// IsRecurring — платеж по подписке, IsFirstRecurring — первый платеж подписки
+	IsRecurring      bool `json:"is_recurring,omitempty"`
+	IsFirstRecurring bool `json:"is_first_recurring,omitempty"`
 }
 
 // NewClient создает новый экземпляр TelegramClient
@@ -140,6 +146,23 @@ func (c *TelegramClient) GetMe() (map[string]interface{}, error) {
 	return result, nil
 }
 
+// GetBotUsername возвращает username бота для ссылок t.me
+func (c *TelegramClient) GetBotUsername() (string, error) {
+	me, err := c.GetMe()
+	if err != nil {
+		return "", err
+	}
+	if ok, _ := me["ok"].(bool); !ok {
+		return "", fmt.Errorf("ошибка getMe: %v", me["description"])
+	}
+	result, _ := me["result"].(map[string]interface{})
+	username, _ := result["username"].(string)
+	if username == "" {
+		return "", fmt.Errorf("getMe не вернул username бота")
+	}
+	return username, nil
+}
+
 // GetUpdates получает обновления от Telegram
 func (c *TelegramClient) GetUpdates(offset, limit int) (*GetUpdatesResponse, error) {
 	params := url.Values{}
@@ -282,6 +305,41 @@ func (c *TelegramClient) SendDocument(chatID int, documentURL, caption string) (
 	return result, nil
 }
 
+// SendDocumentBytes отправляет файл, сформированный в памяти (multipart/form-data)
+func (c *TelegramClient) SendDocumentBytes(chatID int, fileName string, data []byte, caption string) error {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	_ = writer.WriteField("chat_id", strconv.Itoa(chatID))
+	if caption != "" {
+		_ = writer.WriteField("caption", caption)
+	}
+	part, err := writer.CreateFormFile("document", fileName)
+	if err != nil {
+		return fmt.Errorf("ошибка формирования документа: %w", err)
+	}
+	if _, err := part.Write(data); err != nil {
+		return fmt.Errorf("ошибка формирования документа: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("ошибка формирования документа: %w", err)
+	}
+
+	resp, err := c.HTTPClient.Post(c.BaseURL+"/sendDocument", writer.FormDataContentType(), body)
+	if err != nil {
+		return fmt.Errorf("ошибка отправки документа: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var result map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("ошибка декодирования ответа: %w", err)
+	}
+	if ok, exists := result["ok"].(bool); !exists || !ok {
+		return fmt.Errorf("ошибка Telegram API: %v", result["description"])
+	}
+	return nil
+}
+
 // DeleteMessage удаляет сообщение
 func (c *TelegramClient) DeleteMessage(chatID, messageID int) (map[string]interface{}, error) {
 	params := url.Values{}
@@ -524,6 +582,87 @@ func (c *TelegramClient) SendInvoice(chatID int, title, description, payload, pr
 	return nil
 }
 
+// CreateInvoiceLink создает ссылку на оплату счета. subscriptionPeriod — период подписки
+// в секундах (только Telegram Stars, 2592000 — 30 дней), 0 — разовый счет.
+func (c *TelegramClient) CreateInvoiceLink(title, description, payload, currency string, prices []LabeledPrice, subscriptionPeriod int) (string, error) {
+	invoice := map[string]interface{}{
+		"title":       title,
+		"description": description,
+		"payload":     payload,
+		"currency":    currency,
+		"prices":      prices,
+	}
+	if subscriptionPeriod > 0 {
+		invoice["subscription_period"] = subscriptionPeriod
+	}
+	jsonData, err := json.Marshal(invoice)
+	if err != nil {
+		return "", fmt.Errorf("ошибка маршалинга инвойса: %w", err)
+	}
+	resp, err := c.HTTPClient.Post(
+		c.BaseURL+"/createInvoiceLink",
+		"application/json",
+		bytes.NewBuffer(jsonData),
+	)
+	if err != nil {
+		return "", fmt.Errorf("ошибка создания ссылки на оплату: %w", err)
+	}
+	defer resp.Body.Close()
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("ошибка чтения ответа: %w", err)
+	}
+	log.Printf("[TelegramAPI] Ответ на createInvoiceLink: %s", string(bodyBytes))
+	var result struct {
+		OK          bool   `json:"ok"`
+		Result      string `json:"result"`
+		Description string `json:"description"`
+	}
+	if err := json.Unmarshal(bodyBytes, &result); err != nil {
+		return "", fmt.Errorf("ошибка декодирования ответа: %w", err)
+	}
+	if !result.OK {
+		return "", fmt.Errorf("ошибка Telegram API: %s", result.Description)
+	}
+	return result.Result, nil
+}
+
+// EditUserStarSubscription отменяет (isCanceled) или возобновляет продление подписки пользователя.
+// telegramPaymentChargeID — идентификатор платежа подписки.
+func (c *TelegramClient) EditUserStarSubscription(userID int64, telegramPaymentChargeID string, isCanceled bool) error {
+	data := map[string]interface{}{
+		"user_id":                    userID,
+		"telegram_payment_charge_id": telegramPaymentChargeID,
+		"is_canceled":                isCanceled,
+	}
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("ошибка маршалинга запроса: %w", err)
+	}
+	resp, err := c.HTTPClient.Post(
+		c.BaseURL+"/editUserStarSubscription",
+		"application/json",
+		bytes.NewBuffer(jsonData),
+	)
+	if err != nil {
+		return fmt.Errorf("ошибка отправки запроса: %w", err)
+	}
+	defer resp.Body.Close()
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("ошибка чтения ответа: %w", err)
+	}
+	log.Printf("[TelegramAPI] Ответ на editUserStarSubscription: %s", string(bodyBytes))
+	var result map[string]interface{}
+	if err := json.Unmarshal(bodyBytes, &result); err != nil {
+		return fmt.Errorf("ошибка декодирования ответа: %w", err)
+	}
+	if ok, exists := result["ok"].(bool); !exists || !ok {
+		return fmt.Errorf("ошибка Telegram API: %v", result["description"])
+	}
+	return nil
+}
+
 // LabeledPrice описывает цену для инвойса
 // https://core.telegram.org/bots/api#labeledprice
 type LabeledPrice struct {
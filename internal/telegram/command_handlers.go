@@ -1,8 +1,10 @@
 package telegram
 
 import (
+	"TelegramXUI/internal/services"
 	"fmt"
 	"log"
+	"strconv"
 	"strings"
 )
 
@@ -35,14 +37,16 @@ func (p *MessageProcessor) handleTextMessage(client *TelegramClient, update Upda
 
 // handleCommand - обработка команд Telegram
 func (p *MessageProcessor) handleCommand(client *TelegramClient, update Update) error {
-	command := update.Message.Text
+	command, args := parseCommand(update.Message.Text)
 	switch command {
 	case "/start":
-		return p.handleStartCommand(client, update)
+		return p.handleStartCommand(client, update, args)
 	case "/help":
 		return p.handleHelpCommand(client, update)
 	case "/cancel":
 		return p.handleCancelCommand(client, update)
+	case "/vpn":
+		return p.sendVPNList(client, update.Message.Chat.ID, int64(update.Message.From.ID))
 	case "/addhost":
 		return p.handleAddHostCommand(client, update)
 	case "/monitor":
@@ -56,46 +60,152 @@ func (p *MessageProcessor) handleCommand(client *TelegramClient, update Update)
 	case "/check_hosts":
 		return p.handleCheckHostsCommand(client, update)
 	case "/transactions":
-		return p.handleTransactionsCommand(client, update)
+		return p.handleTransactionsCommand(client, update, args)
+	case "/retry_removals":
+		return p.handleRetryRemovalsCommand(client, update)
+	case "/host_mode":
+		return p.handleHostModeCommand(client, update, args)
+	case "/host_weight":
+		return p.handleHostWeightCommand(client, update, args)
+	case "/plans":
+		return p.handlePlansCommand(client, update)
+	case "/plan_add":
+		return p.handlePlanAddCommand(client, update, args)
+	case "/plan_edit":
+		return p.handlePlanEditCommand(client, update, args)
+	case "/plan_toggle":
+		return p.handlePlanToggleCommand(client, update, args)
+	case "/stats":
+		return p.handleStatsCommand(client, update, args)
+	case "/referrals":
+		return p.handleReferralsCommand(client, update)
+	case "/promo":
+		return p.handlePromoCommand(client, update, args)
+	case "/promos":
+		return p.handlePromosCommand(client, update)
+	case "/promo_add":
+		return p.handlePromoAddCommand(client, update, args)
+	case "/promo_toggle":
+		return p.handlePromoToggleCommand(client, update, args)
+	case "/balance":
+		return p.handleBalanceCommand(client, update, args)
+	case "/topup":
+		return p.handleTopUpCommand(client, update, args)
+	case "/balance_adjust":
+		return p.handleBalanceAdjustCommand(client, update, args)
+	case "/gift":
+		return p.handleGiftCommand(client, update)
+	case "/gifts":
+		return p.handleGiftsCommand(client, update)
+	case "/subscriptions":
+		return p.handleSubscriptionsCommand(client, update)
 	// ... другие команды ...
 	default:
 		return p.sendMessage(client, update.Message.Chat.ID, "Неизвестная команда. Используйте /help для справки.")
 	}
 }
 
-func (p *MessageProcessor) handleTransactionsCommand(client *TelegramClient, update Update) error {
+func (p *MessageProcessor) handleRetryRemovalsCommand(client *TelegramClient, update Update) error {
 	userID := int64(update.Message.From.ID)
 	if !p.adminService.IsGlobalAdmin(userID) {
-		return p.sendMessage(client, update.Message.Chat.ID, "❌ Только глобальные администраторы могут просматривать транзакции.")
+		return p.sendMessage(client, update.Message.Chat.ID, "❌ Только глобальные администраторы могут повторять удаление VPN.")
 	}
-	transactions, err := p.transactionService.GetAllTransactions()
+	removed, err := p.vpnRemovalService.RetryPendingRemovals()
 	if err != nil {
-		return p.sendErrorMessage(client, update.Message.Chat.ID, "Ошибка получения транзакций")
-	}
-	if len(transactions) == 0 {
-		return p.sendMessageHTML(client, update.Message.Chat.ID, "ℹ️ <b>Транзакций не найдено</b>")
-	}
-	var sb strings.Builder
-	sb.WriteString("<b>Последние транзакции:</b>\n\n")
-	var keyboard [][]InlineKeyboardButton
-	for _, tx := range transactions {
-		row := []InlineKeyboardButton{}
-		ts := tx.CreatedAt.Format("02.01.06 15:04")
-		sb.WriteString(fmt.Sprintf("ID: <code>%d</code> | User: <code>%d</code> | %s\nСумма: <b>%d</b> | Тип: <b>%s</b> | Статус: <b>%s</b>\nПричина: %s\n---\n",
-			tx.ID, tx.TelegramUserID, ts, tx.Amount, tx.Type, tx.Status, tx.Reason))
-		if tx.Type == "payment" && tx.Status == "success" {
-			row = append(row, InlineKeyboardButton{
-				Text:         "↩️ Возврат средств",
-				CallbackData: fmt.Sprintf("refund_%d", tx.ID),
-			})
+		return p.sendErrorMessage(client, update.Message.Chat.ID, "Ошибка повтора удаления: "+err.Error())
+	}
+	return p.sendMessageHTML(client, update.Message.Chat.ID, fmt.Sprintf("🗑️ Повтор удаления VPN завершен. Удалено на панели: <b>%d</b>", removed))
+}
+
+// handleHostModeCommand показывает или меняет режим выдачи VPN на хостах:
+// /host_mode — список хостов, /host_mode <id> <per_inbound|shared> — смена режима
+func (p *MessageProcessor) handleHostModeCommand(client *TelegramClient, update Update, args []string) error {
+	chatID := update.Message.Chat.ID
+	userID := int64(update.Message.From.ID)
+	if !p.adminService.IsGlobalAdmin(userID) {
+		return p.sendMessage(client, chatID, "❌ Только глобальные администраторы могут менять режим выдачи VPN.")
+	}
+
+	if len(args) == 0 {
+		servers, err := p.xuiServerService.GetAllServers(1000, 0)
+		if err != nil {
+			return p.sendErrorMessage(client, chatID, "Ошибка получения списка хостов")
 		}
-		if len(row) > 0 {
-			keyboard = append(keyboard, row)
+		var sb strings.Builder
+		sb.WriteString("🧩 <b>Режимы выдачи VPN</b>\n\n")
+		for _, s := range servers {
+			sb.WriteString(fmt.Sprintf("ID <code>%d</code> — %s: <b>%s</b>", s.ID, s.ServerName, s.ProvisioningMode))
+			if s.IsShared() {
+				pools, err := p.inboundPoolService.GetServerPoolInbounds(s.ID)
+				if err == nil {
+					clients := 0
+					for _, pool := range pools {
+						clients += pool.ClientCount
+					}
+					sb.WriteString(fmt.Sprintf(" (inbound: %d, клиентов: %d)", len(pools), clients))
+				}
+			}
+			sb.WriteString("\n")
 		}
+		sb.WriteString("\nИзменить: <code>/host_mode ID per_inbound|shared</code>")
+		return p.sendMessageHTML(client, chatID, sb.String())
 	}
-	inlineKeyboard := &InlineKeyboardMarkup{InlineKeyboard: keyboard}
-	_, err = client.SendMessageWithKeyboard(update.Message.Chat.ID, sb.String(), inlineKeyboard)
-	return err
+
+	if len(args) != 2 {
+		return p.sendMessageHTML(client, chatID, "Использование: <code>/host_mode ID per_inbound|shared</code>")
+	}
+	serverID, err := strconv.Atoi(args[0])
+	if err != nil {
+		return p.sendErrorMessage(client, chatID, "Неверный ID хоста")
+	}
+	if err := p.xuiServerService.SetProvisioningMode(serverID, args[1]); err != nil {
+		return p.sendErrorMessage(client, chatID, err.Error())
+	}
+	return p.sendMessageHTML(client, chatID, fmt.Sprintf("✅ Режим выдачи хоста <code>%d</code>: <b>%s</b>", serverID, args[1]))
+}
+
+// handleHostWeightCommand задает вес хоста для стратегии weighted_round_robin:
+// /host_weight — список хостов, /host_weight <id> <вес> — смена веса
+func (p *MessageProcessor) handleHostWeightCommand(client *TelegramClient, update Update, args []string) error {
+	chatID := update.Message.Chat.ID
+	userID := int64(update.Message.From.ID)
+	if !p.adminService.IsGlobalAdmin(userID) {
+		return p.sendMessage(client, chatID, "❌ Только глобальные администраторы могут менять вес хостов.")
+	}
+
+	if len(args) == 0 {
+		servers, err := p.xuiServerService.GetAllServers(1000, 0)
+		if err != nil {
+			return p.sendErrorMessage(client, chatID, "Ошибка получения списка хостов")
+		}
+		var sb strings.Builder
+		sb.WriteString(fmt.Sprintf("⚖️ <b>Выбор хоста</b> (по умолчанию: <b>%s</b>)\n\n", p.config.VPN.HostSelectionStrategy))
+		for _, s := range servers {
+			latency := "—"
+			if s.LastLatencyMs > 0 {
+				latency = fmt.Sprintf("%d мс", s.LastLatencyMs)
+			}
+			sb.WriteString(fmt.Sprintf("ID <code>%d</code> — %s (%s): вес <b>%d</b>, задержка %s\n", s.ID, s.ServerName, s.ServerLocation, s.Weight, latency))
+		}
+		sb.WriteString("\nИзменить: <code>/host_weight ID вес</code>")
+		return p.sendMessageHTML(client, chatID, sb.String())
+	}
+
+	if len(args) != 2 {
+		return p.sendMessageHTML(client, chatID, "Использование: <code>/host_weight ID вес</code>")
+	}
+	serverID, err := strconv.Atoi(args[0])
+	if err != nil {
+		return p.sendErrorMessage(client, chatID, "Неверный ID хоста")
+	}
+	weight, err := strconv.Atoi(args[1])
+	if err != nil {
+		return p.sendErrorMessage(client, chatID, "Неверный вес")
+	}
+	if err := p.xuiServerService.SetServerWeight(serverID, weight); err != nil {
+		return p.sendErrorMessage(client, chatID, err.Error())
+	}
+	return p.sendMessageHTML(client, chatID, fmt.Sprintf("✅ Вес хоста <code>%d</code>: <b>%d</b>", serverID, weight))
 }
 
 // handleUserStateMessage - обработка сообщений по состоянию пользователя
@@ -113,19 +223,34 @@ func (p *MessageProcessor) handleUserStateMessage(client *TelegramClient, update
 }
 
 // handleStartCommand, handleHelpCommand, handleCancelCommand, handleAddHostCommand, handleMonitorCommand — заготовки для дальнейшей реализации
-func (p *MessageProcessor) handleStartCommand(client *TelegramClient, update Update) error {
+// /start <параметр> — переход по ссылке t.me/<бот>?start=<параметр>, например ref_<telegram_id> или gift_<код>
+func (p *MessageProcessor) handleStartCommand(client *TelegramClient, update Update, args []string) error {
 	user := update.Message.From
 	userID := int64(user.ID)
+	startParam := ""
+	if len(args) > 0 {
+		startParam = args[0]
+	}
+	referred := p.registerStartUser(user, startParam)
+	if code, ok := services.ParseGiftStartParam(startParam); ok {
+		return p.redeemGift(client, update.Message.Chat.ID, user, code)
+	}
 	message := "🤖 <b>Добро пожаловать в TelegramXUI!</b>\n\n" +
 		"👤 <b>Пользователь:</b> " + user.Username + "\n" +
 		"✅ <b>Регистрация:</b> Автоматически завершена\n" +
-		"🎯 <b>Доступ:</b> Полный доступ к функциям\n\n" +
-		"Используйте /help для получения справки."
+		"🎯 <b>Доступ:</b> Полный доступ к функциям\n\n"
+	if referred {
+		message += "👥 Вы пришли по приглашению друга.\n\n"
+	}
+	message += "Используйте /help для получения справки."
 	var keyboard *InlineKeyboardMarkup
 	if p.adminService.IsGlobalAdmin(userID) {
 		keyboard = makeAdminButtons()
 	} else {
 		keyboard = makeCreateVPNButton()
+		if trial := p.trialButton(userID); trial != nil {
+			keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, trial)
+		}
 	}
 	return p.sendMessageWithKeyboard(client, update.Message.Chat.ID, message, keyboard)
 }
@@ -135,9 +260,16 @@ func (p *MessageProcessor) handleHelpCommand(client *TelegramClient, update Upda
 		"/start - Начать работу с ботом\n" +
 		"/help - Показать эту справку\n" +
 		"/cancel - Отменить текущую операцию\n" +
-		"/vpn - Управление VPN подключениями\n"
+		"/vpn - Управление VPN подключениями\n" +
+		"/promo - Ввести промокод (/promo КОД)\n" +
+		"/referrals - Пригласить друзей\n" +
+		"/balance - Баланс и история операций\n" +
+		"/topup - Пополнить баланс (/topup СУММА)\n" +
+		"/gift - Подарить VPN\n" +
+		"/gifts - Купленные подарки\n" +
+		"/subscriptions - Подписки на продление VPN\n"
 	if p.adminService.IsGlobalAdmin(userID) {
-		message += "/addhost - Добавить XUI хост\n/monitor - Управление мониторингом хостов\n/monitor_start - Запустить мониторинг\n/monitor_stop - Остановить мониторинг\n/monitor_status - Статус мониторинга\n/check_hosts - Проверить все хосты сейчас\n/transactions - Просмотр транзакций\n"
+		message += "/addhost - Добавить XUI хост\n/monitor - Управление мониторингом хостов\n/monitor_start - Запустить мониторинг\n/monitor_stop - Остановить мониторинг\n/monitor_status - Статус мониторинга\n/check_hosts - Проверить все хосты сейчас\n/transactions - Просмотр транзакций (/transactions [user_id] [страница])\n/retry_removals - Повторить незавершенные удаления VPN\n/host_mode - Режим выдачи VPN на хостах\n/host_weight - Веса и задержки хостов\n/plans - Тарифы\n/plan_add - Добавить тариф\n/plan_edit - Изменить тариф\n/plan_toggle - Включить/отключить тариф\n/stats - Финансовая статистика (/stats [day|week|month] [N] [csv])\n/promos - Промокоды\n/promo_add - Добавить промокод\n/promo_toggle - Включить/отключить промокод\n/balance_adjust - Изменить баланс пользователя\n"
 	}
 	message += "\n💡 <b>Совет:</b> Нажмите кнопку 'Создать VPN' для быстрого доступа к VPN"
 	var keyboard *InlineKeyboardMarkup
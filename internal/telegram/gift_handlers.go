@@ -0,0 +1,225 @@
+package telegram
+
+import (
+	"TelegramXUI/internal/services"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// maxGiftsShown сколько последних подарков показывает /gifts
+const maxGiftsShown = 10
+
+// handleGiftCommand показывает тарифы, которые можно подарить: /gift
+func (p *MessageProcessor) handleGiftCommand(client *TelegramClient, update Update) error {
+	chatID := update.Message.Chat.ID
+	plans, err := p.planService.GetActivePlans()
+	if err != nil {
+		log.Printf("[handleGiftCommand] %v", err)
+		return p.sendErrorMessage(client, chatID, "Ошибка получения списка тарифов")
+	}
+	if len(plans) == 0 {
+		return p.sendMessageHTML(client, chatID, "❌ Сейчас нет доступных тарифов. Обратитесь к администратору.")
+	}
+
+	promo := p.activatedPromo(int64(update.Message.From.ID))
+	var sb strings.Builder
+	sb.WriteString("🎁 <b>Подарить VPN</b>\n\nПосле оплаты вы получите ссылку, по которой получатель активирует VPN в этом боте.\n\n")
+	var keyboard [][]InlineKeyboardButton
+	for _, plan := range plans {
+		sb.WriteString(formatPlanOffer(plan, promo))
+		keyboard = append(keyboard, []InlineKeyboardButton{{
+			Text:         "🎁 " + planButtonText(plan, promo),
+			CallbackData: fmt.Sprintf("gift_plan_%d", plan.ID),
+		}})
+	}
+	sb.WriteString("\n" + promoLine(promo))
+	return p.sendMessageWithKeyboard(client, chatID, sb.String(), &InlineKeyboardMarkup{InlineKeyboard: keyboard})
+}
+
+// handleGiftPlanCallback выставляет счет на подарок по тарифу (gift_plan_<ID тарифа>)
+func (p *MessageProcessor) handleGiftPlanCallback(client *TelegramClient, update Update) error {
+	userID := int64(update.CallbackQuery.From.ID)
+	var planID int
+	if _, err := fmt.Sscanf(update.CallbackQuery.Data, "gift_plan_%d", &planID); err != nil {
+		return p.sendErrorMessage(client, int(userID), "Неверный формат callback данных")
+	}
+	plan, err := p.getActivePlan(planID)
+	if err != nil {
+		return p.sendErrorMessage(client, int(userID), err.Error())
+	}
+	return p.sendGiftInvoice(client, int(userID), userID, plan)
+}
+
+// giftVPNOrder заказ подарочного VPN по тарифу
+func (p *MessageProcessor) giftVPNOrder(userID int64, plan *services.Plan) *vpnOrder {
+	return p.newVPNOrder(&services.InvoicePayload{
+		Action:      services.PayloadActionGiftVPN,
+		UserID:      userID,
+		PlanID:      plan.ID,
+		PlanVersion: plan.Version,
+	}, plan)
+}
+
+// sendGiftInvoice выставляет счет на подарочный VPN
+func (p *MessageProcessor) sendGiftInvoice(client *TelegramClient, chatID int, userID int64, plan *services.Plan) error {
+	order := p.giftVPNOrder(userID, plan)
+	description := "Подарочный VPN. " + services.FormatPlan(plan) + promoInvoiceNote(order.promo)
+	payload, err := order.payload.Encode()
+	if err != nil {
+		log.Printf("[sendGiftInvoice] %v", err)
+		return p.sendErrorMessage(client, chatID, "Не удалось сформировать счет на подарок")
+	}
+	prices := []LabeledPrice{{Label: plan.Name, Amount: order.price}}
+	if err := client.SendInvoice(chatID, plan.Name, description, payload, "", "XTR", prices, false); err != nil {
+		return err
+	}
+	return p.offerBalancePayment(client, chatID, userID, order.price, fmt.Sprintf("bal_g_%d", plan.ID))
+}
+
+// createGiftAndSendCode создает код оплаченного подарка и отправляет покупателю ссылку.
+// Ошибка возвращается, только если код не создан, — платеж в этом случае возвращается.
+func (p *MessageProcessor) createGiftAndSendCode(client *TelegramClient, chatID int, trx *services.Transaction, plan *services.Plan) error {
+	if plan == nil {
+		return fmt.Errorf("тариф подарка %d не найден", trx.PlanID)
+	}
+	gift, err := p.giftService.CreateGift(trx, plan)
+	if err != nil {
+		return err
+	}
+	log.Printf("[createGiftAndSendCode] Платеж %d: создан подарок %d", trx.ID, gift.ID)
+
+	message := fmt.Sprintf("🎁 <b>Подарок оплачен!</b>\n\n💳 <b>Тариф:</b> %s\n", plan.Name)
+	message += fmt.Sprintf("⏳ <b>Срок:</b> %s\n\n", formatGiftDuration(gift.DurationDays))
+	message += p.giftLink(client, gift)
+	message += "\n\nПерешлите ссылку получателю: VPN создастся на его аккаунт, когда он откроет ее в Telegram. Код действует один раз. Ваши подарки: /gifts"
+	_ = p.sendMessageHTML(client, chatID, message)
+	return nil
+}
+
+// giftLink форматирует ссылку на активацию подарка, без имени бота — только код
+func (p *MessageProcessor) giftLink(client *TelegramClient, gift *services.Gift) string {
+	botUsername, err := client.GetBotUsername()
+	if err != nil {
+		log.Printf("[giftLink] %v", err)
+		return fmt.Sprintf("🔑 <b>Код:</b> <code>/start %s</code>", services.GiftStartParam(gift.Code))
+	}
+	return fmt.Sprintf("🔗 <b>Ссылка:</b>\n<code>https://t.me/%s?start=%s</code>", botUsername, services.GiftStartParam(gift.Code))
+}
+
+// formatGiftDuration форматирует срок подарочного VPN
+func formatGiftDuration(days int) string {
+	if days == 0 {
+		return "бессрочно"
+	}
+	return fmt.Sprintf("%d дн.", days)
+}
+
+// redeemGift активирует подарок по ссылке /start gift_<код>: создает VPN на получателя.
+// Платеж остается за покупателем и связывается с созданным подключением.
+func (p *MessageProcessor) redeemGift(client *TelegramClient, chatID int, user User, code string) error {
+	userID := int64(user.ID)
+	allowed, reason, err := p.userStateService.CanUserPerformAction(userID)
+	if err != nil {
+		log.Printf("[redeemGift] Ошибка проверки состояния пользователя %d: %v", userID, err)
+		return p.sendErrorMessage(client, chatID, "Не удалось проверить ваш аккаунт, попробуйте позже")
+	}
+	if !allowed {
+		return p.sendErrorMessage(client, chatID, "Подарок недоступен: "+reason)
+	}
+
+	gift, chargeID, err := p.giftService.Claim(code, userID)
+	if err != nil {
+		log.Printf("[redeemGift] %v", err)
+		return p.sendErrorMessage(client, chatID, "Не удалось активировать подарок, попробуйте позже")
+	}
+	if gift == nil {
+		return p.sendErrorMessage(client, chatID, p.giftUnavailableReason(code, userID))
+	}
+	log.Printf("[redeemGift] Пользователь %d активирует подарок %d от пользователя %d", userID, gift.ID, gift.BuyerTgID)
+
+	plan, err := p.planService.GetPlanByID(gift.PlanID)
+	if err != nil {
+		log.Printf("[redeemGift] %v", err)
+	}
+	_ = p.sendMessageHTML(client, chatID, "🎁 Вам подарили VPN! Создаём подключение...")
+	connectionID, err := p.createVPNAndSendInfo(client, chatID, userID, "", gift.ApplyTo(plan), chargeID)
+	if err != nil {
+		log.Printf("[ERROR] Не удалось создать VPN по подарку %d: %v", gift.ID, err)
+		if err := p.giftService.Unclaim(gift.ID); err != nil {
+			log.Printf("[ERROR] %v", err)
+		}
+		return p.sendErrorMessage(client, chatID, "Не удалось создать VPN по подарку. Подарок сохранен — попробуйте открыть ссылку позже.")
+	}
+
+	if err := p.giftService.LinkConnection(gift.ID, connectionID); err != nil {
+		log.Printf("[ERROR] %v", err)
+	}
+	if err := p.transactionService.LinkConnection(gift.TransactionID, connectionID); err != nil {
+		log.Printf("[ERROR] Платеж %d: %v", gift.TransactionID, err)
+	}
+	name := user.FirstName
+	if user.Username != "" {
+		name = "@" + user.Username
+	}
+	if err := p.sendMessage(client, int(gift.BuyerTgID), fmt.Sprintf("🎁 Ваш подарок активирован пользователем %s (VPN #%d).", name, connectionID)); err != nil {
+		log.Printf("[redeemGift] Ошибка уведомления покупателя %d: %v", gift.BuyerTgID, err)
+	}
+	return nil
+}
+
+// giftUnavailableReason объясняет получателю, почему код нельзя активировать
+func (p *MessageProcessor) giftUnavailableReason(code string, userID int64) string {
+	gift, err := p.giftService.GetGiftByCode(code)
+	switch {
+	case err != nil:
+		log.Printf("[giftUnavailableReason] %v", err)
+		return "Не удалось активировать подарок, попробуйте позже"
+	case gift == nil:
+		return "Подарок не найден. Проверьте ссылку"
+	case gift.RedeemedByTgID == userID:
+		return "Вы уже активировали этот подарок. Ваши подключения: /vpn"
+	case gift.RedeemedByTgID != 0:
+		return "Подарок уже активирован"
+	case gift.CancelledAt != nil:
+		return "Подарок аннулирован: платеж за него возвращен"
+	case gift.BuyerTgID == userID:
+		return "Нельзя активировать собственный подарок — перешлите ссылку получателю"
+	default:
+		return "Подарок сейчас недоступен"
+	}
+}
+
+// handleGiftsCommand показывает подарки, купленные пользователем: /gifts
+func (p *MessageProcessor) handleGiftsCommand(client *TelegramClient, update Update) error {
+	chatID := update.Message.Chat.ID
+	gifts, err := p.giftService.GetBuyerGifts(int64(update.Message.From.ID), maxGiftsShown)
+	if err != nil {
+		log.Printf("[handleGiftsCommand] %v", err)
+		return p.sendErrorMessage(client, chatID, "Ошибка получения подарков")
+	}
+	if len(gifts) == 0 {
+		return p.sendMessageHTML(client, chatID, "🎁 Вы еще не дарили VPN. Подарить: /gift")
+	}
+
+	var sb strings.Builder
+	sb.WriteString("🎁 <b>Ваши подарки</b>\n\n")
+	for _, gift := range gifts {
+		sb.WriteString(fmt.Sprintf("<b>%s</b> — %s: ", gift.CreatedAt.Format("02.01.2006"), formatGiftDuration(gift.DurationDays)))
+		switch {
+		case gift.RedeemedByTgID != 0:
+			sb.WriteString("✅ активирован")
+			if gift.RedeemedAt != nil {
+				sb.WriteString(" " + gift.RedeemedAt.Format("02.01.2006"))
+			}
+			sb.WriteString("\n")
+		case gift.CancelledAt != nil:
+			sb.WriteString("↩️ аннулирован\n")
+		default:
+			sb.WriteString("⏳ ожидает получателя\n" + p.giftLink(client, gift) + "\n")
+		}
+		sb.WriteString("\n")
+	}
+	sb.WriteString("Подарить еще: /gift")
+	return p.sendMessageHTML(client, chatID, sb.String())
+}
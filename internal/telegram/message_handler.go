@@ -7,7 +7,7 @@ import (
 )
 
 // MessageProcessor обрабатывает сообщения Telegram бота
-// (реализация вынесена в отдельные файлы: command_handlers.go, payment_handlers.go, vpn_handlers.go, admin_handlers.go, utils.go)
+// (реализация вынесена в отдельные файлы: command_handlers.go, payment_handlers.go, vpn_handlers.go, plan_handlers.go, transaction_handlers.go, stats_handlers.go, promo_handlers.go, referral_handlers.go, balance_handlers.go, trial_handlers.go, gift_handlers.go, subscription_handlers.go, admin_handlers.go, utils.go)
 type MessageProcessor struct {
 	userStateService       contracts.UserStateService
 	extensibleStateService contracts.ExtensibleStateService
@@ -19,6 +19,19 @@ type MessageProcessor struct {
 	vpnConnectionService   *services.VPNConnectionService
 	config                 *config.Config
 	transactionService     *services.TransactionService
+	vpnRemovalService      *services.VPNRemovalService
+	realityService         *services.RealityService
+	inboundPoolService     *services.InboundPoolService
+	portAllocator          *services.PortAllocatorService
+	hostSelectionService   *services.HostSelectionService
+	planService            *services.PlanService
+	reportService          *services.ReportService
+	promoService           *services.PromoService
+	referralService        *services.ReferralService
+	ledgerService          *services.LedgerService
+	trialService           *services.TrialService
+	giftService            *services.GiftService
+	subscriptionService    *services.SubscriptionService
 }
 
 func NewMessageProcessor(
@@ -32,6 +45,19 @@ func NewMessageProcessor(
 	vpnConnectionService *services.VPNConnectionService,
 	config *config.Config,
 	transactionService *services.TransactionService,
+	vpnRemovalService *services.VPNRemovalService,
+	realityService *services.RealityService,
+	inboundPoolService *services.InboundPoolService,
+	portAllocator *services.PortAllocatorService,
+	hostSelectionService *services.HostSelectionService,
+	planService *services.PlanService,
+	reportService *services.ReportService,
+	promoService *services.PromoService,
+	referralService *services.ReferralService,
+	ledgerService *services.LedgerService,
+	trialService *services.TrialService,
+	giftService *services.GiftService,
+	subscriptionService *services.SubscriptionService,
 ) *MessageProcessor {
 	return &MessageProcessor{
 		userStateService:       userStateService,
@@ -44,6 +70,19 @@ func NewMessageProcessor(
 		vpnConnectionService:   vpnConnectionService,
 		config:                 config,
 		transactionService:     transactionService,
+		vpnRemovalService:      vpnRemovalService,
+		realityService:         realityService,
+		inboundPoolService:     inboundPoolService,
+		portAllocator:          portAllocator,
+		hostSelectionService:   hostSelectionService,
+		planService:            planService,
+		reportService:          reportService,
+		promoService:           promoService,
+		referralService:        referralService,
+		ledgerService:          ledgerService,
+		trialService:           trialService,
+		giftService:            giftService,
+		subscriptionService:    subscriptionService,
 	}
 }
 
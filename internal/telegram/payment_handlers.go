@@ -3,32 +3,204 @@ package telegram
 import (
 	"TelegramXUI/internal/services"
 	"TelegramXUI/internal/xui_client"
+	"errors"
 	"fmt"
 	"log"
-	"time"
 )
 
-// handlePreCheckout - обработка pre_checkout_query
+// handlePreCheckout - обработка pre_checkout_query. Оплата подтверждается, только если
+// оплаченное действие можно выполнить: иначе звезды не списываются, а пользователь видит причину.
 func (p *MessageProcessor) handlePreCheckout(client *TelegramClient, update Update) error {
+	query := update.PreCheckoutQuery
 	log.Printf("[handlePreCheckout] pre_checkout_query: id=%s, user_id=%d, currency=%s, total_amount=%d, payload=%s",
-		update.PreCheckoutQuery.ID,
-		update.PreCheckoutQuery.From.ID,
-		update.PreCheckoutQuery.Currency,
-		update.PreCheckoutQuery.TotalAmount,
-		update.PreCheckoutQuery.InvoicePayload)
+		query.ID,
+		query.From.ID,
+		query.Currency,
+		query.TotalAmount,
+		query.InvoicePayload)
+
+	if reason := p.validatePreCheckout(query); reason != "" {
+		log.Printf("[handlePreCheckout] Оплата отклонена для user_id=%d: %s", query.From.ID, reason)
+		if err := client.AnswerPreCheckoutQuery(query.ID, false, reason); err != nil {
+			log.Printf("[MessageProcessor] Ошибка отклонения pre_checkout_query: %v", err)
+		}
+		return nil
+	}
+
 	// Подтверждаем pre_checkout_query и уведомляем пользователя
-	err := client.AnswerPreCheckoutQuery(update.PreCheckoutQuery.ID, true, "")
+	err := client.AnswerPreCheckoutQuery(query.ID, true, "")
 	if err != nil {
 		log.Printf("[MessageProcessor] Ошибка подтверждения pre_checkout_query: %v", err)
 	} else {
-		log.Printf("[MessageProcessor] pre_checkout_query подтверждён для user_id=%d", update.PreCheckoutQuery.From.ID)
+		log.Printf("[MessageProcessor] pre_checkout_query подтверждён для user_id=%d", query.From.ID)
 	}
-	chatID := int(update.PreCheckoutQuery.From.ID)
+	chatID := int(query.From.ID)
 	_ = p.sendMessageHTML(client, chatID, "💸 Запрос на оплату получен, ожидайте подтверждения!")
 	return nil
 }
 
-// handleSuccessfulPayment - обработка успешного платежа
+// validatePreCheckout проверяет счет перед списанием. Возвращает причину отказа
+// для пользователя или пустую строку, если оплату можно принять.
+func (p *MessageProcessor) validatePreCheckout(query *PreCheckoutQuery) string {
+	userID := int64(query.From.ID)
+
+	payload, err := services.ParseInvoicePayload(query.InvoicePayload)
+	if err != nil {
+		log.Printf("[validatePreCheckout] %v", err)
+		return "Счет устарел. Выставите новый счет через /vpn."
+	}
+	if payload.UserID != userID {
+		return "Счет выставлен другому пользователю."
+	}
+	if query.Currency != "XTR" {
+		return "Оплата принимается только в Telegram Stars."
+	}
+
+	// Пользователь мог открыть счет, не отправляя /start
+	if _, err := p.userService.EnsureUserExists(query.From); err != nil {
+		log.Printf("[validatePreCheckout] %v", err)
+	}
+	allowed, reason, err := p.userStateService.CanUserPerformAction(userID)
+	if err != nil {
+		log.Printf("[validatePreCheckout] Ошибка проверки состояния пользователя %d: %v", userID, err)
+		return "Не удалось проверить ваш аккаунт, попробуйте позже."
+	}
+	if !allowed {
+		return "Покупка недоступна: " + reason
+	}
+
+	if payload.Action == services.PayloadActionTopUp {
+		return validateTopUp(payload, query.TotalAmount)
+	}
+	if payload.PlanID == 0 {
+		return "Счет устарел. Выставите новый счет через /vpn."
+	}
+	plan, err := p.planService.GetPlanByID(payload.PlanID)
+	if err != nil {
+		log.Printf("[validatePreCheckout] %v", err)
+		return "Не удалось проверить тариф, попробуйте позже."
+	}
+	if plan == nil || !plan.IsActive {
+		return "Тариф больше недоступен. Выберите другой тариф в /vpn."
+	}
+	price := plan.PriceStars
+	if payload.PromoCodeID != 0 {
+		promo, err := p.promoService.GetPromoCodeByID(payload.PromoCodeID)
+		if err != nil {
+			log.Printf("[validatePreCheckout] %v", err)
+			return "Не удалось проверить промокод, попробуйте позже."
+		}
+		if promo == nil || !promo.AppliesTo(plan) {
+			return "Промокод не применяется к тарифу. Выставите новый счет через /vpn."
+		}
+		if err := p.promoService.CheckRedeemable(promo, userID); err != nil {
+			return "Промокод не применен: " + err.Error() + ". Выставите новый счет через /vpn."
+		}
+		price = promo.Apply(plan.PriceStars)
+	}
+	if price != query.TotalAmount {
+		return fmt.Sprintf("Цена тарифа изменилась (сейчас %d ⭐). Выставите новый счет через /vpn.", price)
+	}
+
+	switch payload.Action {
+	case services.PayloadActionGiftVPN:
+		// Свободные хосты проверяются при активации подарка получателем
+	case services.PayloadActionCreateVPN:
+		location, err := p.payloadLocation(payload)
+		if err != nil {
+			log.Printf("[validatePreCheckout] %v", err)
+			return "Не удалось проверить выбранную локацию. Выставите новый счет через /vpn."
+		}
+		if location != "" && !plan.AllowsLocation(location) {
+			return "Выбранная локация недоступна в тарифе."
+		}
+		hasCapacity, err := p.hasHostCapacity(plan)
+		if err != nil {
+			log.Printf("[validatePreCheckout] %v", err)
+			return "Не удалось проверить доступность серверов, попробуйте позже."
+		}
+		if !hasCapacity {
+			return "Сейчас нет свободных серверов для нового VPN. Попробуйте позже."
+		}
+	case services.PayloadActionRenewVPN, services.PayloadActionSubscribeVPN:
+		// Первый платеж подписки проходит ту же проверку; ссылка на подписку выдается,
+		// только если подписки на подключение еще нет
+		if payload.Action == services.PayloadActionSubscribeVPN && plan.DurationDays != services.SubscriptionPeriodDays {
+			return "Тариф больше не продается по подписке. Выберите тариф в /vpn."
+		}
+		vpn, err := p.vpnConnectionService.GetVPNConnectionByID(payload.ConnectionID)
+		if err != nil {
+			log.Printf("[validatePreCheckout] %v", err)
+			return "Не удалось проверить подключение, попробуйте позже."
+		}
+		if vpn == nil || vpn.TelegramUserID != userID || !(vpn.IsActive || vpn.IsExpired()) || vpn.RemovalPending {
+			return "Подключение больше недоступно для продления."
+		}
+		location, err := p.connectionLocation(vpn)
+		if err != nil {
+			log.Printf("[validatePreCheckout] %v", err)
+			return "Не удалось проверить подключение, попробуйте позже."
+		}
+		if !plan.AllowsLocation(location) {
+			return "Тариф недоступен в локации подключения. Выберите другой тариф в /vpn."
+		}
+	default:
+		return "Неизвестный тип счета. Выставите новый счет через /vpn."
+	}
+	return ""
+}
+
+// payloadLocation возвращает локацию, выбранную в счете: по серверу локации, а в счетах
+// старого формата — по названию из payload. Пустая строка — любая локация.
+func (p *MessageProcessor) payloadLocation(payload *services.InvoicePayload) (string, error) {
+	if payload.ServerID == 0 {
+		return payload.Location, nil
+	}
+	server, err := p.xuiServerService.GetServerByID(payload.ServerID)
+	if err != nil {
+		return "", err
+	}
+	if server == nil {
+		return "", fmt.Errorf("сервер %d выбранной локации не найден", payload.ServerID)
+	}
+	return server.ServerLocation, nil
+}
+
+// hasHostCapacity проверяет, что хотя бы на одном активном хосте тарифа можно создать VPN:
+// в общем inbound есть место или для нового inbound остался свободный порт
+func (p *MessageProcessor) hasHostCapacity(plan *services.Plan) (bool, error) {
+	servers, err := p.xuiServerService.GetActiveServers()
+	if err != nil {
+		return false, err
+	}
+	vpnConfig := p.config.VPN
+	for _, server := range servers {
+		if !plan.AllowsLocation(server.ServerLocation) {
+			continue
+		}
+		if server.IsShared() {
+			pool, err := p.inboundPoolService.SelectLeastLoaded(server.ID)
+			if err != nil {
+				return false, err
+			}
+			if pool != nil {
+				return true, nil
+			}
+		}
+		free, err := p.portAllocator.HasFreePort(server.ID, vpnConfig.PortRangeStart, vpnConfig.PortRangeEnd)
+		if err != nil {
+			return false, err
+		}
+		if free {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// handleSuccessfulPayment - обработка успешного платежа. Платеж сначала записывается
+// по telegram_payment_charge_id, поэтому повторная доставка того же successful_payment
+// не выполняет оплаченное действие второй раз.
 func (p *MessageProcessor) handleSuccessfulPayment(client *TelegramClient, update Update) error {
 	log.Printf("[handleSuccessfulPayment] Получен SuccessfulPayment: %+v", update)
 	if update.Message.From.ID == 0 {
@@ -39,76 +211,335 @@ func (p *MessageProcessor) handleSuccessfulPayment(client *TelegramClient, updat
 	chatID := update.Message.Chat.ID
 	log.Printf("[handleSuccessfulPayment] userID=%d, chatID=%d", userID, chatID)
 
-	// Сообщаем пользователю, что платёж принят и идёт создание VPN
-	errMsg := p.sendMessageHTML(client, chatID, "⭐️ Платёж успешно принят! Создаём VPN...")
-	if errMsg != nil {
-		log.Printf("[MessageProcessor] Ошибка отправки сообщения о принятии платежа: %v", errMsg)
-	}
-
-	// Пробуем создать VPN
-	errVPN := p.createVPNAndSendInfo(client, chatID, userID)
 	sp := update.Message.SuccessfulPayment
-	if errVPN != nil {
-		// Если не удалось — делаем возврат
-		log.Printf("[ERROR] Ошибка создания VPN: %v", errVPN)
-		refundErr := client.RefundStarPayment(userID, sp.TelegramPaymentChargeID, sp.TotalAmount, "Не удалось создать VPN, возврат средств")
-		if refundErr != nil {
-			log.Printf("[ERROR] Ошибка возврата средств: %v", refundErr)
-			p.sendMessageHTML(client, chatID, "❌ Не удалось создать VPN и вернуть средства. Обратитесь к администратору.")
-		} else {
-			p.sendMessageHTML(client, chatID, "❌ Не удалось создать VPN. Ваши средства возвращены.")
-		}
-		return nil
-	}
-
-	// Если VPN создан — записываем транзакцию
 	trx := &services.Transaction{
 		TelegramPaymentChargeID: sp.TelegramPaymentChargeID,
 		TelegramUserID:          userID,
 		Amount:                  sp.TotalAmount,
 		InvoicePayload:          sp.InvoicePayload,
-		Status:                  "success",
-		Type:                    "payment",
 		Reason:                  "Оплата через Telegram Stars",
 	}
-	errTrx := p.transactionService.AddTransaction(trx)
-	if errTrx != nil {
-		log.Printf("[ERROR] Ошибка записи транзакции: %v", errTrx)
+	if sp.IsRecurring && !sp.IsFirstRecurring {
+		trx.Reason = "Продление подписки Telegram Stars"
+	}
+	if payload, err := services.ParseInvoicePayload(sp.InvoicePayload); err == nil {
+		trx.PlanID = payload.PlanID
+		// Продлеваемое подключение известно заранее, новое связывается после создания
+		trx.VPNConnectionID = payload.ConnectionID
+		trx.PromoCodeID = payload.PromoCodeID
+	}
+	created, err := p.transactionService.RegisterPayment(trx)
+	if err != nil {
+		// Без записи платежа нельзя гарантировать однократное выполнение — не выполняем действие
+		log.Printf("[ERROR] Ошибка записи платежа %s: %v", sp.TelegramPaymentChargeID, err)
+		if adminID := p.config.Admin.GlobalAdminTgID; adminID != 0 {
+			_ = p.sendMessageHTML(client, int(adminID), fmt.Sprintf("⚠️ <b>Не удалось записать платеж</b> <code>%s</code> пользователя <code>%d</code>: %s",
+				sp.TelegramPaymentChargeID, userID, err.Error()))
+		}
+		return p.sendMessageHTML(client, chatID, "⚠️ Платёж получен, но не удалось его обработать. Администратор уже уведомлен.")
+	}
+	if !created {
+		log.Printf("[handleSuccessfulPayment] Платеж %s уже получен ранее (статус %s), повторная доставка пропущена",
+			sp.TelegramPaymentChargeID, trx.Status)
+		return nil
 	}
 
+	if sp.SubscriptionExpirationDate != 0 {
+		p.recordSubscriptionPayment(client, trx, sp)
+	}
+	p.fulfillPayment(client, chatID, trx, false)
 	return nil
 }
 
-func (p *MessageProcessor) createVPNAndSendInfo(client *TelegramClient, chatID int, userID int64) error {
+// fulfillPayment выполняет оплаченное действие и переводит платеж в итоговый статус.
+// Если действие выполнить не удалось, платеж возвращается пользователю.
+// resume — повторная обработка прерванного платежа при запуске сервиса.
+func (p *MessageProcessor) fulfillPayment(client *TelegramClient, chatID int, trx *services.Transaction, resume bool) {
+	claimed, err := p.transactionService.StartProvisioning(trx.ID, resume)
+	if err != nil {
+		log.Printf("[fulfillPayment] %v", err)
+		return
+	}
+	if !claimed {
+		log.Printf("[fulfillPayment] Платеж %d уже обрабатывается или завершен", trx.ID)
+		return
+	}
+
+	// Обработка могла прерваться после выполнения действия, но до сохранения статуса
+	if connectionID, err := p.vpnConnectionService.GetConnectionIDByChargeID(trx.TelegramPaymentChargeID); err != nil {
+		log.Printf("[fulfillPayment] %v", err)
+	} else if connectionID != 0 {
+		log.Printf("[fulfillPayment] Платеж %d уже выполнен (VPN #%d)", trx.ID, connectionID)
+		p.linkConnection(trx, connectionID)
+		p.completePayment(client, trx)
+		return
+	}
+
+	payload, err := services.ParseInvoicePayload(trx.InvoicePayload)
+	if err != nil {
+		log.Printf("[fulfillPayment] %v", err)
+		payload = &services.InvoicePayload{Action: services.PayloadActionCreateVPN, UserID: trx.TelegramUserID}
+	}
+	if payload.Action == services.PayloadActionTopUp {
+		p.fulfillTopUp(client, chatID, trx)
+		return
+	}
+	plan := p.redeemPromo(trx, p.paidPlan(payload))
+	if payload.Action == services.PayloadActionSubscribeVPN {
+		plan = subscriptionPlan(plan)
+	}
+	if payload.Action != services.PayloadActionGiftVPN {
+		// Реферальные дни покупателя не переходят к получателю подарка
+		plan = p.applyReferralBonus(trx, plan)
+	}
+
+	// Сообщаем пользователю, что платёж принят, и выполняем оплаченное действие
+	action := "создать"
+	acceptedMsg := "⭐️ Платёж успешно принят! Создаём VPN..."
+	switch payload.Action {
+	case services.PayloadActionRenewVPN:
+		action = "продлить"
+		acceptedMsg = "⭐️ Платёж успешно принят! Продлеваем VPN..."
+	case services.PayloadActionSubscribeVPN:
+		action = "продлить"
+		acceptedMsg = "⭐️ Платёж по подписке принят! Продлеваем VPN..."
+	case services.PayloadActionGiftVPN:
+		action = "подарить"
+		acceptedMsg = "⭐️ Платёж успешно принят! Оформляем подарок..."
+	}
+	errMsg := p.sendMessageHTML(client, chatID, acceptedMsg)
+	if errMsg != nil {
+		log.Printf("[MessageProcessor] Ошибка отправки сообщения о принятии платежа: %v", errMsg)
+	}
+
+	var connectionID int
+	var errVPN error
+	switch payload.Action {
+	case services.PayloadActionRenewVPN, services.PayloadActionSubscribeVPN:
+		connectionID, errVPN = p.renewVPNAndSendInfo(client, chatID, trx.TelegramUserID, payload.ConnectionID, plan, trx.TelegramPaymentChargeID)
+	case services.PayloadActionGiftVPN:
+		// VPN создается при активации подарка получателем
+		errVPN = p.createGiftAndSendCode(client, chatID, trx, plan)
+	default:
+		location, err := p.payloadLocation(payload)
+		if err != nil {
+			// Оплата уже прошла: VPN создается в любой локации тарифа
+			log.Printf("[ERROR] Платеж %d: %v", trx.ID, err)
+		}
+		connectionID, errVPN = p.createVPNAndSendInfo(client, chatID, trx.TelegramUserID, location, plan, trx.TelegramPaymentChargeID)
+	}
+	if errVPN == nil {
+		p.linkConnection(trx, connectionID)
+		p.completePayment(client, trx)
+		return
+	}
+
+	// Если не удалось — делаем возврат
+	log.Printf("[ERROR] Не удалось %s VPN: %v", action, errVPN)
+	if payload.Action == services.PayloadActionSubscribeVPN {
+		// Подключение недоступно — следующие списания по подписке тоже не выполнятся
+		p.cancelConnectionSubscription(client, payload.ConnectionID)
+	}
+	refundErr := p.returnPaymentFunds(client, trx, fmt.Sprintf("Не удалось %s VPN, возврат средств", action), 0)
+	if refundErr != nil {
+		log.Printf("[ERROR] Ошибка возврата средств: %v", refundErr)
+		p.finishPayment(trx, services.TransactionStatusFailed, fmt.Errorf("%v; возврат: %v", errVPN, refundErr))
+		p.sendMessageHTML(client, chatID, fmt.Sprintf("❌ Не удалось %s VPN и вернуть средства. Обратитесь к администратору.", action))
+		return
+	}
+	if err := p.transactionService.AddRefund(trx, fmt.Sprintf("Автоматический возврат: не удалось %s VPN", action)); err != nil {
+		log.Printf("[ERROR] %v", err)
+	}
+	if trx.PromoCodeID != 0 {
+		if err := p.promoService.ReleaseRedemption(trx.ID); err != nil {
+			log.Printf("[ERROR] Платеж %d: %v", trx.ID, err)
+		}
+	}
+	if err := p.referralService.ReleaseBonusDays(trx.ID); err != nil {
+		log.Printf("[ERROR] Платеж %d: %v", trx.ID, err)
+	}
+	p.finishPayment(trx, services.TransactionStatusRefunded, errVPN)
+	refunded := "Ваши средства возвращены."
+	if trx.PaymentMethod == services.PaymentMethodBalance {
+		refunded = "Средства возвращены на баланс."
+	}
+	p.sendMessageHTML(client, chatID, fmt.Sprintf("❌ Не удалось %s VPN. %s", action, refunded))
+}
+
+// completePayment завершает выполненный платеж: начисляет награду пригласившему
+// и завершает пробный период покупателя
+func (p *MessageProcessor) completePayment(client *TelegramClient, trx *services.Transaction) {
+	p.finishPayment(trx, services.TransactionStatusFulfilled, nil)
+	p.rewardReferrer(client, trx)
+	p.convertTrial(trx)
+}
+
+// finishPayment сохраняет итоговый статус платежа
+func (p *MessageProcessor) finishPayment(trx *services.Transaction, status string, lastErr error) {
+	if err := p.transactionService.FinishPayment(trx.ID, status, lastErr); err != nil {
+		log.Printf("[ERROR] Платеж %d: %v", trx.ID, err)
+		return
+	}
+	trx.Status = status
+	log.Printf("[MessageProcessor] Платеж %d (%s) завершен со статусом %s", trx.ID, trx.TelegramPaymentChargeID, status)
+}
+
+// linkConnection связывает платеж с оплаченным подключением
+func (p *MessageProcessor) linkConnection(trx *services.Transaction, connectionID int) {
+	if connectionID == 0 || trx.VPNConnectionID == connectionID {
+		return
+	}
+	if err := p.transactionService.LinkConnection(trx.ID, connectionID); err != nil {
+		log.Printf("[ERROR] Платеж %d: %v", trx.ID, err)
+		return
+	}
+	trx.VPNConnectionID = connectionID
+}
+
+// ResumePendingPayments продолжает обработку платежей, прерванную остановкой сервиса.
+// Вызывается один раз при запуске бота.
+func (p *MessageProcessor) ResumePendingPayments(client *TelegramClient) {
+	payments, err := p.transactionService.GetUnfinishedPayments()
+	if err != nil {
+		log.Printf("[ResumePendingPayments] %v", err)
+		return
+	}
+	if len(payments) == 0 {
+		return
+	}
+
+	log.Printf("[ResumePendingPayments] Незавершенных платежей: %d", len(payments))
+	for _, trx := range payments {
+		// Платежи Stars приходят из личного чата, его ID совпадает с ID пользователя
+		p.fulfillPayment(client, int(trx.TelegramUserID), trx, true)
+	}
+}
+
+// paidPlan возвращает тариф из payload оплаченного счета. Оплата уже прошла, поэтому
+// отключенный тариф все равно выдается; nil — счет выставлен без тарифа или тариф удален.
+func (p *MessageProcessor) paidPlan(payload *services.InvoicePayload) *services.Plan {
+	if payload.PlanID == 0 {
+		return nil
+	}
+	plan, err := p.planService.GetPlanByID(payload.PlanID)
+	if err != nil {
+		log.Printf("[paidPlan] %v", err)
+		return nil
+	}
+	if plan == nil {
+		log.Printf("[paidPlan] Тариф %d из счета не найден, используются параметры по умолчанию", payload.PlanID)
+		return nil
+	}
+	if plan.Version != payload.PlanVersion {
+		log.Printf("[paidPlan] Тариф %d изменился после выставления счета (версия %d, в счете %d)",
+			plan.ID, plan.Version, payload.PlanVersion)
+	}
+	return plan
+}
+
+// redeemPromo записывает использование промокода из счета и возвращает тариф
+// с дополнительными днями промокода. Скидка уже учтена в сумме платежа.
+func (p *MessageProcessor) redeemPromo(trx *services.Transaction, plan *services.Plan) *services.Plan {
+	if trx.PromoCodeID == 0 {
+		return plan
+	}
+	promo, err := p.promoService.GetPromoCodeByID(trx.PromoCodeID)
+	if err != nil || promo == nil {
+		log.Printf("[redeemPromo] Промокод %d платежа %d не найден: %v", trx.PromoCodeID, trx.ID, err)
+		return plan
+	}
+
+	discount, bonusDays := 0, 0
+	if plan != nil {
+		discount = plan.PriceStars - trx.Amount
+		if plan.DurationDays > 0 {
+			bonusDays = promo.BonusDays()
+		}
+	}
+	redeemed, err := p.promoService.Redeem(promo.ID, trx.TelegramUserID, trx.ID, discount, bonusDays)
+	if err != nil {
+		log.Printf("[ERROR] Платеж %d: %v", trx.ID, err)
+		return plan
+	}
+	if !redeemed {
+		log.Printf("[redeemPromo] Промокод %s уже использован пользователем %d другим платежом", promo.Code, trx.TelegramUserID)
+		return plan
+	}
+	if bonusDays == 0 {
+		return plan
+	}
+	withBonus := *plan
+	withBonus.DurationDays += bonusDays
+	return &withBonus
+}
+
+// createVPNAndSendInfo создает VPN по тарифу в выбранной локации; если в ней не осталось
+// активных хостов, VPN создается в любой доступной локации тарифа.
+// plan == nil — параметры по умолчанию из конфигурации. Возвращает ID созданного подключения;
+// ошибка возвращается, только если VPN не создан, — платеж в этом случае возвращается.
+func (p *MessageProcessor) createVPNAndSendInfo(client *TelegramClient, chatID int, userID int64, location string, plan *services.Plan, chargeID string) (int, error) {
 	user, err := p.userService.GetUserByTelegramID(userID)
 	if err != nil {
-		return p.sendErrorMessage(client, chatID, "Ошибка получения данных пользователя")
+		return 0, fmt.Errorf("ошибка получения данных пользователя: %w", err)
 	}
-	servers, err := p.xuiServerService.GetActiveServers()
-	if err != nil || len(servers) == 0 {
-		return p.sendMessageHTML(client, chatID, "❌ Нет доступных XUI хостов для создания VPN. Обратитесь к администратору.")
+	spec := services.DefaultProvisionSpec(&p.config.VPN)
+	req := services.HostSelectionRequest{TelegramUserID: userID, Location: location}
+	if plan != nil {
+		spec = plan.ProvisionSpec(p.config.VPN.DefaultFlow)
+		req.Strategy = plan.HostStrategy
+		req.AllowedLocations = plan.AllowedLocations
+	}
+	spec.PaymentChargeID = chargeID
+	server, err := p.hostSelectionService.SelectServer(req)
+	if errors.Is(err, services.ErrNoHostAvailable) && location != "" {
+		log.Printf("[createVPNAndSendInfo] Локация %s недоступна, выбираем хост в другой локации", location)
+		req.Location = ""
+		server, err = p.hostSelectionService.SelectServer(req)
+		if err == nil {
+			_ = p.sendMessageHTML(client, chatID, fmt.Sprintf("⚠️ Локация <b>%s</b> сейчас недоступна. VPN будет создан в локации <b>%s</b>.", location, server.ServerLocation))
+		}
+	}
+	if err != nil {
+		log.Printf("[ERROR] Ошибка выбора хоста для пользователя %d: %v", userID, err)
+		_ = p.sendMessageHTML(client, chatID, "❌ Нет доступных XUI хостов для создания VPN. Обратитесь к администратору.")
+		return 0, fmt.Errorf("ошибка выбора хоста: %w", err)
 	}
-	rnd := time.Now().UnixNano()
-	idx := int(rnd) % len(servers)
-	server := servers[idx]
 	xui := xui_client.NewClient(server.ServerURL, server.Username, server.Password)
-	vpnService := services.NewVPNService(xui, p.vpnConnectionService)
+	vpnService := services.NewVPNService(xui, p.vpnConnectionService, p.realityService, p.inboundPoolService, p.portAllocator)
 	vpnConnection, err := vpnService.CreateVPNForUser(
 		userID,
 		user.Username,
 		user.FirstName,
 		user.LastName,
-		server.ID,
+		server,
+		spec,
 		&p.config.VPN,
 	)
+	if errors.Is(err, services.ErrPortRangeExhausted) {
+		log.Printf("[ERROR] На сервере %s закончились порты: %v", server.ServerName, err)
+		if adminID := p.config.Admin.GlobalAdminTgID; adminID != 0 {
+			_ = p.sendMessageHTML(client, int(adminID), fmt.Sprintf("⚠️ <b>Закончились порты</b> на хосте %s (ID %d): %s", server.ServerName, server.ID, err.Error()))
+		}
+		_ = p.sendErrorMessage(client, chatID, "На сервере закончились свободные порты. Администратор уже уведомлен, попробуйте позже.")
+		return 0, err
+	}
 	if err != nil {
-		return p.sendErrorMessage(client, chatID, fmt.Sprintf("Ошибка создания VPN: %v", err))
+		return 0, fmt.Errorf("ошибка создания VPN на хосте %s: %w", server.ServerName, err)
 	}
 	message := fmt.Sprintf("✅ <b>VPN успешно создан и сохранен!</b>\n\n")
 	message += fmt.Sprintf("🔒 <b>VPN подключение #%d</b>\n", vpnConnection.ID)
+	if plan != nil {
+		message += fmt.Sprintf("💳 <b>Тариф:</b> %s\n", plan.Name)
+	}
 	message += fmt.Sprintf("🌐 <b>Сервер:</b> %s\n", server.ServerName)
+	if server.ServerLocation != "" {
+		message += fmt.Sprintf("📍 <b>Локация:</b> %s\n", server.ServerLocation)
+	}
 	message += fmt.Sprintf("🔌 <b>Порт:</b> %d\n", vpnConnection.Port)
 	message += fmt.Sprintf("📧 <b>Email:</b> %s\n", vpnConnection.Email)
+	message += fmt.Sprintf("📊 <b>Трафик:</b> %s\n", services.FormatTrafficLimit(vpnConnection.TrafficLimitBytes))
+	message += fmt.Sprintf("📱 <b>Устройств:</b> %s\n", services.FormatLimitIP(vpnConnection.LimitIP))
+	message += fmt.Sprintf("⏳ <b>Действует до:</b> %s\n", services.FormatExpiry(vpnConnection.ExpiresAt))
 	message += fmt.Sprintf("📅 <b>Создано:</b> %s\n\n", vpnConnection.CreatedAt.Format("02.01.2006 15:04:05"))
 	message += "🔗 <b>VLESS ссылка для подключения:</b>\n"
 	message += fmt.Sprintf("<code>%s</code>\n\n", vpnConnection.VlessLink)
@@ -118,5 +549,54 @@ func (p *MessageProcessor) createVPNAndSendInfo(client *TelegramClient, chatID i
 	message += "3. Нажмите «+» и выберите «Импорт из буфера обмена»\n"
 	message += "4. Вставьте ссылку и нажмите «Сохранить»\n\n"
 	message += "💡 <b>Управление VPN:</b> Используйте команду /vpn для просмотра всех ваших подключений"
-	return p.sendMessageHTML(client, chatID, message)
+	// VPN уже создан: ошибка отправки сообщения не должна приводить к возврату платежа
+	_ = p.sendMessageHTML(client, chatID, message)
+	return vpnConnection.ID, nil
+}
+
+// renewVPNAndSendInfo продлевает подключение пользователя по оплаченному тарифу.
+// Ошибка возвращается, если продление не выполнено, — платеж в этом случае возвращается.
+func (p *MessageProcessor) renewVPNAndSendInfo(client *TelegramClient, chatID int, userID int64, connectionID int, plan *services.Plan, chargeID string) (int, error) {
+	vpn, err := p.vpnConnectionService.GetVPNConnectionByID(connectionID)
+	if err != nil {
+		return 0, err
+	}
+	if vpn == nil || !(vpn.IsActive || vpn.IsExpired()) || vpn.RemovalPending || vpn.TelegramUserID != userID {
+		return 0, fmt.Errorf("подключение %d недоступно для продления пользователем %d", connectionID, userID)
+	}
+	server, err := p.xuiServerService.GetServerByID(vpn.ServerID)
+	if err != nil {
+		return 0, err
+	}
+	if server == nil {
+		return 0, fmt.Errorf("сервер %d подключения %d не найден", vpn.ServerID, vpn.ID)
+	}
+
+	spec := services.DefaultProvisionSpec(&p.config.VPN)
+	if plan != nil {
+		spec = plan.ProvisionSpec(p.config.VPN.DefaultFlow)
+	}
+	spec.Flow = vpn.Flow
+
+	xui := xui_client.NewClient(server.ServerURL, server.Username, server.Password)
+	vpnService := services.NewVPNService(xui, p.vpnConnectionService, p.realityService, p.inboundPoolService, p.portAllocator)
+	renewed, err := vpnService.RenewVPNConnection(vpn, spec, chargeID, server.LinkAddress(p.config.VPN.ServerIP))
+	if err != nil {
+		return 0, err
+	}
+
+	message := fmt.Sprintf("✅ <b>VPN #%d продлен!</b>\n\n", renewed.ID)
+	if plan != nil {
+		message += fmt.Sprintf("💳 <b>Тариф:</b> %s\n", plan.Name)
+	}
+	message += fmt.Sprintf("📊 <b>Трафик:</b> %s\n", services.FormatTrafficLimit(renewed.TrafficLimitBytes))
+	message += fmt.Sprintf("📱 <b>Устройств:</b> %s\n", services.FormatLimitIP(renewed.LimitIP))
+	message += fmt.Sprintf("⏳ <b>Действует до:</b> %s\n\n", services.FormatExpiry(renewed.ExpiresAt))
+	if renewed.VlessLink != vpn.VlessLink {
+		message += fmt.Sprintf("🔗 <b>Ссылка для подключения обновлена:</b>\n<code>%s</code>", renewed.VlessLink)
+	} else {
+		message += "🔗 Ссылка для подключения не изменилась."
+	}
+	_ = p.sendMessageHTML(client, chatID, message)
+	return renewed.ID, nil
 }
@@ -0,0 +1,187 @@
+package telegram
+
+import (
+	"TelegramXUI/internal/services"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// planFieldsUsage формат полей тарифа в /plan_add и /plan_edit
+const planFieldsUsage = "название; цена ⭐; дней; ГБ; устройств; локации через запятую или *; стратегия или -"
+
+// handlePlansCommand показывает все тарифы
+func (p *MessageProcessor) handlePlansCommand(client *TelegramClient, update Update) error {
+	chatID := update.Message.Chat.ID
+	if !p.adminService.IsGlobalAdmin(int64(update.Message.From.ID)) {
+		return p.sendMessage(client, chatID, "❌ Только глобальные администраторы могут управлять тарифами.")
+	}
+
+	plans, err := p.planService.GetAllPlans()
+	if err != nil {
+		return p.sendErrorMessage(client, chatID, "Ошибка получения тарифов")
+	}
+
+	var sb strings.Builder
+	sb.WriteString("💳 <b>Тарифы</b>\n\n")
+	if len(plans) == 0 {
+		sb.WriteString("Тарифов пока нет.\n")
+	}
+	for _, plan := range plans {
+		status := "✅"
+		if !plan.IsActive {
+			status = "⛔"
+		}
+		locations := "все"
+		if len(plan.AllowedLocations) > 0 {
+			locations = strings.Join(plan.AllowedLocations, ", ")
+		}
+		strategy := plan.HostStrategy
+		if strategy == "" {
+			strategy = "по умолчанию"
+		}
+		sb.WriteString(fmt.Sprintf("%s ID <code>%d</code> (v%d): %s\nЛокации: %s | Стратегия: %s\n\n",
+			status, plan.ID, plan.Version, services.FormatPlan(plan), locations, strategy))
+	}
+	sb.WriteString("Добавить: <code>/plan_add " + planFieldsUsage + "</code>\n")
+	sb.WriteString("Изменить: <code>/plan_edit ID; " + planFieldsUsage + "</code>\n")
+	sb.WriteString("Включить/отключить: <code>/plan_toggle ID</code>")
+	return p.sendMessageHTML(client, chatID, sb.String())
+}
+
+// handlePlanAddCommand добавляет тариф: /plan_add название; цена; дней; ГБ; устройств; локации; стратегия
+func (p *MessageProcessor) handlePlanAddCommand(client *TelegramClient, update Update, args []string) error {
+	chatID := update.Message.Chat.ID
+	if !p.adminService.IsGlobalAdmin(int64(update.Message.From.ID)) {
+		return p.sendMessage(client, chatID, "❌ Только глобальные администраторы могут управлять тарифами.")
+	}
+
+	plan := &services.Plan{IsActive: true}
+	if err := p.parsePlanFields(plan, splitPlanArgs(args)); err != nil {
+		return p.sendMessageHTML(client, chatID, "❌ "+err.Error()+"\nИспользование: <code>/plan_add "+planFieldsUsage+"</code>")
+	}
+	if err := p.planService.CreatePlan(plan); err != nil {
+		return p.sendErrorMessage(client, chatID, err.Error())
+	}
+	return p.sendMessageHTML(client, chatID, fmt.Sprintf("✅ Тариф добавлен: ID <code>%d</code>\n%s", plan.ID, services.FormatPlan(plan)))
+}
+
+// handlePlanEditCommand изменяет условия тарифа: /plan_edit ID; название; цена; ...
+// Версия тарифа увеличивается, уже выставленные счета сохраняют старую версию в payload.
+func (p *MessageProcessor) handlePlanEditCommand(client *TelegramClient, update Update, args []string) error {
+	chatID := update.Message.Chat.ID
+	if !p.adminService.IsGlobalAdmin(int64(update.Message.From.ID)) {
+		return p.sendMessage(client, chatID, "❌ Только глобальные администраторы могут управлять тарифами.")
+	}
+
+	usage := "Использование: <code>/plan_edit ID; " + planFieldsUsage + "</code>"
+	fields := splitPlanArgs(args)
+	if len(fields) == 0 {
+		return p.sendMessageHTML(client, chatID, usage)
+	}
+	planID, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return p.sendErrorMessage(client, chatID, "Неверный ID тарифа")
+	}
+	plan, err := p.planService.GetPlanByID(planID)
+	if err != nil {
+		return p.sendErrorMessage(client, chatID, "Ошибка получения тарифа")
+	}
+	if plan == nil {
+		return p.sendErrorMessage(client, chatID, fmt.Sprintf("Тариф с ID %d не найден", planID))
+	}
+	if err := p.parsePlanFields(plan, fields[1:]); err != nil {
+		return p.sendMessageHTML(client, chatID, "❌ "+err.Error()+"\n"+usage)
+	}
+	if err := p.planService.UpdatePlan(plan); err != nil {
+		return p.sendErrorMessage(client, chatID, err.Error())
+	}
+	return p.sendMessageHTML(client, chatID, fmt.Sprintf("✅ Тариф <code>%d</code> обновлен (версия %d)\n%s", plan.ID, plan.Version, services.FormatPlan(plan)))
+}
+
+// handlePlanToggleCommand включает или отключает продажу тарифа: /plan_toggle ID
+func (p *MessageProcessor) handlePlanToggleCommand(client *TelegramClient, update Update, args []string) error {
+	chatID := update.Message.Chat.ID
+	if !p.adminService.IsGlobalAdmin(int64(update.Message.From.ID)) {
+		return p.sendMessage(client, chatID, "❌ Только глобальные администраторы могут управлять тарифами.")
+	}
+
+	if len(args) != 1 {
+		return p.sendMessageHTML(client, chatID, "Использование: <code>/plan_toggle ID</code>")
+	}
+	planID, err := strconv.Atoi(args[0])
+	if err != nil {
+		return p.sendErrorMessage(client, chatID, "Неверный ID тарифа")
+	}
+	plan, err := p.planService.GetPlanByID(planID)
+	if err != nil {
+		return p.sendErrorMessage(client, chatID, "Ошибка получения тарифа")
+	}
+	if plan == nil {
+		return p.sendErrorMessage(client, chatID, fmt.Sprintf("Тариф с ID %d не найден", planID))
+	}
+	if err := p.planService.SetPlanActive(plan.ID, !plan.IsActive); err != nil {
+		return p.sendErrorMessage(client, chatID, err.Error())
+	}
+	if plan.IsActive {
+		return p.sendMessageHTML(client, chatID, fmt.Sprintf("⛔ Тариф <b>%s</b> отключен", plan.Name))
+	}
+	return p.sendMessageHTML(client, chatID, fmt.Sprintf("✅ Тариф <b>%s</b> включен", plan.Name))
+}
+
+// splitPlanArgs собирает аргументы команды обратно и делит их по «;»,
+// чтобы название тарифа и список локаций могли содержать пробелы
+func splitPlanArgs(args []string) []string {
+	raw := strings.Join(args, " ")
+	if strings.TrimSpace(raw) == "" {
+		return nil
+	}
+	fields := strings.Split(raw, ";")
+	for i := range fields {
+		fields[i] = strings.TrimSpace(fields[i])
+	}
+	return fields
+}
+
+// parsePlanFields заполняет тариф из полей команды в порядке planFieldsUsage
+func (p *MessageProcessor) parsePlanFields(plan *services.Plan, fields []string) error {
+	if len(fields) != 7 {
+		return fmt.Errorf("нужно 7 полей через «;», получено %d", len(fields))
+	}
+
+	numbers := make([]int, 4)
+	names := []string{"цена", "дней", "ГБ", "устройств"}
+	for i := range numbers {
+		n, err := strconv.Atoi(fields[i+1])
+		if err != nil {
+			return fmt.Errorf("поле «%s» должно быть числом", names[i])
+		}
+		numbers[i] = n
+	}
+
+	var locations []string
+	if fields[5] != "*" && fields[5] != "" {
+		for _, location := range strings.Split(fields[5], ",") {
+			if location = strings.TrimSpace(location); location != "" {
+				locations = append(locations, location)
+			}
+		}
+	}
+
+	strategy := fields[6]
+	if strategy == "-" {
+		strategy = ""
+	}
+	if strategy != "" && !p.hostSelectionService.IsValidStrategy(strategy) {
+		return fmt.Errorf("неизвестная стратегия: %s", strategy)
+	}
+
+	plan.Name = fields[0]
+	plan.PriceStars = numbers[0]
+	plan.DurationDays = numbers[1]
+	plan.TrafficGB = numbers[2]
+	plan.LimitIP = numbers[3]
+	plan.AllowedLocations = locations
+	plan.HostStrategy = strategy
+	return plan.Validate()
+}
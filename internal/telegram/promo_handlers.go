@@ -0,0 +1,267 @@
+package telegram
+
+import (
+	"TelegramXUI/internal/services"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// promoFieldsUsage формат полей промокода в /promo_add
+const promoFieldsUsage = "КОД; percent|fixed|free_days; значение; лимит использований или 0; до ДД.ММ.ГГГГ или -; ID тарифов через запятую или *"
+
+// promoHint подсказка о вводе промокода в списке тарифов
+const promoHint = "🎟 Есть промокод? Отправьте <code>/promo КОД</code> до выбора тарифа."
+
+// handlePromoCommand применяет промокод к следующей покупке: /promo КОД, /promo - — сбросить
+func (p *MessageProcessor) handlePromoCommand(client *TelegramClient, update Update, args []string) error {
+	chatID := update.Message.Chat.ID
+	userID := int64(update.Message.From.ID)
+
+	if len(args) == 0 {
+		promo := p.activatedPromo(userID)
+		if promo == nil {
+			return p.sendMessageHTML(client, chatID, "🎟 Промокод не введен.\nИспользование: <code>/promo КОД</code>")
+		}
+		return p.sendMessageHTML(client, chatID, fmt.Sprintf("🎟 Введен промокод <b>%s</b>: %s.\nСбросить: <code>/promo -</code>",
+			promo.Code, services.FormatPromoDiscount(promo)))
+	}
+	if args[0] == "-" {
+		if err := p.promoService.ClearActivation(userID); err != nil {
+			log.Printf("[handlePromoCommand] %v", err)
+			return p.sendErrorMessage(client, chatID, "Не удалось сбросить промокод")
+		}
+		return p.sendMessage(client, chatID, "🎟 Промокод сброшен")
+	}
+
+	promo, err := p.promoService.GetPromoCodeByCode(args[0])
+	if err != nil {
+		log.Printf("[handlePromoCommand] %v", err)
+		return p.sendErrorMessage(client, chatID, "Не удалось проверить промокод, попробуйте позже")
+	}
+	if promo == nil {
+		return p.sendErrorMessage(client, chatID, "Промокод не найден")
+	}
+	if err := p.promoService.CheckRedeemable(promo, userID); err != nil {
+		return p.sendErrorMessage(client, chatID, err.Error())
+	}
+	if err := p.promoService.Activate(userID, promo.ID); err != nil {
+		log.Printf("[handlePromoCommand] %v", err)
+		return p.sendErrorMessage(client, chatID, "Не удалось применить промокод")
+	}
+
+	message := fmt.Sprintf("✅ Промокод <b>%s</b> применен: %s.\n", promo.Code, services.FormatPromoDiscount(promo))
+	if len(promo.AllowedPlanIDs) > 0 {
+		message += "Промокод действует не на все тарифы — цены со скидкой отмечены в списке.\n"
+	}
+	message += "Скидка будет учтена в счете при покупке или продлении VPN."
+	return p.sendMessageWithKeyboard(client, chatID, message, makeCreateVPNButton())
+}
+
+// activatedPromo возвращает промокод, введенный пользователем, если его еще можно использовать
+func (p *MessageProcessor) activatedPromo(userID int64) *services.PromoCode {
+	promo, err := p.promoService.GetActivatedPromoCode(userID)
+	if err != nil {
+		log.Printf("[activatedPromo] %v", err)
+		return nil
+	}
+	if promo == nil {
+		return nil
+	}
+	if err := p.promoService.CheckRedeemable(promo, userID); err != nil {
+		log.Printf("[activatedPromo] Промокод %s пользователя %d не применяется: %v", promo.Code, userID, err)
+		return nil
+	}
+	return promo
+}
+
+// planPrice возвращает цену тарифа с учетом промокода и промокод, если он применим к тарифу
+func planPrice(plan *services.Plan, promo *services.PromoCode) (int, *services.PromoCode) {
+	if promo == nil || !promo.AppliesTo(plan) {
+		return plan.PriceStars, nil
+	}
+	return promo.Apply(plan.PriceStars), promo
+}
+
+// promoID возвращает ID промокода или 0, если промокод не применяется
+func promoID(promo *services.PromoCode) int {
+	if promo == nil {
+		return 0
+	}
+	return promo.ID
+}
+
+// promoLine строка под списком тарифов: введенный промокод или подсказка
+func promoLine(promo *services.PromoCode) string {
+	if promo == nil {
+		return promoHint
+	}
+	return fmt.Sprintf("🎟 Промокод <b>%s</b>: %s", promo.Code, services.FormatPromoDiscount(promo))
+}
+
+// promoInvoiceNote дополнение к описанию счета о примененном промокоде
+func promoInvoiceNote(promo *services.PromoCode) string {
+	if promo == nil {
+		return ""
+	}
+	return fmt.Sprintf(". Промокод %s: %s", promo.Code, services.FormatPromoDiscount(promo))
+}
+
+// formatPlanOffer форматирует строку тарифа в списке с учетом промокода
+func formatPlanOffer(plan *services.Plan, promo *services.PromoCode) string {
+	line := "• " + services.FormatPlan(plan)
+	if price, applied := planPrice(plan, promo); applied != nil {
+		line += fmt.Sprintf(" → 🎟 %d ⭐", price)
+		if days := applied.BonusDays(); days > 0 {
+			line += fmt.Sprintf(", +%d дн.", days)
+		}
+	}
+	return line + "\n"
+}
+
+// planButtonText текст кнопки тарифа с учетом промокода
+func planButtonText(plan *services.Plan, promo *services.PromoCode) string {
+	price, applied := planPrice(plan, promo)
+	if applied != nil {
+		return fmt.Sprintf("%s — 🎟 %d ⭐", plan.Name, price)
+	}
+	return fmt.Sprintf("%s — %d ⭐", plan.Name, price)
+}
+
+// handlePromosCommand показывает все промокоды
+func (p *MessageProcessor) handlePromosCommand(client *TelegramClient, update Update) error {
+	chatID := update.Message.Chat.ID
+	if !p.adminService.IsGlobalAdmin(int64(update.Message.From.ID)) {
+		return p.sendMessage(client, chatID, "❌ Только глобальные администраторы могут управлять промокодами.")
+	}
+
+	promos, err := p.promoService.GetAllPromoCodes()
+	if err != nil {
+		return p.sendErrorMessage(client, chatID, "Ошибка получения промокодов")
+	}
+
+	var sb strings.Builder
+	sb.WriteString("🎟 <b>Промокоды</b>\n\n")
+	if len(promos) == 0 {
+		sb.WriteString("Промокодов пока нет.\n")
+	}
+	for _, promo := range promos {
+		status := "✅"
+		if !promo.IsActive {
+			status = "⛔"
+		}
+		uses := fmt.Sprintf("%d", promo.UsedCount)
+		if promo.MaxUses > 0 {
+			uses = fmt.Sprintf("%d из %d", promo.UsedCount, promo.MaxUses)
+		}
+		expires := "бессрочно"
+		if promo.ExpiresAt != nil {
+			expires = "до " + promo.ExpiresAt.Format("02.01.2006")
+		}
+		plans := "все"
+		if len(promo.AllowedPlanIDs) > 0 {
+			ids := make([]string, len(promo.AllowedPlanIDs))
+			for i, id := range promo.AllowedPlanIDs {
+				ids[i] = strconv.FormatInt(id, 10)
+			}
+			plans = strings.Join(ids, ", ")
+		}
+		sb.WriteString(fmt.Sprintf("%s <code>%s</code>: %s\nИспользований: %s | %s | Тарифы: %s\n\n",
+			status, promo.Code, services.FormatPromoDiscount(promo), uses, expires, plans))
+	}
+	sb.WriteString("Добавить: <code>/promo_add " + promoFieldsUsage + "</code>\n")
+	sb.WriteString("Включить/отключить: <code>/promo_toggle КОД</code>")
+	return p.sendMessageHTML(client, chatID, sb.String())
+}
+
+// handlePromoAddCommand добавляет промокод: /promo_add КОД; тип; значение; лимит; срок; тарифы
+func (p *MessageProcessor) handlePromoAddCommand(client *TelegramClient, update Update, args []string) error {
+	chatID := update.Message.Chat.ID
+	userID := int64(update.Message.From.ID)
+	if !p.adminService.IsGlobalAdmin(userID) {
+		return p.sendMessage(client, chatID, "❌ Только глобальные администраторы могут управлять промокодами.")
+	}
+
+	promo := &services.PromoCode{IsActive: true, CreatedByTgID: userID}
+	if err := parsePromoFields(promo, splitPlanArgs(args)); err != nil {
+		return p.sendMessageHTML(client, chatID, "❌ "+err.Error()+"\nИспользование: <code>/promo_add "+promoFieldsUsage+"</code>")
+	}
+	if err := p.promoService.CreatePromoCode(promo); err != nil {
+		return p.sendErrorMessage(client, chatID, err.Error())
+	}
+	return p.sendMessageHTML(client, chatID, fmt.Sprintf("✅ Промокод <code>%s</code> добавлен: %s", promo.Code, services.FormatPromoDiscount(promo)))
+}
+
+// handlePromoToggleCommand включает или отключает промокод: /promo_toggle КОД
+func (p *MessageProcessor) handlePromoToggleCommand(client *TelegramClient, update Update, args []string) error {
+	chatID := update.Message.Chat.ID
+	if !p.adminService.IsGlobalAdmin(int64(update.Message.From.ID)) {
+		return p.sendMessage(client, chatID, "❌ Только глобальные администраторы могут управлять промокодами.")
+	}
+
+	if len(args) != 1 {
+		return p.sendMessageHTML(client, chatID, "Использование: <code>/promo_toggle КОД</code>")
+	}
+	promo, err := p.promoService.GetPromoCodeByCode(args[0])
+	if err != nil {
+		return p.sendErrorMessage(client, chatID, "Ошибка получения промокода")
+	}
+	if promo == nil {
+		return p.sendErrorMessage(client, chatID, "Промокод не найден")
+	}
+	if err := p.promoService.SetPromoCodeActive(promo.ID, !promo.IsActive); err != nil {
+		return p.sendErrorMessage(client, chatID, err.Error())
+	}
+	if promo.IsActive {
+		return p.sendMessageHTML(client, chatID, fmt.Sprintf("⛔ Промокод <code>%s</code> отключен", promo.Code))
+	}
+	return p.sendMessageHTML(client, chatID, fmt.Sprintf("✅ Промокод <code>%s</code> включен", promo.Code))
+}
+
+// parsePromoFields заполняет промокод из полей команды в порядке promoFieldsUsage
+func parsePromoFields(promo *services.PromoCode, fields []string) error {
+	if len(fields) != 6 {
+		return fmt.Errorf("нужно 6 полей через «;», получено %d", len(fields))
+	}
+
+	value, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return fmt.Errorf("значение скидки должно быть числом")
+	}
+	maxUses, err := strconv.Atoi(fields[3])
+	if err != nil {
+		return fmt.Errorf("лимит использований должен быть числом")
+	}
+
+	var expiresAt *time.Time
+	if fields[4] != "-" && fields[4] != "" {
+		date, err := time.ParseInLocation("02.01.2006", fields[4], time.Local)
+		if err != nil {
+			return fmt.Errorf("неверная дата %s, нужен формат ДД.ММ.ГГГГ", fields[4])
+		}
+		// Промокод действует до конца указанного дня
+		end := date.AddDate(0, 0, 1)
+		expiresAt = &end
+	}
+
+	var planIDs []int64
+	if fields[5] != "*" && fields[5] != "" {
+		for _, item := range strings.Split(fields[5], ",") {
+			id, err := strconv.ParseInt(strings.TrimSpace(item), 10, 64)
+			if err != nil {
+				return fmt.Errorf("неверный ID тарифа: %s", item)
+			}
+			planIDs = append(planIDs, id)
+		}
+	}
+
+	promo.Code = services.NormalizePromoCode(fields[0])
+	promo.DiscountType = fields[1]
+	promo.DiscountValue = value
+	promo.MaxUses = maxUses
+	promo.ExpiresAt = expiresAt
+	promo.AllowedPlanIDs = planIDs
+	return promo.Validate()
+}
@@ -0,0 +1,136 @@
+package telegram
+
+import (
+	"TelegramXUI/internal/services"
+	"fmt"
+	"html"
+	"log"
+	"strings"
+)
+
+// maxReferralsShown сколько приглашенных показывает /referrals
+const maxReferralsShown = 20
+
+// registerStartUser сохраняет пользователя при /start и записывает пригласившего
+// из параметра ref_<telegram_id>. Возвращает true, если приглашение записано.
+func (p *MessageProcessor) registerStartUser(user User, startParam string) bool {
+	if _, err := p.userService.EnsureUserExists(user); err != nil {
+		log.Printf("[registerStartUser] %v", err)
+		return false
+	}
+	referrerID, ok := services.ParseReferralStartParam(startParam)
+	if !ok {
+		return false
+	}
+	recorded, err := p.referralService.SetReferrer(int64(user.ID), referrerID)
+	if err != nil {
+		log.Printf("[registerStartUser] %v", err)
+		return false
+	}
+	if recorded {
+		log.Printf("[registerStartUser] Пользователь %d приглашен пользователем %d", user.ID, referrerID)
+	}
+	return recorded
+}
+
+// handleReferralsCommand показывает ссылку для приглашения, приглашенных и награды
+func (p *MessageProcessor) handleReferralsCommand(client *TelegramClient, update Update) error {
+	chatID := update.Message.Chat.ID
+	userID := int64(update.Message.From.ID)
+
+	botUsername, err := client.GetBotUsername()
+	if err != nil {
+		log.Printf("[handleReferralsCommand] %v", err)
+		return p.sendErrorMessage(client, chatID, "Не удалось сформировать ссылку, попробуйте позже")
+	}
+	summary, err := p.referralService.GetReferralSummary(userID)
+	if err != nil {
+		log.Printf("[handleReferralsCommand] %v", err)
+		return p.sendErrorMessage(client, chatID, "Ошибка получения приглашенных")
+	}
+
+	var sb strings.Builder
+	sb.WriteString("👥 <b>Пригласите друзей</b>\n\n")
+	sb.WriteString(fmt.Sprintf("Ваша ссылка:\n<code>https://t.me/%s?start=%s</code>\n\n", botUsername, services.ReferralStartParam(userID)))
+	if reward := formatReferralReward(p.config.Referral.RewardDays, p.config.Referral.RewardStars); reward != "" {
+		sb.WriteString("За первую оплату каждого друга вы получите <b>" + reward + "</b>.\n\n")
+	}
+
+	sb.WriteString(fmt.Sprintf("Приглашено: <b>%d</b>\n", len(summary.Invitees)))
+	for i, invitee := range summary.Invitees {
+		if i == maxReferralsShown {
+			sb.WriteString(fmt.Sprintf("… и еще %d\n", len(summary.Invitees)-maxReferralsShown))
+			break
+		}
+		name := invitee.FirstName
+		if invitee.Username != "" {
+			name = "@" + invitee.Username
+		}
+		status := "⏳ ожидает первой оплаты"
+		if invitee.Rewarded {
+			status = "✅ " + formatReferralReward(invitee.RewardDays, invitee.RewardStars)
+		}
+		sb.WriteString(fmt.Sprintf("• %s — %s (%s)\n", html.EscapeString(name), status, invitee.ReferredAt.Format("02.01.2006")))
+	}
+	earned := formatReferralReward(summary.EarnedDays, summary.EarnedStars)
+	if earned == "" {
+		earned = "пока ничего"
+	}
+	sb.WriteString("\nЗаработано: <b>" + earned + "</b>")
+	if summary.PendingDays > 0 {
+		sb.WriteString(fmt.Sprintf("\nБудет добавлено к следующей покупке: <b>%d дн.</b>", summary.PendingDays))
+	}
+	return p.sendMessageHTML(client, chatID, sb.String())
+}
+
+// applyReferralBonus добавляет к оплаченному тарифу неиспользованные реферальные дни
+// покупателя. Бессрочные тарифы и покупки без тарифа дни не расходуют.
+func (p *MessageProcessor) applyReferralBonus(trx *services.Transaction, plan *services.Plan) *services.Plan {
+	if plan == nil || plan.DurationDays == 0 {
+		return plan
+	}
+	days, err := p.referralService.ClaimBonusDays(trx.TelegramUserID, trx.ID)
+	if err != nil {
+		log.Printf("[ERROR] Платеж %d: %v", trx.ID, err)
+		return plan
+	}
+	if days == 0 {
+		return plan
+	}
+	log.Printf("[applyReferralBonus] К платежу %d добавлено %d реферальных дней", trx.ID, days)
+	withBonus := *plan
+	withBonus.DurationDays += days
+	return &withBonus
+}
+
+// rewardReferrer начисляет награду пригласившему после первой оплаты приглашенного
+func (p *MessageProcessor) rewardReferrer(client *TelegramClient, trx *services.Transaction) {
+	days, stars := p.config.Referral.RewardDays, p.config.Referral.RewardStars
+	referrerID, err := p.referralService.GrantReward(trx.TelegramUserID, trx.ID, days, stars)
+	if err != nil {
+		log.Printf("[ERROR] Платеж %d: %v", trx.ID, err)
+		return
+	}
+	if referrerID == 0 {
+		return
+	}
+	reward := formatReferralReward(days, stars)
+	log.Printf("[rewardReferrer] Пользователю %d начислено %s за приглашение %d", referrerID, reward, trx.TelegramUserID)
+	message := fmt.Sprintf("🎉 Приглашенный вами друг совершил первую оплату! Вам начислено <b>%s</b>.", reward)
+	if days > 0 {
+		message += " Дни добавятся к следующей покупке или продлению."
+	}
+	_ = p.sendMessageHTML(client, int(referrerID), message+" Подробнее: /referrals")
+}
+
+// formatReferralReward форматирует награду за приглашение: дни и звезды на баланс
+func formatReferralReward(days, stars int) string {
+	var parts []string
+	if days > 0 {
+		parts = append(parts, fmt.Sprintf("+%d дн.", days))
+	}
+	if stars > 0 {
+		parts = append(parts, fmt.Sprintf("+%d ⭐ на баланс", stars))
+	}
+	return strings.Join(parts, " и ")
+}
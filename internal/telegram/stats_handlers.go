@@ -0,0 +1,96 @@
+package telegram
+
+import (
+	"TelegramXUI/internal/services"
+	"bytes"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// statsUsage формат аргументов /stats
+const statsUsage = "/stats [day|week|month] [количество периодов] [csv]"
+
+// maxStatsPeriods ограничение количества периодов в отчете
+const maxStatsPeriods = 366
+
+// handleStatsCommand показывает выручку, возвраты и покупки по периодам и тарифам:
+// /stats [day|week|month] [N] [csv]
+func (p *MessageProcessor) handleStatsCommand(client *TelegramClient, update Update, args []string) error {
+	chatID := update.Message.Chat.ID
+	if !p.adminService.IsGlobalAdmin(int64(update.Message.From.ID)) {
+		return p.sendMessage(client, chatID, "❌ Только глобальные администраторы могут просматривать статистику.")
+	}
+
+	granularity := services.ReportGranularityDay
+	count := 0
+	asCSV := false
+	for _, arg := range args {
+		switch {
+		case arg == "csv":
+			asCSV = true
+		case services.IsValidReportGranularity(arg):
+			granularity = arg
+		default:
+			n, err := strconv.Atoi(arg)
+			if err != nil || n < 1 || n > maxStatsPeriods {
+				return p.sendMessageHTML(client, chatID, fmt.Sprintf("❌ Неверный аргумент: %s\nИспользование: <code>%s</code>", arg, statsUsage))
+			}
+			count = n
+		}
+	}
+	if count == 0 {
+		count = services.DefaultReportPeriods(granularity)
+	}
+
+	from, to := services.ReportRange(granularity, count, time.Now())
+	report, err := p.reportService.GetRevenueReport(granularity, from, to)
+	if err != nil {
+		log.Printf("[handleStatsCommand] %v", err)
+		return p.sendErrorMessage(client, chatID, "Ошибка построения отчета")
+	}
+
+	if asCSV {
+		var buf bytes.Buffer
+		if err := report.WriteCSV(&buf); err != nil {
+			return p.sendErrorMessage(client, chatID, "Ошибка формирования CSV")
+		}
+		fileName := fmt.Sprintf("stats_%s_%s_%s.csv", granularity, from.Format("20060102"), to.Format("20060102"))
+		caption := fmt.Sprintf("Отчет с %s по %s", from.Format("02.01.2006"), to.Format("02.01.2006"))
+		if err := client.SendDocumentBytes(chatID, fileName, buf.Bytes(), caption); err != nil {
+			log.Printf("[handleStatsCommand] %v", err)
+			return p.sendErrorMessage(client, chatID, "Ошибка отправки CSV")
+		}
+		return nil
+	}
+	return p.sendMessageHTML(client, chatID, formatRevenueReport(report))
+}
+
+// formatRevenueReport форматирует отчет для сообщения администратору
+func formatRevenueReport(report *services.RevenueReport) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("📈 <b>Финансы с %s по %s</b>\n\n", report.From.Format("02.01.2006"), report.To.Format("02.01.2006")))
+	sb.WriteString(fmt.Sprintf("💰 Выручка: <b>%d ⭐</b>\n↩️ Возвраты: <b>%d ⭐</b> (%d)\n✅ Чистыми: <b>%d ⭐</b>\n🛒 Покупок: <b>%d</b>\n",
+		report.Total.Gross, report.Total.Refunds, report.Total.Refunded, report.Total.Net, report.Total.Purchases))
+	if len(report.Periods) == 0 {
+		sb.WriteString("\nПлатежей за период нет.")
+		return sb.String()
+	}
+
+	sb.WriteString("\n<b>По периодам:</b>\n")
+	for _, period := range report.Periods {
+		sb.WriteString(fmt.Sprintf("%s: %d ⭐ − %d ⭐ = <b>%d ⭐</b>, покупок: %d\n",
+			services.FormatReportPeriod(report.Granularity, period.Period),
+			period.Gross, period.Refunds, period.Net, period.Purchases))
+	}
+
+	sb.WriteString("\n<b>По тарифам:</b>\n")
+	for _, plan := range report.Plans {
+		sb.WriteString(fmt.Sprintf("%s: %d ⭐ − %d ⭐ = <b>%d ⭐</b>, покупок: %d\n",
+			plan.PlanName, plan.Gross, plan.Refunds, plan.Net, plan.Purchases))
+	}
+	sb.WriteString("\nCSV: <code>/stats " + report.Granularity + " csv</code>")
+	return sb.String()
+}
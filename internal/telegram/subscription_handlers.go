@@ -0,0 +1,205 @@
+package telegram
+
+import (
+	"TelegramXUI/internal/services"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// subscriptionPlan возвращает условия продления по подписке: срок всегда равен периоду
+// подписки, даже если тариф изменили или удалили после оформления
+func subscriptionPlan(plan *services.Plan) *services.Plan {
+	subscribed := &services.Plan{Name: "Подписка"}
+	if plan != nil {
+		copied := *plan
+		subscribed = &copied
+	}
+	subscribed.DurationDays = services.SubscriptionPeriodDays
+	return subscribed
+}
+
+// offerSubscription предлагает оформить подписку на продление подключения, если срок тарифа
+// совпадает с периодом подписки Telegram Stars и подписки еще нет. Скидки промокодов
+// на подписку не действуют.
+func (p *MessageProcessor) offerSubscription(client *TelegramClient, chatID int, vpn *services.VPNConnection, plan *services.Plan) {
+	if plan.DurationDays != services.SubscriptionPeriodDays {
+		return
+	}
+	sub, err := p.subscriptionService.GetRenewingByConnection(vpn.ID)
+	if err != nil {
+		log.Printf("[offerSubscription] %v", err)
+		return
+	}
+	if sub != nil {
+		return
+	}
+
+	payload, err := (&services.InvoicePayload{
+		Action:       services.PayloadActionSubscribeVPN,
+		UserID:       vpn.TelegramUserID,
+		PlanID:       plan.ID,
+		PlanVersion:  plan.Version,
+		ConnectionID: vpn.ID,
+	}).Encode()
+	if err != nil {
+		log.Printf("[offerSubscription] %v", err)
+		return
+	}
+	description := fmt.Sprintf("Автопродление VPN #%d каждые %d дн. %s", vpn.ID, services.SubscriptionPeriodDays, services.FormatPlan(plan))
+	prices := []LabeledPrice{{Label: plan.Name, Amount: plan.PriceStars}}
+	link, err := client.CreateInvoiceLink(plan.Name, description, payload, "XTR", prices, services.SubscriptionPeriodSeconds)
+	if err != nil {
+		log.Printf("[offerSubscription] %v", err)
+		return
+	}
+	keyboard := &InlineKeyboardMarkup{InlineKeyboard: [][]InlineKeyboardButton{{
+		{Text: fmt.Sprintf("🔁 Подписка: %d ⭐ / %d дн.", plan.PriceStars, services.SubscriptionPeriodDays), URL: link},
+	}}}
+	if err := p.sendMessageWithKeyboard(client, chatID, "🔁 Или оформите подписку: Telegram будет продлевать VPN автоматически, "+
+		"отменить можно в любой момент в /subscriptions.", keyboard); err != nil {
+		log.Printf("[offerSubscription] %v", err)
+	}
+}
+
+// recordSubscriptionPayment сохраняет платеж по подписке до продления подключения.
+// Если на подключение уже была оформлена другая подписка (пользователь оплатил две ссылки),
+// старая отменяется, чтобы за одно подключение не списывалось дважды.
+func (p *MessageProcessor) recordSubscriptionPayment(client *TelegramClient, trx *services.Transaction, sp *SuccessfulPayment) {
+	if trx.VPNConnectionID == 0 {
+		log.Printf("[recordSubscriptionPayment] В платеже %d по подписке не указано подключение", trx.ID)
+		return
+	}
+	first := sp.IsFirstRecurring || !sp.IsRecurring
+	if first {
+		previous, err := p.subscriptionService.GetRenewingByConnection(trx.VPNConnectionID)
+		if err != nil {
+			log.Printf("[recordSubscriptionPayment] %v", err)
+		} else if previous != nil && previous.ChargeID != trx.TelegramPaymentChargeID {
+			if err := client.EditUserStarSubscription(previous.TelegramUserID, previous.ChargeID, true); err != nil {
+				log.Printf("[ERROR] Не удалось отменить прежнюю подписку %d на VPN #%d: %v", previous.ID, trx.VPNConnectionID, err)
+			}
+		}
+	}
+	sub, err := p.subscriptionService.RecordPayment(&services.StarSubscription{
+		TelegramUserID:  trx.TelegramUserID,
+		VPNConnectionID: trx.VPNConnectionID,
+		PlanID:          trx.PlanID,
+		ChargeID:        trx.TelegramPaymentChargeID,
+		PriceStars:      trx.Amount,
+		ExpiresAt:       time.Unix(sp.SubscriptionExpirationDate, 0),
+	}, first)
+	if err != nil {
+		log.Printf("[ERROR] Платеж %d: %v", trx.ID, err)
+		return
+	}
+	log.Printf("[recordSubscriptionPayment] Подписка %d на VPN #%d оплачена до %s (платеж %d)",
+		sub.ID, sub.VPNConnectionID, sub.ExpiresAt.Format("02.01.2006 15:04"), trx.ID)
+}
+
+// cancelConnectionSubscription отменяет продление подписки подключения в Telegram,
+// чтобы за удаленный или недоступный VPN больше не списывалась оплата.
+// Возвращает true, если подписка была отменена.
+func (p *MessageProcessor) cancelConnectionSubscription(client *TelegramClient, connectionID int) bool {
+	sub, err := p.subscriptionService.GetRenewingByConnection(connectionID)
+	if err != nil {
+		log.Printf("[cancelConnectionSubscription] %v", err)
+		return false
+	}
+	if sub == nil {
+		return false
+	}
+	if err := p.setSubscriptionCancelled(client, sub, true); err != nil {
+		log.Printf("[ERROR] Не удалось отменить подписку %d на VPN #%d: %v", sub.ID, connectionID, err)
+		return false
+	}
+	log.Printf("[cancelConnectionSubscription] Подписка %d на VPN #%d отменена", sub.ID, connectionID)
+	return true
+}
+
+// setSubscriptionCancelled отменяет или возобновляет продление подписки в Telegram и в БД
+func (p *MessageProcessor) setSubscriptionCancelled(client *TelegramClient, sub *services.StarSubscription, cancelled bool) error {
+	if err := client.EditUserStarSubscription(sub.TelegramUserID, sub.ChargeID, cancelled); err != nil {
+		return err
+	}
+	return p.subscriptionService.SetCancelled(sub.ID, cancelled)
+}
+
+// handleSubscriptionsCommand показывает подписки пользователя: /subscriptions
+func (p *MessageProcessor) handleSubscriptionsCommand(client *TelegramClient, update Update) error {
+	userID := int64(update.Message.From.ID)
+	return p.sendSubscriptions(client, update.Message.Chat.ID, userID)
+}
+
+// sendSubscriptions отправляет список подписок с кнопками отмены и возобновления
+func (p *MessageProcessor) sendSubscriptions(client *TelegramClient, chatID int, userID int64) error {
+	subs, err := p.subscriptionService.GetUserSubscriptions(userID)
+	if err != nil {
+		log.Printf("[sendSubscriptions] %v", err)
+		return p.sendErrorMessage(client, chatID, "Ошибка получения подписок")
+	}
+	if len(subs) == 0 {
+		return p.sendMessageHTML(client, chatID, fmt.Sprintf("🔁 У вас нет подписок. Оформить подписку можно при продлении VPN "+
+			"по тарифу на %d дн. в /vpn.", services.SubscriptionPeriodDays))
+	}
+
+	var sb strings.Builder
+	sb.WriteString("🔁 <b>Ваши подписки</b>\n\n")
+	var keyboard [][]InlineKeyboardButton
+	for _, sub := range subs {
+		date := sub.ExpiresAt.Format("02.01.2006")
+		if sub.IsRenewing() {
+			sb.WriteString(fmt.Sprintf("VPN #%d — %d ⭐ / %d дн., следующее списание %s\n", sub.VPNConnectionID, sub.PriceStars, services.SubscriptionPeriodDays, date))
+			keyboard = append(keyboard, []InlineKeyboardButton{{Text: fmt.Sprintf("❌ Отменить подписку на VPN #%d", sub.VPNConnectionID), CallbackData: fmt.Sprintf("sub_cancel_%d", sub.ID)}})
+		} else {
+			sb.WriteString(fmt.Sprintf("VPN #%d — отменена, оплачено до %s\n", sub.VPNConnectionID, date))
+			keyboard = append(keyboard, []InlineKeyboardButton{{Text: fmt.Sprintf("▶️ Возобновить подписку на VPN #%d", sub.VPNConnectionID), CallbackData: fmt.Sprintf("sub_resume_%d", sub.ID)}})
+		}
+	}
+	return p.sendMessageWithKeyboard(client, chatID, sb.String(), &InlineKeyboardMarkup{InlineKeyboard: keyboard})
+}
+
+// handleSubscriptionCallback отменяет (sub_cancel_<ID>) или возобновляет (sub_resume_<ID>) подписку
+func (p *MessageProcessor) handleSubscriptionCallback(client *TelegramClient, update Update) error {
+	userID := int64(update.CallbackQuery.From.ID)
+	chatID := int(userID)
+	data := update.CallbackQuery.Data
+
+	var subID int
+	cancel := strings.HasPrefix(data, "sub_cancel_")
+	format := "sub_resume_%d"
+	if cancel {
+		format = "sub_cancel_%d"
+	}
+	if _, err := fmt.Sscanf(data, format, &subID); err != nil {
+		return p.sendErrorMessage(client, chatID, "Неверный формат callback данных")
+	}
+	sub, err := p.subscriptionService.GetSubscriptionByID(subID)
+	if err != nil {
+		log.Printf("[handleSubscriptionCallback] %v", err)
+		return p.sendErrorMessage(client, chatID, "Ошибка получения подписки")
+	}
+	if sub == nil || sub.TelegramUserID != userID || !sub.ExpiresAt.After(time.Now()) {
+		return p.sendErrorMessage(client, chatID, "Подписка не найдена или уже закончилась")
+	}
+	if !cancel {
+		vpn, err := p.vpnConnectionService.GetVPNConnectionByID(sub.VPNConnectionID)
+		if err != nil || vpn == nil || !(vpn.IsActive || vpn.IsExpired()) || vpn.RemovalPending {
+			return p.sendErrorMessage(client, chatID, "VPN подключение подписки удалено, возобновить ее нельзя")
+		}
+	}
+
+	if err := p.setSubscriptionCancelled(client, sub, cancel); err != nil {
+		log.Printf("[handleSubscriptionCallback] Подписка %d: %v", sub.ID, err)
+		return p.sendErrorMessage(client, chatID, "Не удалось изменить подписку, попробуйте позже")
+	}
+	if cancel {
+		log.Printf("[handleSubscriptionCallback] Пользователь %d отменил подписку %d", userID, sub.ID)
+		return p.sendMessageHTML(client, chatID, fmt.Sprintf("❌ Подписка на VPN #%d отменена. VPN действует до конца оплаченного срока, "+
+			"списаний больше не будет.", sub.VPNConnectionID))
+	}
+	log.Printf("[handleSubscriptionCallback] Пользователь %d возобновил подписку %d", userID, sub.ID)
+	return p.sendMessageHTML(client, chatID, fmt.Sprintf("▶️ Подписка на VPN #%d возобновлена, следующее списание %s.",
+		sub.VPNConnectionID, sub.ExpiresAt.Format("02.01.2006")))
+}
@@ -0,0 +1,279 @@
+package telegram
+
+import (
+	"TelegramXUI/internal/services"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// transactionsPageSize количество транзакций на странице /transactions
+const transactionsPageSize = 10
+
+// transactionsUsage формат аргументов /transactions
+const transactionsUsage = "/transactions [user_id] [страница] [type=payment|refund] [status=...] [from=ДД.ММ.ГГГГ] [to=ДД.ММ.ГГГГ]"
+
+// transactionsCallbackDate формат дат фильтра в callback_data кнопок страниц
+const transactionsCallbackDate = "20060102"
+
+// transactionTypes и transactionStatuses допустимые значения фильтров /transactions
+var (
+	transactionTypes = map[string]bool{
+		services.TransactionTypePayment: true,
+		services.TransactionTypeRefund:  true,
+		"duplicate":                     true,
+	}
+	transactionStatuses = map[string]bool{
+		services.TransactionStatusPending:      true,
+		services.TransactionStatusProvisioning: true,
+		services.TransactionStatusFulfilled:    true,
+		services.TransactionStatusRefunded:     true,
+		services.TransactionStatusFailed:       true,
+		services.TransactionStatusSuccess:      true,
+	}
+)
+
+// handleTransactionsCommand показывает платежи вместе с возвратами по ним:
+// /transactions [user_id] [страница] [type=...] [status=...] [from=...] [to=...]
+func (p *MessageProcessor) handleTransactionsCommand(client *TelegramClient, update Update, args []string) error {
+	chatID := update.Message.Chat.ID
+	if !p.adminService.IsGlobalAdmin(int64(update.Message.From.ID)) {
+		return p.sendMessage(client, chatID, "❌ Только глобальные администраторы могут просматривать транзакции.")
+	}
+
+	filter, page, err := parseTransactionsArgs(args)
+	if err != nil {
+		return p.sendMessageHTML(client, chatID, "❌ "+err.Error()+"\nИспользование: <code>"+transactionsUsage+"</code>")
+	}
+	return p.sendTransactionsPage(client, chatID, filter, page)
+}
+
+// handleTransactionsPageCallback переключает страницу /transactions: txp_<страница>_<user>_<type>_<status>_<from>_<to>
+func (p *MessageProcessor) handleTransactionsPageCallback(client *TelegramClient, update Update) error {
+	chatID := int(update.CallbackQuery.From.ID)
+	if !p.adminService.IsGlobalAdmin(int64(update.CallbackQuery.From.ID)) {
+		return p.sendErrorMessage(client, chatID, "Нет прав для просмотра транзакций")
+	}
+
+	filter, page, err := parseTransactionsPageCallback(update.CallbackQuery.Data)
+	if err != nil {
+		return p.sendErrorMessage(client, chatID, err.Error())
+	}
+	return p.sendTransactionsPage(client, chatID, filter, page)
+}
+
+// sendTransactionsPage отправляет страницу транзакций с кнопками возврата и навигации
+func (p *MessageProcessor) sendTransactionsPage(client *TelegramClient, chatID int, filter services.TransactionFilter, page int) error {
+	transactions, total, err := p.transactionService.GetTransactions(filter, transactionsPageSize, (page-1)*transactionsPageSize)
+	if err != nil {
+		return p.sendErrorMessage(client, chatID, "Ошибка получения транзакций")
+	}
+	if total == 0 {
+		return p.sendMessageHTML(client, chatID, "ℹ️ <b>Транзакций не найдено</b>")
+	}
+	pages := (total + transactionsPageSize - 1) / transactionsPageSize
+	if len(transactions) == 0 {
+		return p.sendMessageHTML(client, chatID, fmt.Sprintf("ℹ️ Страницы %d нет, всего страниц: %d", page, pages))
+	}
+
+	// Возвраты выводятся под своим платежом, если он есть на странице
+	listed := make(map[int]bool)
+	for _, tx := range transactions {
+		if tx.Type != services.TransactionTypeRefund {
+			listed[tx.ID] = true
+		}
+	}
+	refunds := make(map[int][]*services.Transaction)
+	for _, tx := range transactions {
+		if tx.Type == services.TransactionTypeRefund && listed[tx.ParentTransactionID] {
+			refunds[tx.ParentTransactionID] = append(refunds[tx.ParentTransactionID], tx)
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString("<b>Транзакции")
+	if filter.TelegramUserID != 0 {
+		sb.WriteString(fmt.Sprintf(" пользователя <code>%d</code>", filter.TelegramUserID))
+	}
+	sb.WriteString(fmt.Sprintf("</b> (стр. %d из %d, всего %d)\n\n", page, pages, total))
+	var keyboard [][]InlineKeyboardButton
+	for _, tx := range transactions {
+		if tx.Type == services.TransactionTypeRefund && listed[tx.ParentTransactionID] {
+			continue
+		}
+		sb.WriteString(formatTransaction(tx))
+		for _, refund := range refunds[tx.ID] {
+			sb.WriteString(fmt.Sprintf("  ↩️ Возврат ID <code>%d</code> | %s | <b>%s</b>\n  %s\n",
+				refund.ID, refund.CreatedAt.Format("02.01.06 15:04"), refund.Status, refund.Reason))
+		}
+		sb.WriteString("---\n")
+		if tx.Type == services.TransactionTypePayment && tx.Status == services.TransactionStatusFulfilled {
+			keyboard = append(keyboard, []InlineKeyboardButton{{
+				Text:         fmt.Sprintf("↩️ Возврат средств (ID %d)", tx.ID),
+				CallbackData: fmt.Sprintf("refund_%d", tx.ID),
+			}})
+		}
+	}
+
+	var navigation []InlineKeyboardButton
+	if page > 1 {
+		navigation = append(navigation, InlineKeyboardButton{
+			Text:         "⬅️ Назад",
+			CallbackData: transactionsPageCallback(filter, page-1),
+		})
+	}
+	if page < pages {
+		navigation = append(navigation, InlineKeyboardButton{
+			Text:         "Вперед ➡️",
+			CallbackData: transactionsPageCallback(filter, page+1),
+		})
+	}
+	if len(navigation) > 0 {
+		keyboard = append(keyboard, navigation)
+	}
+
+	inlineKeyboard := &InlineKeyboardMarkup{InlineKeyboard: keyboard}
+	_, err = client.SendMessageWithKeyboard(chatID, sb.String(), inlineKeyboard)
+	return err
+}
+
+// formatTransaction форматирует транзакцию для списка администратора
+func formatTransaction(tx *services.Transaction) string {
+	text := fmt.Sprintf("ID: <code>%d</code> | User: <code>%d</code> | %s\nСумма: <b>%d</b> | Тип: <b>%s</b> | Статус: <b>%s</b>\n",
+		tx.ID, tx.TelegramUserID, tx.CreatedAt.Format("02.01.06 15:04"), tx.Amount, tx.Type, tx.Status)
+	if tx.VPNConnectionID != 0 {
+		text += fmt.Sprintf("VPN: <code>#%d</code>", tx.VPNConnectionID)
+		if tx.ParentTransactionID != 0 {
+			text += fmt.Sprintf(" | Платеж: <code>%d</code>", tx.ParentTransactionID)
+		}
+		text += "\n"
+	} else if tx.ParentTransactionID != 0 {
+		text += fmt.Sprintf("Платеж: <code>%d</code>\n", tx.ParentTransactionID)
+	}
+	if tx.PromoCodeID != 0 {
+		text += fmt.Sprintf("Промокод: <code>%d</code>\n", tx.PromoCodeID)
+	}
+	if tx.PaymentMethod == services.PaymentMethodBalance {
+		text += "Оплата: с баланса\n"
+	}
+	text += fmt.Sprintf("Причина: %s\n", tx.Reason)
+	if tx.Attempts > 1 {
+		text += fmt.Sprintf("Попыток: %d\n", tx.Attempts)
+	}
+	if tx.LastError != "" {
+		text += fmt.Sprintf("Ошибка: %s\n", html.EscapeString(tx.LastError))
+	}
+	return text
+}
+
+// parseTransactionsArgs разбирает аргументы /transactions: первое число — ID пользователя
+// (0 — все пользователи), второе — номер страницы, остальные — фильтры key=value
+func parseTransactionsArgs(args []string) (services.TransactionFilter, int, error) {
+	var filter services.TransactionFilter
+	page := 1
+	numbers := 0
+	for _, arg := range args {
+		key, value, isFilter := strings.Cut(arg, "=")
+		if !isFilter {
+			n, err := strconv.ParseInt(arg, 10, 64)
+			if err != nil || n < 0 {
+				return filter, 0, fmt.Errorf("неверный аргумент: %s", arg)
+			}
+			switch numbers {
+			case 0:
+				filter.TelegramUserID = n
+			case 1:
+				if n == 0 {
+					return filter, 0, fmt.Errorf("страницы нумеруются с 1")
+				}
+				page = int(n)
+			default:
+				return filter, 0, fmt.Errorf("лишний аргумент: %s", arg)
+			}
+			numbers++
+			continue
+		}
+
+		switch key {
+		case "type":
+			if !transactionTypes[value] {
+				return filter, 0, fmt.Errorf("неизвестный тип транзакции: %s", value)
+			}
+			filter.Type = value
+		case "status":
+			if !transactionStatuses[value] {
+				return filter, 0, fmt.Errorf("неизвестный статус транзакции: %s", value)
+			}
+			filter.Status = value
+		case "from", "to":
+			date, err := time.ParseInLocation("02.01.2006", value, time.Local)
+			if err != nil {
+				return filter, 0, fmt.Errorf("неверная дата %s, нужен формат ДД.ММ.ГГГГ", value)
+			}
+			if key == "from" {
+				filter.From = &date
+			} else {
+				// Дата окончания включается в выборку целиком
+				end := date.AddDate(0, 0, 1)
+				filter.To = &end
+			}
+		default:
+			return filter, 0, fmt.Errorf("неизвестный фильтр: %s", key)
+		}
+	}
+	return filter, page, nil
+}
+
+// transactionsPageCallback формирует callback_data кнопки страницы (не длиннее 64 байт)
+func transactionsPageCallback(filter services.TransactionFilter, page int) string {
+	orDash := func(value string) string {
+		if value == "" {
+			return "-"
+		}
+		return value
+	}
+	formatDate := func(date *time.Time) string {
+		if date == nil {
+			return "-"
+		}
+		return date.Format(transactionsCallbackDate)
+	}
+	return fmt.Sprintf("txp_%d_%d_%s_%s_%s_%s", page, filter.TelegramUserID,
+		orDash(filter.Type), orDash(filter.Status), formatDate(filter.From), formatDate(filter.To))
+}
+
+// parseTransactionsPageCallback разбирает callback_data, сформированный transactionsPageCallback
+func parseTransactionsPageCallback(data string) (services.TransactionFilter, int, error) {
+	var filter services.TransactionFilter
+	parts := strings.Split(strings.TrimPrefix(data, "txp_"), "_")
+	if len(parts) != 6 {
+		return filter, 0, fmt.Errorf("неверный формат callback страницы транзакций")
+	}
+
+	page, err := strconv.Atoi(parts[0])
+	if err != nil || page < 1 {
+		return filter, 0, fmt.Errorf("неверный номер страницы")
+	}
+	if filter.TelegramUserID, err = strconv.ParseInt(parts[1], 10, 64); err != nil {
+		return filter, 0, fmt.Errorf("неверный ID пользователя")
+	}
+	if parts[2] != "-" {
+		filter.Type = parts[2]
+	}
+	if parts[3] != "-" {
+		filter.Status = parts[3]
+	}
+	for i, date := range []**time.Time{&filter.From, &filter.To} {
+		if parts[4+i] == "-" {
+			continue
+		}
+		parsed, err := time.ParseInLocation(transactionsCallbackDate, parts[4+i], time.Local)
+		if err != nil {
+			return filter, 0, fmt.Errorf("неверная дата в callback страницы транзакций")
+		}
+		*date = &parsed
+	}
+	return filter, page, nil
+}
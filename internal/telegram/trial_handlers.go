@@ -0,0 +1,112 @@
+package telegram
+
+import (
+	"TelegramXUI/internal/services"
+	"fmt"
+	"log"
+)
+
+// trialCallbackData callback кнопки «Попробовать бесплатно»
+const trialCallbackData = "trial_claim"
+
+// trialPlan возвращает условия пробного VPN из конфигурации, nil — пробный период отключен
+func (p *MessageProcessor) trialPlan() *services.Plan {
+	trial := p.config.Trial
+	if trial.DurationDays <= 0 {
+		return nil
+	}
+	return &services.Plan{
+		Name:         "Пробный период",
+		DurationDays: trial.DurationDays,
+		TrafficGB:    trial.TrafficGB,
+		LimitIP:      trial.LimitIP,
+	}
+}
+
+// trialButton возвращает кнопку пробного периода, если пользователь может его получить
+func (p *MessageProcessor) trialButton(userID int64) []InlineKeyboardButton {
+	plan := p.trialPlan()
+	if plan == nil {
+		return nil
+	}
+	available, err := p.trialService.IsAvailable(userID)
+	if err != nil {
+		log.Printf("[trialButton] %v", err)
+		return nil
+	}
+	if !available {
+		return nil
+	}
+	return []InlineKeyboardButton{{
+		Text:         fmt.Sprintf("🎁 Попробовать бесплатно (%d дн.)", plan.DurationDays),
+		CallbackData: trialCallbackData,
+	}}
+}
+
+// handleTrialCallback выдает пробный VPN без оплаты. Пробный период закрепляется за Telegram ID
+// до создания VPN, поэтому повторное нажатие не создаст второй VPN; если создать VPN
+// не удалось, закрепление снимается.
+func (p *MessageProcessor) handleTrialCallback(client *TelegramClient, update Update) error {
+	from := update.CallbackQuery.From
+	userID := int64(from.ID)
+	chatID := int(userID)
+
+	plan := p.trialPlan()
+	if plan == nil {
+		return p.sendErrorMessage(client, chatID, "Пробный период сейчас недоступен")
+	}
+	if _, err := p.userService.EnsureUserExists(from); err != nil {
+		log.Printf("[handleTrialCallback] %v", err)
+		return p.sendErrorMessage(client, chatID, "Не удалось проверить ваш аккаунт, попробуйте позже")
+	}
+	allowed, reason, err := p.userStateService.CanUserPerformAction(userID)
+	if err != nil {
+		log.Printf("[handleTrialCallback] Ошибка проверки состояния пользователя %d: %v", userID, err)
+		return p.sendErrorMessage(client, chatID, "Не удалось проверить ваш аккаунт, попробуйте позже")
+	}
+	if !allowed {
+		return p.sendErrorMessage(client, chatID, "Пробный период недоступен: "+reason)
+	}
+
+	claimed, err := p.trialService.Claim(userID)
+	if err != nil {
+		log.Printf("[handleTrialCallback] %v", err)
+		return p.sendErrorMessage(client, chatID, "Не удалось оформить пробный период, попробуйте позже")
+	}
+	if !claimed {
+		return p.sendErrorMessage(client, chatID, "Пробный период можно получить только один раз. Выберите тариф в /vpn.")
+	}
+	log.Printf("[handleTrialCallback] Пользователь %d получает пробный VPN", userID)
+
+	_ = p.sendMessageHTML(client, chatID, "🎁 Создаём пробный VPN...")
+	connectionID, err := p.createVPNAndSendInfo(client, chatID, userID, "", plan, "")
+	if err != nil {
+		log.Printf("[ERROR] Не удалось создать пробный VPN пользователю %d: %v", userID, err)
+		if err := p.trialService.Release(userID); err != nil {
+			log.Printf("[ERROR] %v", err)
+		}
+		return p.sendErrorMessage(client, chatID, "Не удалось создать пробный VPN, попробуйте позже")
+	}
+
+	expiresAt, err := p.trialService.Activate(userID, connectionID)
+	if err != nil {
+		// VPN уже выдан, закрепление остается и блокирует повторное получение
+		log.Printf("[ERROR] Пробный VPN #%d пользователя %d: %v", connectionID, userID, err)
+		return nil
+	}
+	log.Printf("[handleTrialCallback] Пользователь %d переведен в состояние trial до %s", userID, services.FormatExpiry(expiresAt))
+	return p.sendMessageHTML(client, chatID, fmt.Sprintf("🎁 Пробный период на <b>%d дн.</b> активирован. "+
+		"Когда он закончится, продлите VPN по тарифу в /vpn — ссылка для подключения не изменится.", plan.DurationDays))
+}
+
+// convertTrial возвращает пользователя с пробного периода в active после первой оплаты
+func (p *MessageProcessor) convertTrial(trx *services.Transaction) {
+	converted, err := p.trialService.Convert(trx.TelegramUserID, trx.ID)
+	if err != nil {
+		log.Printf("[ERROR] Платеж %d: %v", trx.ID, err)
+		return
+	}
+	if converted {
+		log.Printf("[convertTrial] Пользователь %d оплатил после пробного периода (платеж %d)", trx.TelegramUserID, trx.ID)
+	}
+}
@@ -2,6 +2,7 @@ package telegram
 
 import (
 	"log"
+	"strings"
 )
 
 // sendMessage отправляет обычное сообщение
@@ -28,6 +29,19 @@ func (p *MessageProcessor) sendErrorMessage(client *TelegramClient, chatID int,
 	return p.sendMessageHTML(client, chatID, errorMessage)
 }
 
+// parseCommand разделяет текст команды на саму команду (без @имя_бота) и аргументы
+func parseCommand(text string) (string, []string) {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return "", nil
+	}
+	command := fields[0]
+	if idx := strings.Index(command, "@"); idx != -1 {
+		command = command[:idx]
+	}
+	return command, fields[1:]
+}
+
 // makeCreateVPNButton возвращает inline-клавиатуру с кнопкой "Создать VPN"
 func makeCreateVPNButton() *InlineKeyboardMarkup {
 	return &InlineKeyboardMarkup{
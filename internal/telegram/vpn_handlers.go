@@ -1,7 +1,10 @@
 package telegram
 
 import (
+	"TelegramXUI/internal/services"
 	"fmt"
+	"log"
+	"strconv"
 	"strings"
 )
 
@@ -10,8 +13,14 @@ func (p *MessageProcessor) handleCallbackVPN(client *TelegramClient, update Upda
 	data := update.CallbackQuery.Data
 	if data == "create_vpn" {
 		return p.handleCreateVPNCallback(client, update)
+	} else if strings.HasPrefix(data, "vpn_plan_") {
+		return p.handleVPNPlanCallback(client, update)
+	} else if strings.HasPrefix(data, "vpn_loc_") {
+		return p.handleVPNLocationCallback(client, update)
 	} else if strings.HasPrefix(data, "vpn_info_") {
 		return p.handleVPNInfoCallback(client, update)
+	} else if strings.HasPrefix(data, "vpn_renew_") {
+		return p.handleVPNRenewCallback(client, update)
 	} else if strings.HasPrefix(data, "vpn_delete_") {
 		return p.handleVPNDeleteCallback(client, update)
 	} else if data == "vpn_refresh" {
@@ -20,17 +29,212 @@ func (p *MessageProcessor) handleCallbackVPN(client *TelegramClient, update Upda
 	return nil
 }
 
-// handleCreateVPNCallback - обработка callback для создания VPN
+// handleCreateVPNCallback - обработка callback для создания VPN: показываем активные тарифы
 func (p *MessageProcessor) handleCreateVPNCallback(client *TelegramClient, update Update) error {
 	chatID := int(update.CallbackQuery.From.ID)
-	title := "Создание VPN-подключения"
-	description := "Оплата за создание VPN через Telegram Stars"
-	payload := fmt.Sprintf("vpn_create_%d", update.CallbackQuery.From.ID)
+	plans, err := p.planService.GetActivePlans()
+	if err != nil {
+		log.Printf("[handleCreateVPNCallback] %v", err)
+		return p.sendErrorMessage(client, chatID, "Ошибка получения списка тарифов")
+	}
+	if len(plans) == 0 {
+		return p.sendMessageHTML(client, chatID, "❌ Сейчас нет доступных тарифов. Обратитесь к администратору.")
+	}
+
+	promo := p.activatedPromo(int64(chatID))
+	var sb strings.Builder
+	sb.WriteString("💳 <b>Выберите тариф</b>\n\n")
+	var keyboard [][]InlineKeyboardButton
+	if trial := p.trialButton(int64(chatID)); trial != nil {
+		keyboard = append(keyboard, trial)
+	}
+	for _, plan := range plans {
+		sb.WriteString(formatPlanOffer(plan, promo))
+		keyboard = append(keyboard, []InlineKeyboardButton{{
+			Text:         planButtonText(plan, promo),
+			CallbackData: fmt.Sprintf("vpn_plan_%d", plan.ID),
+		}})
+	}
+	sb.WriteString("\n" + promoLine(promo))
+
+	_, err = client.SendMessageWithKeyboard(chatID, sb.String(), &InlineKeyboardMarkup{InlineKeyboard: keyboard})
+	return err
+}
+
+// handleVPNPlanCallback - выбор тарифа (vpn_plan_<ID тарифа>): если активные хосты
+// тарифа есть в нескольких локациях, сначала предлагаем выбрать локацию
+func (p *MessageProcessor) handleVPNPlanCallback(client *TelegramClient, update Update) error {
+	userID := int64(update.CallbackQuery.From.ID)
+	var planID int
+	if _, err := fmt.Sscanf(update.CallbackQuery.Data, "vpn_plan_%d", &planID); err != nil {
+		return p.sendErrorMessage(client, int(userID), "Неверный формат callback данных")
+	}
+	plan, err := p.getActivePlan(planID)
+	if err != nil {
+		return p.sendErrorMessage(client, int(userID), err.Error())
+	}
+
+	servers, err := p.xuiServerService.GetActiveServers()
+	if err != nil {
+		return p.sendErrorMessage(client, int(userID), "Ошибка получения списка серверов")
+	}
+	var planServers []*services.XUIServer
+	for _, server := range servers {
+		if plan.AllowsLocation(server.ServerLocation) {
+			planServers = append(planServers, server)
+		}
+	}
+	if len(planServers) == 0 {
+		return p.sendMessageHTML(client, int(userID), "❌ Нет доступных XUI хостов для этого тарифа. Выберите другой тариф или обратитесь к администратору.")
+	}
+
+	locations := activeLocations(planServers)
+	if len(locations) <= 1 {
+		location, serverID := "", 0
+		if len(locations) == 1 {
+			location, serverID = locations[0].name, locations[0].serverID
+		}
+		return p.sendCreateVPNInvoice(client, int(userID), userID, plan, location, serverID)
+	}
+
+	var keyboard [][]InlineKeyboardButton
+	for _, loc := range locations {
+		keyboard = append(keyboard, []InlineKeyboardButton{{
+			Text:         "📍 " + loc.name,
+			CallbackData: fmt.Sprintf("vpn_loc_%d_%d", plan.ID, loc.serverID),
+		}})
+	}
+	keyboard = append(keyboard, []InlineKeyboardButton{{Text: "🌍 Любая локация", CallbackData: fmt.Sprintf("vpn_loc_%d_0", plan.ID)}})
+
+	_, err = client.SendMessageWithKeyboard(int(userID), "🌍 <b>Выберите локацию сервера</b>", &InlineKeyboardMarkup{InlineKeyboard: keyboard})
+	return err
+}
+
+// handleVPNLocationCallback - выбор локации перед оплатой (vpn_loc_<ID тарифа>_<ID сервера локации>)
+func (p *MessageProcessor) handleVPNLocationCallback(client *TelegramClient, update Update) error {
+	userID := int64(update.CallbackQuery.From.ID)
+	var planID, serverID int
+	if _, err := fmt.Sscanf(update.CallbackQuery.Data, "vpn_loc_%d_%d", &planID, &serverID); err != nil {
+		return p.sendErrorMessage(client, int(userID), "Неверный формат callback данных")
+	}
+	plan, err := p.getActivePlan(planID)
+	if err != nil {
+		return p.sendErrorMessage(client, int(userID), err.Error())
+	}
+
+	location, err := p.planLocation(plan, serverID)
+	if err != nil {
+		return p.sendErrorMessage(client, int(userID), err.Error())
+	}
+	return p.sendCreateVPNInvoice(client, int(userID), userID, plan, location, serverID)
+}
+
+// planLocation возвращает локацию сервера serverID, если она доступна в тарифе;
+// serverID == 0 — любая локация
+func (p *MessageProcessor) planLocation(plan *services.Plan, serverID int) (string, error) {
+	if serverID == 0 {
+		return "", nil
+	}
+	server, err := p.xuiServerService.GetServerByID(serverID)
+	if err != nil || server == nil || !server.IsActive || !plan.AllowsLocation(server.ServerLocation) {
+		return "", fmt.Errorf("Локация больше недоступна, выберите другую")
+	}
+	return server.ServerLocation, nil
+}
+
+// getActivePlan возвращает тариф, доступный для покупки, или ошибку с текстом для пользователя
+func (p *MessageProcessor) getActivePlan(planID int) (*services.Plan, error) {
+	plan, err := p.planService.GetPlanByID(planID)
+	if err != nil {
+		log.Printf("[getActivePlan] %v", err)
+		return nil, fmt.Errorf("Ошибка получения тарифа")
+	}
+	if plan == nil || !plan.IsActive {
+		return nil, fmt.Errorf("Тариф больше недоступен, выберите другой")
+	}
+	return plan, nil
+}
+
+// vpnOrder покупка или продление VPN: payload счета и сумма с учетом промокода
+type vpnOrder struct {
+	payload *services.InvoicePayload
+	price   int
+	promo   *services.PromoCode
+}
+
+// newVPNOrder применяет к заказу промокод, введенный пользователем
+func (p *MessageProcessor) newVPNOrder(payload *services.InvoicePayload, plan *services.Plan) *vpnOrder {
+	price, promo := planPrice(plan, p.activatedPromo(payload.UserID))
+	payload.PromoCodeID = promoID(promo)
+	return &vpnOrder{payload: payload, price: price, promo: promo}
+}
+
+// createVPNOrder заказ на создание VPN по тарифу в локации сервера serverID (0 — любая локация)
+func (p *MessageProcessor) createVPNOrder(userID int64, plan *services.Plan, serverID int) *vpnOrder {
+	return p.newVPNOrder(&services.InvoicePayload{
+		Action:      services.PayloadActionCreateVPN,
+		UserID:      userID,
+		PlanID:      plan.ID,
+		PlanVersion: plan.Version,
+		ServerID:    serverID,
+	}, plan)
+}
+
+// renewVPNOrder заказ на продление подключения по тарифу
+func (p *MessageProcessor) renewVPNOrder(vpn *services.VPNConnection, plan *services.Plan) *vpnOrder {
+	return p.newVPNOrder(&services.InvoicePayload{
+		Action:       services.PayloadActionRenewVPN,
+		UserID:       vpn.TelegramUserID,
+		PlanID:       plan.ID,
+		PlanVersion:  plan.Version,
+		ConnectionID: vpn.ID,
+	}, plan)
+}
+
+// sendCreateVPNInvoice выставляет счет на создание VPN по тарифу в выбранной локации.
+// serverID — сервер выбранной локации (0 — любая), по нему локация определяется при оплате.
+func (p *MessageProcessor) sendCreateVPNInvoice(client *TelegramClient, chatID int, userID int64, plan *services.Plan, location string, serverID int) error {
+	order := p.createVPNOrder(userID, plan, serverID)
+	title := plan.Name
+	description := services.FormatPlan(plan)
+	if location != "" {
+		description += ". Локация: " + location
+	}
+	description += promoInvoiceNote(order.promo)
+	payload, err := order.payload.Encode()
+	if err != nil {
+		log.Printf("[sendCreateVPNInvoice] %v", err)
+		return p.sendErrorMessage(client, chatID, "Не удалось сформировать счет")
+	}
 	providerToken := "" // Для Stars provider_token пустой
 	currency := "XTR"
-	prices := []LabeledPrice{{Label: "VPN", Amount: 1}}
+	prices := []LabeledPrice{{Label: plan.Name, Amount: order.price}}
 	isTest := false
-	return client.SendInvoice(chatID, title, description, payload, providerToken, currency, prices, isTest)
+	if err := client.SendInvoice(chatID, title, description, payload, providerToken, currency, prices, isTest); err != nil {
+		return err
+	}
+	return p.offerBalancePayment(client, chatID, userID, order.price, fmt.Sprintf("bal_c_%d_%d", plan.ID, serverID))
+}
+
+// locationOption локация и сервер, по которому ее можно однозначно найти в callback
+type locationOption struct {
+	name     string
+	serverID int
+}
+
+// activeLocations возвращает уникальные непустые локации активных серверов в порядке появления
+func activeLocations(servers []*services.XUIServer) []locationOption {
+	seen := make(map[string]bool)
+	var locations []locationOption
+	for _, server := range servers {
+		key := strings.ToLower(strings.TrimSpace(server.ServerLocation))
+		if key == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		locations = append(locations, locationOption{name: server.ServerLocation, serverID: server.ID})
+	}
+	return locations
 }
 
 // handleVPNInfoCallback - обработка callback для информации о VPN
@@ -47,31 +251,179 @@ func (p *MessageProcessor) handleVPNInfoCallback(client *TelegramClient, update
 		return p.sendErrorMessage(client, int(update.CallbackQuery.From.ID), "VPN подключение не найдено")
 	}
 	// Отправляем информацию
-	msg := fmt.Sprintf("🔒 <b>VPN #%d</b>\n📧 Email: <code>%s</code>\n🔌 Порт: <code>%d</code>\n🆔 Client ID: <code>%s</code>\n\n<code>%s</code>", vpn.ID, vpn.Email, vpn.Port, vpn.ClientID, vpn.VlessLink)
+	msg := fmt.Sprintf("🔒 <b>VPN #%d</b>\n📧 Email: <code>%s</code>\n🔌 Порт: <code>%d</code>\n🆔 Client ID: <code>%s</code>\n📊 Трафик: %s\n📱 Устройств: %s\n⏳ Действует до: %s\n\n<code>%s</code>",
+		vpn.ID, vpn.Email, vpn.Port, vpn.ClientID,
+		services.FormatTrafficLimit(vpn.TrafficLimitBytes), services.FormatLimitIP(vpn.LimitIP), services.FormatExpiry(vpn.ExpiresAt),
+		vpn.VlessLink)
 	return p.sendMessageHTML(client, int(update.CallbackQuery.From.ID), msg)
 }
 
 // handleVPNDeleteCallback - обработка callback для удаления VPN
 func (p *MessageProcessor) handleVPNDeleteCallback(client *TelegramClient, update Update) error {
+	userID := int64(update.CallbackQuery.From.ID)
 	var vpnID int
 	_, err := fmt.Sscanf(update.CallbackQuery.Data, "vpn_delete_%d", &vpnID)
 	if err != nil {
-		return p.sendErrorMessage(client, int(update.CallbackQuery.From.ID), "Неверный формат callback данных")
+		return p.sendErrorMessage(client, int(userID), "Неверный формат callback данных")
 	}
-	err = p.vpnConnectionService.DeactivateVPNConnection(vpnID)
-	if err != nil {
-		return p.sendErrorMessage(client, int(update.CallbackQuery.From.ID), "Ошибка удаления VPN: "+err.Error())
+	vpn, err := p.vpnConnectionService.GetVPNConnectionByID(vpnID)
+	if err != nil || vpn == nil || !(vpn.IsActive || vpn.IsExpired()) {
+		return p.sendErrorMessage(client, int(userID), "VPN подключение не найдено")
 	}
-	return p.sendMessageHTML(client, int(update.CallbackQuery.From.ID), "🗑️ VPN подключение удалено")
+	if vpn.TelegramUserID != userID && !p.adminService.IsGlobalAdmin(userID) {
+		return p.sendErrorMessage(client, int(userID), "Нет прав для удаления этого VPN подключения")
+	}
+	if p.cancelConnectionSubscription(client, vpn.ID) {
+		_ = p.sendMessageHTML(client, int(userID), fmt.Sprintf("🔁 Подписка на VPN #%d отменена.", vpn.ID))
+	}
+	if err := p.vpnRemovalService.RemoveVPNConnection(vpn); err != nil {
+		log.Printf("[handleVPNDeleteCallback] Ошибка удаления VPN %d: %v", vpnID, err)
+		return p.sendMessageHTML(client, int(userID), "⚠️ Не удалось удалить VPN на сервере. Удаление будет повторено, подключение скрыто из списка.")
+	}
+	return p.sendMessageHTML(client, int(userID), "🗑️ VPN подключение удалено")
 }
 
 // handleVPNRefreshCallback - обработка callback для обновления списка VPN
 func (p *MessageProcessor) handleVPNRefreshCallback(client *TelegramClient, update Update) error {
 	userID := int64(update.CallbackQuery.From.ID)
+	return p.sendVPNList(client, int(userID), userID)
+}
+
+// sendVPNList отправляет список активных VPN подключений пользователя с кнопками управления
+func (p *MessageProcessor) sendVPNList(client *TelegramClient, chatID int, userID int64) error {
 	connections, err := p.vpnConnectionService.GetUserVPNConnections(userID)
 	if err != nil {
-		return p.sendErrorMessage(client, int(userID), "Ошибка получения VPN подключений")
+		return p.sendErrorMessage(client, chatID, "Ошибка получения VPN подключений")
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("🔒 <b>Ваши VPN подключения (%d)</b>\n\n", len(connections)))
+	var keyboard [][]InlineKeyboardButton
+	for i, vpn := range connections {
+		status := ""
+		if vpn.IsExpired() {
+			status = " ⛔ истек"
+		}
+		sb.WriteString(fmt.Sprintf("%d. VPN #%d%s\n📧 Email: <code>%s</code>\n🔌 Порт: <code>%d</code>\n⏳ Действует до: %s\n📅 Создано: %s\n\n",
+			i+1, vpn.ID, status, vpn.Email, vpn.Port, services.FormatExpiry(vpn.ExpiresAt), vpn.CreatedAt.Format("02.01.2006 15:04")))
+		keyboard = append(keyboard, []InlineKeyboardButton{
+			{Text: fmt.Sprintf("ℹ️ VPN #%d", vpn.ID), CallbackData: fmt.Sprintf("vpn_info_%d", vpn.ID)},
+			{Text: "🔄 Продлить", CallbackData: fmt.Sprintf("vpn_renew_%d", vpn.ID)},
+			{Text: fmt.Sprintf("🗑️ Удалить #%d", vpn.ID), CallbackData: fmt.Sprintf("vpn_delete_%d", vpn.ID)},
+		})
+	}
+	if len(connections) > 0 {
+		sb.WriteString("💡 Для получения данных подключения нажмите на соответствующую кнопку ниже")
+	} else {
+		sb.WriteString("У вас пока нет VPN подключений")
+	}
+	keyboard = append(keyboard,
+		[]InlineKeyboardButton{{Text: "➕ Создать новое VPN", CallbackData: "create_vpn"}},
+		[]InlineKeyboardButton{{Text: "🔄 Обновить список", CallbackData: "vpn_refresh"}},
+	)
+
+	_, err = client.SendMessageWithKeyboard(chatID, sb.String(), &InlineKeyboardMarkup{InlineKeyboard: keyboard})
+	return err
+}
+
+// handleVPNRenewCallback - продление VPN: vpn_renew_<ID подключения> выставляет счет по тарифу
+// подключения; если тариф недоступен, предлагает выбрать тариф (vpn_renew_<ID подключения>_<ID тарифа>)
+func (p *MessageProcessor) handleVPNRenewCallback(client *TelegramClient, update Update) error {
+	userID := int64(update.CallbackQuery.From.ID)
+	parts := strings.Split(strings.TrimPrefix(update.CallbackQuery.Data, "vpn_renew_"), "_")
+	vpnID, err := strconv.Atoi(parts[0])
+	if err != nil || len(parts) > 2 {
+		return p.sendErrorMessage(client, int(userID), "Неверный формат callback данных")
+	}
+	vpn, err := p.vpnConnectionService.GetVPNConnectionByID(vpnID)
+	if err != nil || vpn == nil || !(vpn.IsActive || vpn.IsExpired()) || vpn.RemovalPending || vpn.TelegramUserID != userID {
+		return p.sendErrorMessage(client, int(userID), "VPN подключение не найдено")
+	}
+
+	location, err := p.connectionLocation(vpn)
+	if err != nil {
+		log.Printf("[handleVPNRenewCallback] %v", err)
+		return p.sendErrorMessage(client, int(userID), "Ошибка получения сервера подключения")
+	}
+
+	planID := vpn.PlanID
+	if len(parts) == 2 {
+		if planID, err = strconv.Atoi(parts[1]); err != nil {
+			return p.sendErrorMessage(client, int(userID), "Неверный формат callback данных")
+		}
+	}
+	if planID != 0 {
+		plan, err := p.getActivePlan(planID)
+		if err == nil && !plan.AllowsLocation(location) {
+			err = fmt.Errorf("Тариф недоступен в локации подключения, выберите другой")
+		}
+		if err == nil {
+			return p.sendRenewVPNInvoice(client, int(userID), vpn, plan)
+		} else if len(parts) == 2 {
+			return p.sendErrorMessage(client, int(userID), err.Error())
+		}
+	}
+
+	// Тариф подключения не задан или больше не продается — предлагаем выбрать другой
+	// из тарифов, доступных в локации подключения
+	activePlans, err := p.planService.GetActivePlans()
+	if err != nil {
+		log.Printf("[handleVPNRenewCallback] %v", err)
+		return p.sendErrorMessage(client, int(userID), "Ошибка получения списка тарифов")
+	}
+	var plans []*services.Plan
+	for _, plan := range activePlans {
+		if plan.AllowsLocation(location) {
+			plans = append(plans, plan)
+		}
+	}
+	if len(plans) == 0 {
+		return p.sendMessageHTML(client, int(userID), "❌ Сейчас нет доступных тарифов для локации этого подключения. Обратитесь к администратору.")
+	}
+	promo := p.activatedPromo(userID)
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("🔄 <b>Продление VPN #%d</b>\nВыберите тариф:\n\n", vpn.ID))
+	var keyboard [][]InlineKeyboardButton
+	for _, plan := range plans {
+		sb.WriteString(formatPlanOffer(plan, promo))
+		keyboard = append(keyboard, []InlineKeyboardButton{{
+			Text:         planButtonText(plan, promo),
+			CallbackData: fmt.Sprintf("vpn_renew_%d_%d", vpn.ID, plan.ID),
+		}})
+	}
+	sb.WriteString("\n" + promoLine(promo))
+	_, err = client.SendMessageWithKeyboard(int(userID), sb.String(), &InlineKeyboardMarkup{InlineKeyboard: keyboard})
+	return err
+}
+
+// connectionLocation возвращает локацию сервера подключения: продлить подключение можно
+// только тарифом, в котором эта локация доступна
+func (p *MessageProcessor) connectionLocation(vpn *services.VPNConnection) (string, error) {
+	server, err := p.xuiServerService.GetServerByID(vpn.ServerID)
+	if err != nil {
+		return "", err
+	}
+	if server == nil {
+		return "", fmt.Errorf("сервер %d подключения %d не найден", vpn.ServerID, vpn.ID)
+	}
+	return server.ServerLocation, nil
+}
+
+// sendRenewVPNInvoice выставляет счет на продление подключения по тарифу
+func (p *MessageProcessor) sendRenewVPNInvoice(client *TelegramClient, chatID int, vpn *services.VPNConnection, plan *services.Plan) error {
+	order := p.renewVPNOrder(vpn, plan)
+	title := plan.Name
+	description := fmt.Sprintf("Продление VPN #%d. %s", vpn.ID, services.FormatPlan(plan))
+	description += promoInvoiceNote(order.promo)
+	payload, err := order.payload.Encode()
+	if err != nil {
+		log.Printf("[sendRenewVPNInvoice] %v", err)
+		return p.sendErrorMessage(client, chatID, "Не удалось сформировать счет на продление")
+	}
+	prices := []LabeledPrice{{Label: plan.Name, Amount: order.price}}
+	if err := client.SendInvoice(chatID, title, description, payload, "", "XTR", prices, false); err != nil {
+		return err
 	}
-	msg := fmt.Sprintf("🔒 <b>Ваши VPN подключения (%d)</b>", len(connections))
-	return p.sendMessageHTML(client, int(userID), msg)
+	p.offerSubscription(client, chatID, vpn, plan)
+	return p.offerBalancePayment(client, chatID, vpn.TelegramUserID, order.price, fmt.Sprintf("bal_r_%d_%d", vpn.ID, plan.ID))
 }
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
@@ -261,3 +262,61 @@ func (c *Client) AddClientToInbound(form *AddClientForm) error {
 
 	return nil
 }
+
+// apiResponse общий формат ответа API панели 3x-ui
+type apiResponse struct {
+	Success bool            `json:"success"`
+	Msg     string          `json:"msg"`
+	Obj     json.RawMessage `json:"obj"`
+}
+
+// doAPIRequest выполняет авторизованный запрос к API панели и возвращает поле obj.
+// body может быть nil; для POST-запросов с телом используется form-urlencoded.
+func (c *Client) doAPIRequest(method, path string, body io.Reader) (json.RawMessage, error) {
+	fmt.Println("[xui_client] Запрос к API:", method, c.BaseURL+path)
+	req, err := http.NewRequest(method, c.BaseURL+path, body)
+	if err != nil {
+		fmt.Println("[xui_client] Ошибка создания запроса:", err)
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	req.Header.Set("Accept", "application/json")
+	req.AddCookie(&http.Cookie{
+		Name:  "3x-ui",
+		Value: c.Token,
+	})
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		fmt.Println("[xui_client] Ошибка отправки запроса:", err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	fmt.Println("[xui_client] Код ответа:", resp.StatusCode)
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("bad status: %s", resp.Status)
+	}
+
+	if len(respBody) == 0 {
+		fmt.Println("[xui_client] Получен пустой ответ от сервера:", path)
+		return nil, errors.New("empty response from server")
+	}
+
+	var result apiResponse
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		fmt.Println("[xui_client] Ошибка декодирования JSON:", err)
+		return nil, fmt.Errorf("JSON decode error: %v", err)
+	}
+
+	if !result.Success {
+		fmt.Println("[xui_client] Запрос не успешен:", result.Msg)
+		return nil, fmt.Errorf("request not successful: %s", result.Msg)
+	}
+
+	return result.Obj, nil
+}
@@ -0,0 +1,200 @@
+package xui_client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// InboundClient клиент внутри settings.clients inbound
+type InboundClient struct {
+	ID         string `json:"id,omitempty"`       // UUID для VLESS/VMess
+	Password   string `json:"password,omitempty"` // пароль для Trojan/Shadowsocks
+	Method     string `json:"method,omitempty"`   // метод шифрования Shadowsocks
+	Flow       string `json:"flow"`
+	Email      string `json:"email"`
+	LimitIP    int    `json:"limitIp"`
+	TotalGB    int64  `json:"totalGB"` // лимит трафика в байтах (название поля из панели)
+	ExpiryTime int64  `json:"expiryTime"`
+	Enable     bool   `json:"enable"`
+	TgID       int64  `json:"tgId"`
+	SubID      string `json:"subId"`
+	Comment    string `json:"comment"`
+	Reset      int    `json:"reset"`
+}
+
+// UnmarshalJSON допускает tgId как числом, так и строкой:
+// старые inbound создавались с "tgId":"".
+func (c *InboundClient) UnmarshalJSON(data []byte) error {
+	type alias InboundClient
+	aux := struct {
+		*alias
+		TgID json.RawMessage `json:"tgId"`
+	}{alias: (*alias)(c)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	c.TgID = 0
+	raw := strings.Trim(string(aux.TgID), `"`)
+	if raw != "" && raw != "null" {
+		tgID, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid tgId %s: %v", string(aux.TgID), err)
+		}
+		c.TgID = tgID
+	}
+	return nil
+}
+
+// Key возвращает идентификатор клиента, который панель ожидает в updateClient/delClient:
+// UUID для VLESS/VMess, пароль для Trojan и email для Shadowsocks
+func (c *InboundClient) Key(protocol string) string {
+	switch protocol {
+	case "trojan":
+		return c.Password
+	case "shadowsocks":
+		return c.Email
+	default:
+		return c.ID
+	}
+}
+
+// InboundSettings разобранное поле settings inbound
+type InboundSettings struct {
+	Clients    []InboundClient `json:"clients"`
+	Decryption string          `json:"decryption,omitempty"`
+	Method     string          `json:"method,omitempty"`   // Shadowsocks
+	Password   string          `json:"password,omitempty"` // Shadowsocks 2022
+	Network    string          `json:"network,omitempty"`  // Shadowsocks
+}
+
+// ParseInboundSettings разбирает JSON-строку settings inbound
+func ParseInboundSettings(settings string) (*InboundSettings, error) {
+	parsed := &InboundSettings{}
+	if strings.TrimSpace(settings) == "" {
+		return parsed, nil
+	}
+	if err := json.Unmarshal([]byte(settings), parsed); err != nil {
+		return nil, fmt.Errorf("invalid inbound settings: %v", err)
+	}
+	return parsed, nil
+}
+
+// Clients возвращает клиентов inbound
+func (i *Inbound) Clients() ([]InboundClient, error) {
+	settings, err := ParseInboundSettings(i.Settings)
+	if err != nil {
+		return nil, err
+	}
+	return settings.Clients, nil
+}
+
+// FindClient ищет клиента inbound по email
+func (i *Inbound) FindClient(email string) (*InboundClient, error) {
+	clients, err := i.Clients()
+	if err != nil {
+		return nil, err
+	}
+	for idx := range clients {
+		if clients[idx].Email == email {
+			return &clients[idx], nil
+		}
+	}
+	return nil, fmt.Errorf("client %s not found in inbound %d", email, i.ID)
+}
+
+// ClientSettingsJSON формирует settings вида {"clients":[...]} для addClient/updateClient
+func ClientSettingsJSON(clients ...InboundClient) (string, error) {
+	data, err := json.Marshal(struct {
+		Clients []InboundClient `json:"clients"`
+	}{Clients: clients})
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// UpdateClient заменяет параметры клиента inbound.
+// clientKey — UUID (VLESS/VMess), пароль (Trojan) или email (Shadowsocks), см. InboundClient.Key
+func (c *Client) UpdateClient(inboundID int, clientKey string, client InboundClient) error {
+	settings, err := ClientSettingsJSON(client)
+	if err != nil {
+		return fmt.Errorf("update client: %w", err)
+	}
+
+	data := url.Values{}
+	data.Set("id", strconv.Itoa(inboundID))
+	data.Set("settings", settings)
+
+	path := "/panel/api/inbounds/updateClient/" + url.PathEscape(clientKey)
+	if _, err := c.doAPIRequest("POST", path, strings.NewReader(data.Encode())); err != nil {
+		return fmt.Errorf("update client %s: %w", clientKey, err)
+	}
+
+	fmt.Println("[xui_client] Клиент обновлен:", client.Email)
+	return nil
+}
+
+// DeleteClient удаляет клиента из inbound
+func (c *Client) DeleteClient(inboundID int, clientKey string) error {
+	path := fmt.Sprintf("/panel/api/inbounds/%d/delClient/%s", inboundID, url.PathEscape(clientKey))
+	if _, err := c.doAPIRequest("POST", path, nil); err != nil {
+		return fmt.Errorf("delete client %s: %w", clientKey, err)
+	}
+
+	fmt.Println("[xui_client] Клиент удален:", clientKey)
+	return nil
+}
+
+// ResetClientTraffic обнуляет счетчики трафика клиента
+func (c *Client) ResetClientTraffic(inboundID int, email string) error {
+	path := fmt.Sprintf("/panel/api/inbounds/%d/resetClientTraffic/%s", inboundID, url.PathEscape(email))
+	if _, err := c.doAPIRequest("POST", path, nil); err != nil {
+		return fmt.Errorf("reset client traffic %s: %w", email, err)
+	}
+
+	fmt.Println("[xui_client] Трафик клиента сброшен:", email)
+	return nil
+}
+
+// GetClientTrafficsByEmail возвращает статистику трафика клиента по email
+func (c *Client) GetClientTrafficsByEmail(email string) (*ClientTraffic, error) {
+	obj, err := c.doAPIRequest("GET", "/panel/api/inbounds/getClientTraffics/"+url.PathEscape(email), nil)
+	if err != nil {
+		return nil, fmt.Errorf("get client traffics %s: %w", email, err)
+	}
+
+	if len(obj) == 0 || string(obj) == "null" {
+		return nil, fmt.Errorf("client traffics for %s not found", email)
+	}
+
+	traffic := &ClientTraffic{}
+	if err := json.Unmarshal(obj, traffic); err != nil {
+		fmt.Println("[xui_client] Ошибка декодирования трафика клиента:", err)
+		return nil, fmt.Errorf("JSON decode error: %v", err)
+	}
+
+	return traffic, nil
+}
+
+// GetClientTrafficsByID возвращает статистику трафика по UUID клиента
+func (c *Client) GetClientTrafficsByID(clientID string) ([]ClientTraffic, error) {
+	obj, err := c.doAPIRequest("GET", "/panel/api/inbounds/getClientTrafficsById/"+url.PathEscape(clientID), nil)
+	if err != nil {
+		return nil, fmt.Errorf("get client traffics by id %s: %w", clientID, err)
+	}
+
+	var traffics []ClientTraffic
+	if len(obj) == 0 || string(obj) == "null" {
+		return traffics, nil
+	}
+	if err := json.Unmarshal(obj, &traffics); err != nil {
+		fmt.Println("[xui_client] Ошибка декодирования трафика клиента:", err)
+		return nil, fmt.Errorf("JSON decode error: %v", err)
+	}
+
+	return traffics, nil
+}
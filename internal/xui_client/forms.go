@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"math/rand"
 	"net/url"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -32,7 +33,7 @@ func (f *InboundAddForm) ToFormData() string {
 }
 
 // daysValid - срок действия в днях, если 0 или меньше, то бессрочно (expiryTime=0)
-func GenerateRandomInboundForm(daysValid int) *InboundAddForm {
+func GenerateRandomInboundForm(daysValid int, reality RealityOptions) *InboundAddForm {
 	rand.Seed(time.Now().UnixNano())
 	randomPort := 20000 + rand.Intn(40000)
 	randomId := uuid.New().String()
@@ -48,20 +49,20 @@ func GenerateRandomInboundForm(daysValid int) *InboundAddForm {
 		Protocol: "vless",
 		Settings: fmt.Sprintf(`{"clients":[{"id":"%s","flow":"","email":"%s","limitIp":0,"totalGB":0,"expiryTime":%d,"enable":true,"tgId":"","subId":"%s","comment":"","reset":0}],"decryption":"none","fallbacks":[]}`,
 			randomId, randomEmail, expiryTime, randomSubId),
-		StreamSettings: `{"network":"tcp","security":"reality","externalProxy":[],"realitySettings":{"show":false,"xver":0,"dest":"yahoo.com:443","serverNames":["yahoo.com","www.yahoo.com"],"privateKey":"SBLy12PtgIQhVDQk9kgQm9W3ubthkpKOiZsiSepo6WE","minClient":"","maxClient":"","maxTimediff":0,"shortIds":["cb1387d77a62","c1f87d4c98","ce5356c7748f66","fc97e9","19","a192c5c8","c9d6","3e2bb7312ad2e425"],"settings":{"publicKey":"RV7AJOzhT5kEBckDgTN7jDbmRFZEdg8M5xXmDQJNB1Y","fingerprint":"chrome","serverName":"","spiderX":"/"}},"tcpSettings":{"acceptProxyProtocol":true,"header":{"type":"http","request":{"version":"1.1","method":"GET","path":["/"],"headers":{}},"response":{"version":"1.1","status":"200","reason":"OK","headers":{}}}}}`,
+		StreamSettings: RealityStreamSettings(reality),
 		Sniffing:       `{"enabled":false,"destOverride":["http","tls","quic","fakedns"],"metadataOnly":false,"routeOnly":false}`,
 		Enable:         "true",
 	}
 }
 
-// Генерация inbound без пользователей
-func GenerateEmptyInboundForm(port int, remark string) *InboundAddForm {
+// Генерация inbound без пользователей с ключами REALITY конкретного хоста
+func GenerateEmptyInboundForm(port int, remark string, reality RealityOptions) *InboundAddForm {
 	return &InboundAddForm{
 		Remark:         remark,
 		Port:           fmt.Sprintf("%d", port),
 		Protocol:       "vless",
 		Settings:       `{"clients":[],"decryption":"none","fallbacks":[]}`,
-		StreamSettings: `{"network":"tcp","security":"reality","externalProxy":[],"realitySettings":{"show":false,"xver":0,"dest":"yahoo.com:443","serverNames":["yahoo.com","www.yahoo.com"],"privateKey":"SBLy12PtgIQhVDQk9kgQm9W3ubthkpKOiZsiSepo6WE","minClient":"","maxClient":"","maxTimediff":0,"shortIds":["cb1387d77a62","c1f87d4c98","ce5356c7748f66","fc97e9","19","a192c5c8","c9d6","3e2bb7312ad2e425"],"settings":{"publicKey":"RV7AJOzhT5kEBckDgTN7jDbmRFZEdg8M5xXmDQJNB1Y","fingerprint":"chrome","serverName":"","spiderX":"/"}},"tcpSettings":{"acceptProxyProtocol":true,"header":{"type":"http","request":{"version":"1.1","method":"GET","path":["/"],"headers":{}},"response":{"version":"1.1","status":"200","reason":"OK","headers":{}}}}}`,
+		StreamSettings: RealityStreamSettings(reality),
 		Sniffing:       `{"enabled":false,"destOverride":["http","tls","quic","fakedns"],"metadataOnly":false,"routeOnly":false}`,
 		Enable:         "true",
 	}
@@ -85,6 +86,22 @@ func GenerateClientSettings(id, email, subId string, totalGB int64, expiryTime i
 		id, email, totalGB, expiryTime, tgId, subId)
 }
 
+// NewInboundClient создает клиента с новыми UUID и subId.
+// totalBytes и expiryTime (мс) равные 0 означают отсутствие ограничения.
+func NewInboundClient(email string, tgID int64, totalBytes int64, expiryTime int64, limitIP int, flow string) InboundClient {
+	return InboundClient{
+		ID:         uuid.New().String(),
+		Flow:       flow,
+		Email:      email,
+		LimitIP:    limitIP,
+		TotalGB:    totalBytes,
+		ExpiryTime: expiryTime,
+		Enable:     true,
+		TgID:       tgID,
+		SubID:      strings.ReplaceAll(uuid.New().String(), "-", "")[:16],
+	}
+}
+
 // Генерация JSON settings для одного случайного пользователя
 func GenerateRandomClientSettings(expiryDays int) (clientId, email, subId string, settings string) {
 	rand.Seed(time.Now().UnixNano())
@@ -0,0 +1,142 @@
+package xui_client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ClientTraffic статистика трафика клиента inbound (clientStats в ответе панели)
+type ClientTraffic struct {
+	ID         int    `json:"id"`
+	InboundID  int    `json:"inboundId"`
+	Enable     bool   `json:"enable"`
+	Email      string `json:"email"`
+	Up         int64  `json:"up"`
+	Down       int64  `json:"down"`
+	ExpiryTime int64  `json:"expiryTime"`
+	Total      int64  `json:"total"`
+	Reset      int    `json:"reset"`
+}
+
+// Inbound описывает inbound панели 3x-ui.
+// Settings, StreamSettings и Sniffing панель хранит и отдает как JSON-строки.
+type Inbound struct {
+	ID             int             `json:"id"`
+	Up             int64           `json:"up"`
+	Down           int64           `json:"down"`
+	Total          int64           `json:"total"`
+	Remark         string          `json:"remark"`
+	Enable         bool            `json:"enable"`
+	ExpiryTime     int64           `json:"expiryTime"`
+	ClientStats    []ClientTraffic `json:"clientStats"`
+	Listen         string          `json:"listen"`
+	Port           int             `json:"port"`
+	Protocol       string          `json:"protocol"`
+	Settings       string          `json:"settings"`
+	StreamSettings string          `json:"streamSettings"`
+	Tag            string          `json:"tag"`
+	Sniffing       string          `json:"sniffing"`
+}
+
+// ToFormData кодирует inbound в формат, который принимает /panel/api/inbounds/update/:id
+func (i *Inbound) ToFormData() string {
+	data := url.Values{}
+	data.Set("up", strconv.FormatInt(i.Up, 10))
+	data.Set("down", strconv.FormatInt(i.Down, 10))
+	data.Set("total", strconv.FormatInt(i.Total, 10))
+	data.Set("remark", i.Remark)
+	data.Set("enable", strconv.FormatBool(i.Enable))
+	data.Set("expiryTime", strconv.FormatInt(i.ExpiryTime, 10))
+	data.Set("listen", i.Listen)
+	data.Set("port", strconv.Itoa(i.Port))
+	data.Set("protocol", i.Protocol)
+	data.Set("settings", i.Settings)
+	data.Set("streamSettings", i.StreamSettings)
+	data.Set("tag", i.Tag)
+	data.Set("sniffing", i.Sniffing)
+	return data.Encode()
+}
+
+// ListInbounds возвращает все inbound панели
+func (c *Client) ListInbounds() ([]Inbound, error) {
+	obj, err := c.doAPIRequest("GET", "/panel/api/inbounds/list", nil)
+	if err != nil {
+		return nil, fmt.Errorf("list inbounds: %w", err)
+	}
+
+	var inbounds []Inbound
+	if len(obj) == 0 || string(obj) == "null" {
+		return inbounds, nil
+	}
+	if err := json.Unmarshal(obj, &inbounds); err != nil {
+		fmt.Println("[xui_client] Ошибка декодирования списка inbound:", err)
+		return nil, fmt.Errorf("JSON decode error: %v", err)
+	}
+
+	fmt.Println("[xui_client] Получено inbound:", len(inbounds))
+	return inbounds, nil
+}
+
+// GetInbound возвращает inbound по его ID
+func (c *Client) GetInbound(id int) (*Inbound, error) {
+	obj, err := c.doAPIRequest("GET", fmt.Sprintf("/panel/api/inbounds/get/%d", id), nil)
+	if err != nil {
+		return nil, fmt.Errorf("get inbound %d: %w", id, err)
+	}
+
+	if len(obj) == 0 || string(obj) == "null" {
+		return nil, fmt.Errorf("inbound %d not found", id)
+	}
+
+	inbound := &Inbound{}
+	if err := json.Unmarshal(obj, inbound); err != nil {
+		fmt.Println("[xui_client] Ошибка декодирования inbound:", err)
+		return nil, fmt.Errorf("JSON decode error: %v", err)
+	}
+
+	return inbound, nil
+}
+
+// UpdateInbound сохраняет изменения inbound на панели.
+// Передавайте inbound, полученный через GetInbound: панель перезаписывает все поля.
+func (c *Client) UpdateInbound(inbound *Inbound) error {
+	if inbound == nil || inbound.ID == 0 {
+		return fmt.Errorf("update inbound: inbound id is required")
+	}
+
+	_, err := c.doAPIRequest("POST", fmt.Sprintf("/panel/api/inbounds/update/%d", inbound.ID), strings.NewReader(inbound.ToFormData()))
+	if err != nil {
+		return fmt.Errorf("update inbound %d: %w", inbound.ID, err)
+	}
+
+	return nil
+}
+
+// SetInboundEnable включает или отключает inbound
+func (c *Client) SetInboundEnable(id int, enable bool) error {
+	inbound, err := c.GetInbound(id)
+	if err != nil {
+		return err
+	}
+
+	if inbound.Enable == enable {
+		return nil
+	}
+
+	inbound.Enable = enable
+	return c.UpdateInbound(inbound)
+}
+
+// DeleteInbound удаляет inbound вместе со всеми его клиентами
+func (c *Client) DeleteInbound(id int) error {
+	_, err := c.doAPIRequest("POST", fmt.Sprintf("/panel/api/inbounds/del/%d", id), nil)
+	if err != nil {
+		return fmt.Errorf("delete inbound %d: %w", id, err)
+	}
+
+	fmt.Println("[xui_client] Inbound удален:", id)
+	return nil
+}
@@ -0,0 +1,209 @@
+package xui_client
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// BuildShareLink формирует ссылку для импорта в клиентское приложение
+// (vless://, vmess://, trojan://, ss://) на основе фактических настроек inbound.
+// address — адрес сервера, к которому подключается клиент; remark — подпись ссылки.
+func BuildShareLink(inbound *Inbound, client *InboundClient, address, remark string) (string, error) {
+	if inbound == nil || client == nil {
+		return "", fmt.Errorf("build share link: inbound and client are required")
+	}
+
+	stream, err := ParseStreamSettings(inbound.StreamSettings)
+	if err != nil {
+		return "", err
+	}
+
+	hostPort := net.JoinHostPort(address, strconv.Itoa(inbound.Port))
+
+	switch inbound.Protocol {
+	case "vless":
+		params := streamParams(stream)
+		params.Set("encryption", "none")
+		if client.Flow != "" && stream.Network == "tcp" && (stream.Security == "tls" || stream.Security == "reality") {
+			params.Set("flow", client.Flow)
+		}
+		return fmt.Sprintf("vless://%s@%s?%s#%s", client.ID, hostPort, params.Encode(), url.PathEscape(remark)), nil
+
+	case "trojan":
+		params := streamParams(stream)
+		return fmt.Sprintf("trojan://%s@%s?%s#%s", url.PathEscape(client.Password), hostPort, params.Encode(), url.PathEscape(remark)), nil
+
+	case "vmess":
+		return buildVMessLink(stream, client, address, inbound.Port, remark)
+
+	case "shadowsocks":
+		settings, err := ParseInboundSettings(inbound.Settings)
+		if err != nil {
+			return "", err
+		}
+		method := settings.Method
+		password := client.Password
+		if strings.HasPrefix(method, "2022") {
+			// Для Shadowsocks 2022 клиенту нужен пароль сервера и пароль пользователя
+			if settings.Password != "" {
+				password = settings.Password + ":" + client.Password
+			}
+		} else if client.Method != "" {
+			method = client.Method
+		}
+		userInfo := base64.RawURLEncoding.EncodeToString([]byte(method + ":" + password))
+		return fmt.Sprintf("ss://%s@%s#%s", userInfo, hostPort, url.PathEscape(remark)), nil
+
+	default:
+		return "", fmt.Errorf("build share link: unsupported protocol %q", inbound.Protocol)
+	}
+}
+
+// streamParams формирует общие параметры транспорта и безопасности для vless:// и trojan://
+func streamParams(stream *StreamSettings) url.Values {
+	params := url.Values{}
+	params.Set("type", stream.Network)
+
+	switch stream.Network {
+	case "tcp":
+		if stream.TCPSettings != nil && stream.TCPSettings.Header.Type == "http" {
+			params.Set("headerType", "http")
+			if req := stream.TCPSettings.Header.Request; req != nil {
+				if len(req.Path) > 0 {
+					params.Set("path", strings.Join(req.Path, ","))
+				}
+				if host := headerHost(req.Headers); host != "" {
+					params.Set("host", host)
+				}
+			}
+		}
+	case "kcp":
+		if stream.KCPSettings != nil {
+			params.Set("headerType", stream.KCPSettings.Header.Type)
+			if stream.KCPSettings.Seed != "" {
+				params.Set("seed", stream.KCPSettings.Seed)
+			}
+		}
+	case "ws":
+		if stream.WSSettings != nil {
+			params.Set("path", stream.WSSettings.Path)
+			if host := wsHost(stream.WSSettings); host != "" {
+				params.Set("host", host)
+			}
+		}
+	case "grpc":
+		if stream.GRPCSettings != nil {
+			params.Set("serviceName", stream.GRPCSettings.ServiceName)
+			if stream.GRPCSettings.Authority != "" {
+				params.Set("authority", stream.GRPCSettings.Authority)
+			}
+			if stream.GRPCSettings.MultiMode {
+				params.Set("mode", "multi")
+			}
+		}
+	case "httpupgrade":
+		if stream.HTTPUpgradeSettings != nil {
+			params.Set("path", stream.HTTPUpgradeSettings.Path)
+			if stream.HTTPUpgradeSettings.Host != "" {
+				params.Set("host", stream.HTTPUpgradeSettings.Host)
+			}
+		}
+	case "xhttp":
+		if stream.XHTTPSettings != nil {
+			params.Set("path", stream.XHTTPSettings.Path)
+			if stream.XHTTPSettings.Host != "" {
+				params.Set("host", stream.XHTTPSettings.Host)
+			}
+			if stream.XHTTPSettings.Mode != "" {
+				params.Set("mode", stream.XHTTPSettings.Mode)
+			}
+		}
+	}
+
+	params.Set("security", stream.Security)
+	switch stream.Security {
+	case "tls":
+		if tls := stream.TLSSettings; tls != nil {
+			if tls.ServerName != "" {
+				params.Set("sni", tls.ServerName)
+			}
+			if tls.Settings.Fingerprint != "" {
+				params.Set("fp", tls.Settings.Fingerprint)
+			}
+			if len(tls.ALPN) > 0 {
+				params.Set("alpn", strings.Join(tls.ALPN, ","))
+			}
+			if tls.Settings.AllowInsecure {
+				params.Set("allowInsecure", "1")
+			}
+		}
+	case "reality":
+		if reality := stream.RealitySettings; reality != nil {
+			params.Set("pbk", reality.Settings.PublicKey)
+			if reality.Settings.Fingerprint != "" {
+				params.Set("fp", reality.Settings.Fingerprint)
+			}
+			sni := reality.Settings.ServerName
+			if sni == "" && len(reality.ServerNames) > 0 {
+				sni = reality.ServerNames[0]
+			}
+			if sni != "" {
+				params.Set("sni", sni)
+			}
+			if len(reality.ShortIDs) > 0 {
+				params.Set("sid", reality.ShortIDs[0])
+			}
+			if reality.Settings.SpiderX != "" {
+				params.Set("spx", reality.Settings.SpiderX)
+			}
+		}
+	}
+
+	return params
+}
+
+// buildVMessLink формирует vmess:// ссылку в формате v2rayN (base64 JSON)
+func buildVMessLink(stream *StreamSettings, client *InboundClient, address string, port int, remark string) (string, error) {
+	params := streamParams(stream)
+
+	link := map[string]interface{}{
+		"v":    "2",
+		"ps":   remark,
+		"add":  address,
+		"port": port,
+		"id":   client.ID,
+		"aid":  0,
+		"scy":  "auto",
+		"net":  stream.Network,
+		"type": "none",
+		"host": params.Get("host"),
+		"path": params.Get("path"),
+		"tls":  "",
+	}
+	if headerType := params.Get("headerType"); headerType != "" {
+		link["type"] = headerType
+	}
+	if stream.Network == "grpc" {
+		link["path"] = params.Get("serviceName")
+		if params.Get("mode") == "multi" {
+			link["type"] = "multi"
+		}
+	}
+	if stream.Security == "tls" {
+		link["tls"] = "tls"
+		link["sni"] = params.Get("sni")
+		link["fp"] = params.Get("fp")
+		link["alpn"] = params.Get("alpn")
+	}
+
+	data, err := json.Marshal(link)
+	if err != nil {
+		return "", fmt.Errorf("build vmess link: %v", err)
+	}
+	return "vmess://" + base64.StdEncoding.EncodeToString(data), nil
+}
@@ -0,0 +1,133 @@
+package xui_client
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// RealityKeyGenerator генерирует ключи x25519 и shortIds для REALITY
+type RealityKeyGenerator interface {
+	GenerateKeyPair() (privateKey, publicKey string, err error)
+	GenerateShortIDs(count int) ([]string, error)
+}
+
+// readerRealityGenerator генерирует ключи из произвольного источника случайных байт
+type readerRealityGenerator struct {
+	rand io.Reader
+}
+
+// NewRealityKeyGenerator создает генератор на основе источника r.
+// Если r == nil, используется crypto/rand; для детерминированных ключей передайте свой io.Reader.
+func NewRealityKeyGenerator(r io.Reader) RealityKeyGenerator {
+	if r == nil {
+		r = rand.Reader
+	}
+	return &readerRealityGenerator{rand: r}
+}
+
+// GenerateKeyPair возвращает пару ключей x25519 в формате xray (base64 URL без паддинга)
+func (g *readerRealityGenerator) GenerateKeyPair() (string, string, error) {
+	seed := make([]byte, 32)
+	if _, err := io.ReadFull(g.rand, seed); err != nil {
+		return "", "", fmt.Errorf("generate x25519 key: %v", err)
+	}
+
+	// Клэмпинг как в `xray x25519`
+	seed[0] &= 248
+	seed[31] &= 127
+	seed[31] |= 64
+
+	privateKey, err := ecdh.X25519().NewPrivateKey(seed)
+	if err != nil {
+		return "", "", fmt.Errorf("generate x25519 key: %v", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(privateKey.Bytes()),
+		base64.RawURLEncoding.EncodeToString(privateKey.PublicKey().Bytes()), nil
+}
+
+// GenerateShortIDs возвращает count hex-строк длиной от 2 до 16 символов
+func (g *readerRealityGenerator) GenerateShortIDs(count int) ([]string, error) {
+	shortIDs := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		var size [1]byte
+		if _, err := io.ReadFull(g.rand, size[:]); err != nil {
+			return nil, fmt.Errorf("generate short id: %v", err)
+		}
+		buf := make([]byte, 1+int(size[0])%8)
+		if _, err := io.ReadFull(g.rand, buf); err != nil {
+			return nil, fmt.Errorf("generate short id: %v", err)
+		}
+		shortIDs = append(shortIDs, hex.EncodeToString(buf))
+	}
+	return shortIDs, nil
+}
+
+// RealityOptions параметры REALITY для создаваемого inbound
+type RealityOptions struct {
+	PrivateKey  string
+	PublicKey   string
+	ShortIDs    []string
+	Dest        string
+	ServerNames []string
+	Fingerprint string
+}
+
+// RealityStreamSettings формирует streamSettings TCP+REALITY с HTTP-маскировкой заголовка
+func RealityStreamSettings(opts RealityOptions) string {
+	fingerprint := opts.Fingerprint
+	if fingerprint == "" {
+		fingerprint = "chrome"
+	}
+	serverNames := opts.ServerNames
+	if serverNames == nil {
+		serverNames = []string{}
+	}
+	shortIDs := opts.ShortIDs
+	if shortIDs == nil {
+		shortIDs = []string{}
+	}
+
+	stream := StreamSettings{
+		Network:       "tcp",
+		Security:      "reality",
+		ExternalProxy: []interface{}{},
+		RealitySettings: &RealitySettings{
+			Dest:        opts.Dest,
+			ServerNames: serverNames,
+			PrivateKey:  opts.PrivateKey,
+			ShortIDs:    shortIDs,
+			Settings: RealityClientSettings{
+				PublicKey:   opts.PublicKey,
+				Fingerprint: fingerprint,
+				SpiderX:     "/",
+			},
+		},
+		TCPSettings: &TCPSettings{
+			AcceptProxyProtocol: true,
+			Header: TCPHeader{
+				Type: "http",
+				Request: &TCPHeaderRequest{
+					Version: "1.1",
+					Method:  "GET",
+					Path:    []string{"/"},
+					Headers: map[string][]string{},
+				},
+				Response: &TCPHeaderResponse{
+					Version: "1.1",
+					Status:  "200",
+					Reason:  "OK",
+					Headers: map[string][]string{},
+				},
+			},
+		},
+	}
+
+	data, _ := json.Marshal(stream)
+	return string(data)
+}
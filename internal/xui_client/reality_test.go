@@ -0,0 +1,89 @@
+package xui_client
+
+import (
+	"crypto/ecdh"
+	"encoding/base64"
+	"encoding/hex"
+	"math/rand"
+	"testing"
+)
+
+// fixedRealityGenerator генератор с фиксированным seed: ключи одинаковы при каждом запуске
+func fixedRealityGenerator() RealityKeyGenerator {
+	return NewRealityKeyGenerator(rand.New(rand.NewSource(42)))
+}
+
+func TestGenerateKeyPairDeterministic(t *testing.T) {
+	privateKey, publicKey, err := fixedRealityGenerator().GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("GenerateKeyPair: %v", err)
+	}
+
+	const wantPrivate = "UIx_lrFkvxuXu59LtHLon1sUhPJSCcnZND6SugndnVI"
+	const wantPublic = "zGlHRqj3XcTZ3NY2M_nwGl9K_5PYegwsKkFSzdsYxUI"
+	if privateKey != wantPrivate || publicKey != wantPublic {
+		t.Fatalf("ключи изменились: private=%s public=%s", privateKey, publicKey)
+	}
+
+	again, againPublic, err := fixedRealityGenerator().GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("GenerateKeyPair: %v", err)
+	}
+	if again != privateKey || againPublic != publicKey {
+		t.Fatalf("тот же seed дал другие ключи: %s/%s и %s/%s", privateKey, publicKey, again, againPublic)
+	}
+}
+
+func TestGenerateKeyPairPublicKeyMatchesPrivate(t *testing.T) {
+	privateKey, publicKey, err := fixedRealityGenerator().GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("GenerateKeyPair: %v", err)
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(privateKey)
+	if err != nil {
+		t.Fatalf("приватный ключ не в base64 URL: %v", err)
+	}
+	if len(raw) != 32 {
+		t.Fatalf("длина приватного ключа %d, ожидалось 32", len(raw))
+	}
+	if raw[0]&7 != 0 || raw[31]&128 != 0 || raw[31]&64 == 0 {
+		t.Fatalf("приватный ключ не клэмпирован: %x", raw)
+	}
+
+	key, err := ecdh.X25519().NewPrivateKey(raw)
+	if err != nil {
+		t.Fatalf("NewPrivateKey: %v", err)
+	}
+	if derived := base64.RawURLEncoding.EncodeToString(key.PublicKey().Bytes()); derived != publicKey {
+		t.Fatalf("публичный ключ %s не соответствует приватному (ожидалось %s)", publicKey, derived)
+	}
+}
+
+func TestGenerateShortIDs(t *testing.T) {
+	shortIDs, err := fixedRealityGenerator().GenerateShortIDs(8)
+	if err != nil {
+		t.Fatalf("GenerateShortIDs: %v", err)
+	}
+	if len(shortIDs) != 8 {
+		t.Fatalf("получено %d shortIds, ожидалось 8", len(shortIDs))
+	}
+	for _, id := range shortIDs {
+		if len(id) < 2 || len(id) > 16 || len(id)%2 != 0 {
+			t.Errorf("shortId %q: длина %d, ожидалась четная от 2 до 16", id, len(id))
+		}
+		if _, err := hex.DecodeString(id); err != nil {
+			t.Errorf("shortId %q не hex: %v", id, err)
+		}
+	}
+
+	again, err := fixedRealityGenerator().GenerateShortIDs(8)
+	if err != nil {
+		t.Fatalf("GenerateShortIDs: %v", err)
+	}
+	for i := range shortIDs {
+		if again[i] != shortIDs[i] {
+			t.Fatalf("тот же seed дал другие shortIds: %v и %v", shortIDs, again)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package xui_client
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// StreamSettings разобранное поле streamSettings inbound
+type StreamSettings struct {
+	Network             string               `json:"network"`
+	Security            string               `json:"security"`
+	ExternalProxy       []interface{}        `json:"externalProxy"`
+	RealitySettings     *RealitySettings     `json:"realitySettings,omitempty"`
+	TLSSettings         *TLSSettings         `json:"tlsSettings,omitempty"`
+	TCPSettings         *TCPSettings         `json:"tcpSettings,omitempty"`
+	KCPSettings         *KCPSettings         `json:"kcpSettings,omitempty"`
+	WSSettings          *WSSettings          `json:"wsSettings,omitempty"`
+	GRPCSettings        *GRPCSettings        `json:"grpcSettings,omitempty"`
+	HTTPUpgradeSettings *HTTPUpgradeSettings `json:"httpupgradeSettings,omitempty"`
+	XHTTPSettings       *XHTTPSettings       `json:"xhttpSettings,omitempty"`
+}
+
+// RealitySettings серверные настройки REALITY
+type RealitySettings struct {
+	Show        bool                  `json:"show"`
+	Xver        int                   `json:"xver"`
+	Dest        string                `json:"dest"`
+	ServerNames []string              `json:"serverNames"`
+	PrivateKey  string                `json:"privateKey"`
+	MinClient   string                `json:"minClient"`
+	MaxClient   string                `json:"maxClient"`
+	MaxTimediff int                   `json:"maxTimediff"`
+	ShortIDs    []string              `json:"shortIds"`
+	Settings    RealityClientSettings `json:"settings"`
+}
+
+// RealityClientSettings параметры REALITY, которые панель отдает клиентам
+type RealityClientSettings struct {
+	PublicKey   string `json:"publicKey"`
+	Fingerprint string `json:"fingerprint"`
+	ServerName  string `json:"serverName"`
+	SpiderX     string `json:"spiderX"`
+}
+
+// TLSSettings настройки TLS
+type TLSSettings struct {
+	ServerName string            `json:"serverName"`
+	ALPN       []string          `json:"alpn"`
+	Settings   TLSClientSettings `json:"settings"`
+}
+
+// TLSClientSettings параметры TLS, которые панель отдает клиентам
+type TLSClientSettings struct {
+	AllowInsecure bool   `json:"allowInsecure"`
+	Fingerprint   string `json:"fingerprint"`
+}
+
+// TCPSettings настройки транспорта TCP
+type TCPSettings struct {
+	AcceptProxyProtocol bool      `json:"acceptProxyProtocol"`
+	Header              TCPHeader `json:"header"`
+}
+
+// TCPHeader маскировка заголовка TCP (none или http)
+type TCPHeader struct {
+	Type     string             `json:"type"`
+	Request  *TCPHeaderRequest  `json:"request,omitempty"`
+	Response *TCPHeaderResponse `json:"response,omitempty"`
+}
+
+// TCPHeaderRequest HTTP-запрос для маскировки TCP
+type TCPHeaderRequest struct {
+	Version string              `json:"version"`
+	Method  string              `json:"method"`
+	Path    []string            `json:"path"`
+	Headers map[string][]string `json:"headers"`
+}
+
+// TCPHeaderResponse HTTP-ответ для маскировки TCP
+type TCPHeaderResponse struct {
+	Version string              `json:"version"`
+	Status  string              `json:"status"`
+	Reason  string              `json:"reason"`
+	Headers map[string][]string `json:"headers"`
+}
+
+// KCPSettings настройки транспорта mKCP
+type KCPSettings struct {
+	Header struct {
+		Type string `json:"type"`
+	} `json:"header"`
+	Seed string `json:"seed"`
+}
+
+// WSSettings настройки транспорта WebSocket
+type WSSettings struct {
+	Path    string            `json:"path"`
+	Host    string            `json:"host"`
+	Headers map[string]string `json:"headers"`
+}
+
+// GRPCSettings настройки транспорта gRPC
+type GRPCSettings struct {
+	ServiceName string `json:"serviceName"`
+	Authority   string `json:"authority"`
+	MultiMode   bool   `json:"multiMode"`
+}
+
+// HTTPUpgradeSettings настройки транспорта HTTPUpgrade
+type HTTPUpgradeSettings struct {
+	Path    string            `json:"path"`
+	Host    string            `json:"host"`
+	Headers map[string]string `json:"headers"`
+}
+
+// XHTTPSettings настройки транспорта XHTTP
+type XHTTPSettings struct {
+	Path string `json:"path"`
+	Host string `json:"host"`
+	Mode string `json:"mode"`
+}
+
+// ParseStreamSettings разбирает JSON-строку streamSettings inbound
+func ParseStreamSettings(streamSettings string) (*StreamSettings, error) {
+	parsed := &StreamSettings{}
+	if strings.TrimSpace(streamSettings) == "" {
+		parsed.Network = "tcp"
+		parsed.Security = "none"
+		return parsed, nil
+	}
+	if err := json.Unmarshal([]byte(streamSettings), parsed); err != nil {
+		return nil, fmt.Errorf("invalid stream settings: %v", err)
+	}
+	if parsed.Network == "" {
+		parsed.Network = "tcp"
+	}
+	if parsed.Security == "" {
+		parsed.Security = "none"
+	}
+	return parsed, nil
+}
+
+// headerHost возвращает первое значение заголовка Host (без учета регистра)
+func headerHost(headers map[string][]string) string {
+	for name, values := range headers {
+		if strings.EqualFold(name, "host") && len(values) > 0 {
+			return strings.Join(values, ",")
+		}
+	}
+	return ""
+}
+
+// wsHost возвращает host WebSocket из нового поля host или из заголовков
+func wsHost(ws *WSSettings) string {
+	if ws.Host != "" {
+		return ws.Host
+	}
+	for name, value := range ws.Headers {
+		if strings.EqualFold(name, "host") {
+			return value
+		}
+	}
+	return ""
+}